@@ -0,0 +1,145 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"golang.org/x/xerrors"
+)
+
+type role string
+
+const (
+	roleBuyer  role = "Buyer"
+	roleSeller role = "Seller"
+)
+
+type action string
+
+const (
+	actionCancel        action = "Cancel"
+	actionRequestCancel action = "RequestCancel"
+	actionReactivate    action = "Reactivate"
+	// actionPartialCancel は、スケジュール行の数量の一部キャンセルです。権限は actionCancel として検証する
+	actionPartialCancel action = "PartialCancel"
+)
+
+// cancelAction は、IsCancelled の指定に対応する操作を返します
+func cancelAction(isCancelled bool) action {
+	if isCancelled {
+		return actionCancel
+	}
+	return actionReactivate
+}
+
+// 買い手はキャンセルの依頼のみ、売り手はキャンセルとキャンセル取消の両方を行うことができる
+// 買い手の依頼は、売り手が確定するまでオーダーを更新しない
+var rolePermissions = map[role]map[action]bool{
+	roleBuyer: {
+		actionRequestCancel: true,
+	},
+	roleSeller: {
+		actionCancel:     true,
+		actionReactivate: true,
+	},
+}
+
+// authorize は、呼び出し元のビジネスパートナがオーダーの買い手か売り手かを判定し、要求された操作の権限を検証します
+// requestable の場合は、キャンセルの依頼ができればキャンセルを許可します（予約の登録）
+// orders は、キャンセル処理と同じトランザクション内で読み込むリポジトリです
+func (c *DPFMAPICaller) authorize(
+	orders OrdersRepository,
+	input *dpfm_api_input_reader.SDC,
+	accepter []string,
+	requestable bool,
+	log *logger.Logger,
+) error {
+	_, roles, err := partnerRoles(orders, input, log)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range requestedActions(input, accepter) {
+		permitted := false
+		for _, r := range roles {
+			if rolePermissions[r][a] || (requestable && a == actionCancel && rolePermissions[r][actionRequestCancel]) {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return xerrors.Errorf("business partner %d (%s) is not permitted to %s order %d", input.BusinessPartner, roles[0], a, input.Header.OrderID)
+		}
+	}
+	return nil
+}
+
+// partnerRoles は、呼び出し元のビジネスパートナのオーダーにおける役割を返します
+func partnerRoles(
+	orders OrdersRepository,
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.HeaderPartner, []role, error) {
+	headerPartner := orders.HeaderPartnerRead(input, log)
+	if headerPartner == nil {
+		return nil, nil, xerrors.Errorf("order %d: %w", input.Header.OrderID, errNotFound)
+	}
+
+	roles := make([]role, 0, 2)
+	if headerPartner.Buyer == input.BusinessPartner {
+		roles = append(roles, roleBuyer)
+	}
+	if headerPartner.Seller == input.BusinessPartner {
+		roles = append(roles, roleSeller)
+	}
+	if len(roles) == 0 {
+		return nil, nil, xerrors.Errorf("business partner %d is neither buyer nor seller of order %d", input.BusinessPartner, input.Header.OrderID)
+	}
+	return headerPartner, roles, nil
+}
+
+func requestedActions(
+	input *dpfm_api_input_reader.SDC,
+	accepter []string,
+) []action {
+	actions := make(map[action]struct{})
+	add := func(isCancelled *bool) {
+		if isCancelled == nil {
+			return
+		}
+		if *isCancelled {
+			actions[actionCancel] = struct{}{}
+		} else {
+			actions[actionReactivate] = struct{}{}
+		}
+	}
+	for _, a := range accepter {
+		switch a {
+		case "Header":
+			add(input.Header.IsCancelled)
+		case "Item":
+			for _, item := range input.Header.Item {
+				add(item.IsCancelled)
+			}
+		case "ItemScheduleLine":
+			for _, item := range input.Header.Item {
+				for _, itemScheduleLine := range item.ItemScheduleLine {
+					if itemScheduleLine.CancelledQuantityInBaseUnit != nil {
+						actions[actionCancel] = struct{}{}
+						continue
+					}
+					add(itemScheduleLine.IsCancelled)
+				}
+			}
+		}
+	}
+
+	res := make([]action, 0, len(actions))
+	for _, a := range []action{actionCancel, actionReactivate} {
+		if _, ok := actions[a]; ok {
+			res = append(res, a)
+		}
+	}
+	return res
+}
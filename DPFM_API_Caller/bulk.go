@@ -0,0 +1,53 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+)
+
+// bulkCancels は、複数のオーダーを 1 件ずつキャンセルし、オーダーごとの結果を返します
+// 1 件のオーダーが失敗しても、他のオーダーの処理は継続します
+func (c *DPFMAPICaller) bulkCancels(
+	input *dpfm_api_input_reader.SDC,
+	output *dpfm_api_output_formatter.SDC,
+	accepter []string,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.BulkCancellation {
+	return c.cancelOrders(input, output, input.BulkOrders, accepter, log)
+}
+
+func (c *DPFMAPICaller) cancelOrders(
+	input *dpfm_api_input_reader.SDC,
+	output *dpfm_api_output_formatter.SDC,
+	orders []dpfm_api_input_reader.Header,
+	accepter []string,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.BulkCancellation {
+	bulk := &dpfm_api_output_formatter.BulkCancellation{
+		OrderResult: make([]dpfm_api_output_formatter.OrderResult, 0, len(orders)),
+	}
+	for _, order := range orders {
+		orderInput := *input
+		orderInput.Header = order
+		orderInput.BulkOrders = nil
+		orderOutput := *output
+
+		res, err := c.cancels(&orderInput, &orderOutput, accepter, log)
+		result := dpfm_api_output_formatter.OrderResult{
+			OrderID: order.OrderID,
+			Result:  err == nil,
+			Message: res,
+		}
+		if err != nil {
+			log.Error("order %d: %+v", order.OrderID, err)
+			result.Error = err.Error()
+			bulk.Failed++
+		} else {
+			bulk.Succeeded++
+		}
+		bulk.OrderResult = append(bulk.OrderResult, result)
+	}
+	return bulk
+}
@@ -0,0 +1,71 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestBulkCancelsReturnResultPerOrder(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+	delivered := testOrder(decimal.NewFromInt(10), decimal.NewFromInt(10))
+	delivered.Header.OrderID = testOrderID + 1
+	delivered.Header.HeaderDeliveryStatus = getStringPtr("CL")
+	delivered.Item[0].OrderID = testOrderID + 1
+	delivered.ItemScheduleLine[0].OrderID = testOrderID + 1
+	c.orders.Seed(delivered)
+
+	input := &dpfm_api_input_reader.SDC{
+		RuntimeSessionID: "bulk",
+		BusinessPartner:  testSeller,
+		APIType:          "bulk-cancels",
+		Accepter:         []string{"Header"},
+		BulkOrders: []dpfm_api_input_reader.Header{
+			{OrderID: testOrderID, IsCancelled: getBoolPtr(true)},
+			{OrderID: testOrderID + 1, IsCancelled: getBoolPtr(true)},
+			{OrderID: testOrderID + 2, IsCancelled: getBoolPtr(true)},
+		},
+	}
+	res, errs := c.AsyncCancels(input.Accepter, input, &dpfm_api_output_formatter.SDC{}, c.log)
+	mustNoErrors(t, errs)
+	bulk, ok := res.(*dpfm_api_output_formatter.BulkCancellation)
+	if !ok {
+		t.Fatalf("bulk-cancels returned %T", res)
+	}
+	if bulk.Succeeded != 1 || bulk.Failed != 2 || len(bulk.OrderResult) != 3 {
+		t.Fatalf("bulk cancellation = %+v, want 1 succeeded and 2 failed", bulk)
+	}
+
+	want := []struct {
+		orderID int
+		result  bool
+	}{
+		{testOrderID, true},
+		{testOrderID + 1, false},
+		{testOrderID + 2, false},
+	}
+	for i, w := range want {
+		result := bulk.OrderResult[i]
+		if result.OrderID != w.orderID || result.Result != w.result {
+			t.Errorf("order result %d = %+v, want order %d result %v", i, result, w.orderID, w.result)
+		}
+		if !w.result && result.Error == "" {
+			t.Errorf("failed order %d has no error", w.orderID)
+		}
+	}
+	if message := bulk.OrderResult[1].Message; message == nil || message.CancellationPolicyViolation == nil {
+		t.Errorf("policy violations of order %d are not returned: %+v", testOrderID+1, message)
+	}
+
+	// 失敗したオーダーがあっても、他のオーダーはキャンセルされる
+	if !isTrue(c.header(t).IsCancelled) {
+		t.Errorf("order %d is not cancelled", testOrderID)
+	}
+	assertQuantity(t, "stock after bulk cancel", c.stock(t, testDate), 110)
+	header := c.orders.HeaderRead(&dpfm_api_input_reader.SDC{BusinessPartner: testSeller, Header: dpfm_api_input_reader.Header{OrderID: testOrderID + 1}}, c.log)
+	if header == nil || isTrue(header.IsCancelled) {
+		t.Errorf("order %d rejected by policy is cancelled", testOrderID+1)
+	}
+}
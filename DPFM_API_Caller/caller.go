@@ -5,28 +5,65 @@ import (
 	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
 	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
 	"data-platform-api-orders-cancels-rmq-kube/config"
+	"data-platform-api-orders-cancels-rmq-kube/metrics"
+	"strconv"
+	"time"
 
 	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
-	database "github.com/latonaio/golang-mysql-network-connector"
 	rabbitmq "github.com/latonaio/rabbitmq-golang-client-for-data-platform"
+	"github.com/shopspring/decimal"
 	"golang.org/x/xerrors"
 )
 
+// RMQClient は、存在性チェック・サブファンクション・sql-update-kube への問い合わせと、ドメインイベントの送信に使う RabbitMQ のクライアントです
+type RMQClient interface {
+	SessionKeepRequest(ctx context.Context, sendQueue string, payload interface{}) (rabbitmq.RabbitmqMessage, error)
+	Send(sendQueue string, payload interface{}) error
+}
+
 type DPFMAPICaller struct {
-	ctx  context.Context
-	conf *config.Conf
-	rmq  *rabbitmq.RabbitmqClient
-	db   *database.Mysql
+	ctx                  context.Context
+	conf                 *config.Conf
+	rmq                  RMQClient
+	orders               OrdersRepository
+	stocks               StockRepository
+	writer               SQLWriter
+	transactor           Transactor
+	idempotency          IdempotencyStore
+	scheduled            ScheduledCancellationStore
+	cancellationRequests CancellationRequestStore
+	massCancellations    MassCancellationStore
+	outbox               OutboxStore
+	stockLedger          StockLedgerStore
+	policies             []CancellationPolicy
+	orderLocks           *keyedMutex
+	stockLocks           *keyedMutex
+	outboxNotify         chan struct{}
+	massNotify           chan struct{}
 }
 
 func NewDPFMAPICaller(
-	conf *config.Conf, rmq *rabbitmq.RabbitmqClient, db *database.Mysql,
+	conf *config.Conf, rmq RMQClient, stores *Stores,
 ) *DPFMAPICaller {
 	return &DPFMAPICaller{
-		ctx:  context.Background(),
-		conf: conf,
-		rmq:  rmq,
-		db:   db,
+		ctx:                  context.Background(),
+		conf:                 conf,
+		rmq:                  rmq,
+		orders:               stores.Orders,
+		stocks:               stores.Stocks,
+		writer:               stores.Writer,
+		transactor:           stores.Transactor,
+		idempotency:          stores.Idempotency,
+		scheduled:            stores.Scheduled,
+		cancellationRequests: stores.CancellationRequests,
+		massCancellations:    stores.MassCancellations,
+		outbox:               stores.Outbox,
+		stockLedger:          stores.StockLedger,
+		outboxNotify:         make(chan struct{}, 1),
+		massNotify:           make(chan struct{}, 1),
+		policies:             defaultCancellationPolicies(),
+		orderLocks:           newKeyedMutex(),
+		stockLocks:           newKeyedMutex(),
 	}
 }
 
@@ -37,81 +74,281 @@ func (c *DPFMAPICaller) AsyncCancels(
 	log *logger.Logger,
 ) (interface{}, []error) {
 	var response interface{}
+	errs := make([]error, 0)
 	switch input.APIType {
 	case "cancels":
-		response = c.cancelSqlProcess(input, output, accepter, log)
+		if input.EffectiveDateTime != nil {
+			effectiveDateTime, err := parseEffectiveDateTime(*input.EffectiveDateTime)
+			if err != nil {
+				errs = append(errs, err)
+				break
+			}
+			// 実行日時が未来の場合は登録のみ行い、スケジューラが実行日時にキャンセルする
+			if effectiveDateTime.After(time.Now()) {
+				res, err := c.scheduleCancels(input, accepter, effectiveDateTime, log)
+				response = res
+				if err != nil {
+					errs = append(errs, err)
+				}
+				break
+			}
+		}
+		res, err := c.cancels(input, output, accepter, log)
+		response = res
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "cancels-preview":
+		// 更新は行わず、キャンセルした場合に変更される行と再計算後の在庫を返す
+		// 買い手のキャンセルは、cancels と同じく登録されるキャンセル依頼を返す
+		res, ok, err := c.requestCancellation(input, accepter, true, log)
+		if err == nil && !ok {
+			res, err = c.cancelSqlProcess(c.newDryRunSaga(input.RuntimeSessionID), input, output, accepter, log)
+		}
+		response = res
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "bulk-cancels":
+		response = c.bulkCancels(input, output, accepter, log)
+	case "mass-cancels", "mass-cancels-preview":
+		res, err := c.massCancels(input, input.APIType == "mass-cancels-preview", log)
+		response = res
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "mass-cancels-status":
+		res, err := c.massCancellationStatus(input)
+		response = res
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "scheduled-cancels-list":
+		res, err := c.scheduledCancellationList(input)
+		response = res
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "scheduled-cancels-revoke":
+		res, err := c.scheduledCancellationRevoke(input, log)
+		response = res
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "cancellation-requests-list":
+		res, err := c.cancellationRequestList(input)
+		response = res
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "cancellation-requests-confirm":
+		res, err := c.cancellationRequestConfirm(input, log)
+		response = res
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "cancellation-requests-reject":
+		res, err := c.cancellationRequestReject(input, log)
+		response = res
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "stock-movements":
+		res, err := c.stockMovements(input, log)
+		response = res
+		if err != nil {
+			errs = append(errs, err)
+		}
 	default:
 		log.Error("unknown api type %s", input.APIType)
 	}
-	return response, nil
+	return response, errs
+}
+
+func (c *DPFMAPICaller) cancels(
+	input *dpfm_api_input_reader.SDC,
+	output *dpfm_api_output_formatter.SDC,
+	accepter []string,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.Message, error) {
+	// 同じオーダーに対する処理は並行して実行しない
+	defer c.orderLocks.Lock(strconv.Itoa(input.Header.OrderID))()
+
+	// 再配送されたメッセージは前回の処理結果を返し、在庫の二重引当解除を防ぐ
+	// 同じ runtime_session_id で内容が異なる要求は、前回の処理結果を返さずにエラーとする
+	key := NewIdempotencyKey(input, accepter)
+	hash, err := payloadHash(input)
+	if err != nil {
+		return nil, err
+	}
+	processed, ok, err := c.idempotency.Load(key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if processed.PayloadHash != hash {
+			return nil, xerrors.Errorf("runtime_session_id %s, order %d: %w", input.RuntimeSessionID, input.Header.OrderID, errIdempotencyConflict)
+		}
+		log.Info("runtime_session_id %s is already processed", input.RuntimeSessionID)
+		return processed.Message, nil
+	}
+	// 買い手のキャンセルは依頼として登録し、売り手の確定時にキャンセルする
+	if requested, ok, err := c.requestCancellation(input, accepter, false, log); err != nil || ok {
+		return requested, err
+	}
+	s, err := c.newSaga(input.RuntimeSessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.rollback()
+	res, err := c.cancelSqlProcess(s, input, output, accepter, log)
+	if err != nil {
+		return res, err
+	}
+	if err := c.recordDomainEvents(s, input, log); err != nil {
+		return nil, err
+	}
+	// トランザクションで更新する場合は、処理済みの記録も同じトランザクションで行い、コミット後の障害で再処理されないようにする
+	record := &IdempotencyRecord{PayloadHash: hash, Message: res}
+	inTransaction := s.tx != nil
+	if inTransaction {
+		if err := s.tx.SaveIdempotency(key, record); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.commit(); err != nil {
+		return nil, err
+	}
+	// 更新は適用済みのため、処理済みを記録できない場合もキャンセルは成功として返す
+	// 失敗として返すと、メッセージが再配送されて適用済みの更新が再度行われる
+	if !inTransaction {
+		if err := c.idempotency.Save(key, record); err != nil {
+			log.Error("runtime_session_id %s is processed but cannot be recorded: %+v", input.RuntimeSessionID, err)
+		}
+	}
+	return res, nil
 }
 
 func (c *DPFMAPICaller) cancelSqlProcess(
+	s *saga,
 	input *dpfm_api_input_reader.SDC,
 	output *dpfm_api_output_formatter.SDC,
 	accepter []string,
 	log *logger.Logger,
-) *dpfm_api_output_formatter.Message {
+) (*dpfm_api_output_formatter.Message, error) {
+	// プレビューでは存在性チェックを依頼しない。存在しないオーダーは読み込みで見つからないものとして返す
+	if !s.dryRun {
+		if err := c.exconfProcess(input, output, log); err != nil {
+			recordCancellations(s, accepter, outcomeOf(err))
+			return nil, err
+		}
+	}
+	if err := c.authorize(s.orders, input, accepter, false, log); err != nil {
+		recordCancellations(s, accepter, outcomeOf(err))
+		return nil, err
+	}
+	if err := c.validateCancellationReasonCodes(input); err != nil {
+		recordCancellations(s, accepter, metrics.OutcomeRejected)
+		return nil, err
+	}
+	if err := c.subfuncProcess(s, input, output, accepter, log); err != nil {
+		recordCancellations(s, accepter, outcomeOf(err))
+		return nil, err
+	}
+	violations, err := c.evaluateCancellationPolicies(s, input, accepter, log)
+	if err != nil {
+		recordCancellations(s, accepter, outcomeOf(err))
+		return nil, err
+	}
+	if len(violations) != 0 {
+		recordCancellations(s, accepter, metrics.OutcomeRejected)
+		return &dpfm_api_output_formatter.Message{
+			CancellationPolicyViolation: &violations,
+		}, xerrors.Errorf("cancellation rejected by policy: %s", violations[0].Reason)
+	}
 	var headerData *dpfm_api_output_formatter.Header
 	itemData := make([]dpfm_api_output_formatter.Item, 0)
 	itemScheduleLineData := make([]dpfm_api_output_formatter.ItemScheduleLine, 0)
 	productStockData := make([]dpfm_api_output_formatter.ProductStock, 0)
 	for _, a := range accepter {
+		found := false
 		switch a {
 		case "Header":
-			h, i, s, p := c.headerCancel(input, output, log)
+			h, i, sl, p := c.headerCancel(s, input, output, log)
 			headerData = h
-			if h == nil || i == nil || s == nil {
-				continue
+			found = h != nil
+			if h == nil || i == nil || sl == nil {
+				break
 			}
 			itemData = append(itemData, *i...)
-			itemScheduleLineData = append(itemScheduleLineData, *s...)
+			itemScheduleLineData = append(itemScheduleLineData, *sl...)
 			productStockData = append(productStockData, *p...)
 		case "Item":
-			i, s, p := c.itemCancel(input, output, log)
-			if i == nil || s == nil {
-				continue
+			i, sl, p := c.itemCancel(s, input, output, log)
+			if i == nil || sl == nil {
+				break
 			}
+			found = true
 			itemData = append(itemData, *i...)
-			itemScheduleLineData = append(itemScheduleLineData, *s...)
+			itemScheduleLineData = append(itemScheduleLineData, *sl...)
 			productStockData = append(productStockData, *p...)
 		case "ItemScheduleLine":
-			s := c.itemScheduleLineCancel(input, output, log)
-			if s == nil {
-				continue
+			sl, p := c.itemScheduleLineCancel(s, input, output, log)
+			if sl == nil {
+				break
 			}
-			itemScheduleLineData = append(itemScheduleLineData, *s...)
+			found = true
+			itemScheduleLineData = append(itemScheduleLineData, *sl...)
+			productStockData = append(productStockData, *p...)
 		}
+		// 途中で更新に失敗した場合は、適用済みの更新を逆順に取り消してオーダーを元の状態に戻す
+		if s.failed() {
+			recordCancellations(s, []string{a}, outcomeOf(s.err))
+			if errs := c.compensate(s, log); len(errs) != 0 {
+				return nil, xerrors.Errorf("cancel failed: %v, compensation failed: %v", s.err, errs[0])
+			}
+			return nil, xerrors.Errorf("cancel failed and rolled back: %w", s.err)
+		}
+		if !found {
+			recordCancellations(s, []string{a}, metrics.OutcomeNotFound)
+			continue
+		}
+		recordCancellations(s, []string{a}, metrics.OutcomeSuccess)
 	}
 
-	return &dpfm_api_output_formatter.Message{
-		Header:       		headerData,
-		Item:         		&itemData,
-		ItemScheduleLine:	&itemScheduleLineData,
-		ProductStock: 		&productStockData,
+	message := &dpfm_api_output_formatter.Message{
+		Header:           headerData,
+		Item:             &itemData,
+		ItemScheduleLine: &itemScheduleLineData,
+		ProductStock:     &productStockData,
+	}
+	if len(s.reservations) != 0 {
+		message.StockReservation = &s.reservations
 	}
+	return message, nil
 }
 
 func (c *DPFMAPICaller) headerCancel(
+	s *saga,
 	input *dpfm_api_input_reader.SDC,
 	output *dpfm_api_output_formatter.SDC,
 	log *logger.Logger,
 ) (*dpfm_api_output_formatter.Header, *[]dpfm_api_output_formatter.Item, *[]dpfm_api_output_formatter.ItemScheduleLine, *[]dpfm_api_output_formatter.ProductStock) {
-	sessionID := input.RuntimeSessionID
-
-	header := c.HeaderRead(input, log)
+	if input.Header.IsCancelled == nil {
+		s.fail(xerrors.New("IsCancelled of Orders is required"))
+		return nil, nil, nil, nil
+	}
+	header := s.orders.HeaderRead(input, log)
 	if header == nil {
 		return nil, nil, nil, nil
 	}
+	headerBefore := *header
 	header.IsCancelled = input.Header.IsCancelled
-	res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": header, "function": "OrdersHeader", "runtime_session_id": sessionID})
+	header.CancellationReasonCode = input.Header.CancellationReasonCode
+	header.CancellationComment = input.Header.CancellationComment
+	err := c.executeHeader(s, header, headerBefore)
 	if err != nil {
-		err = xerrors.Errorf("rmq error: %w", err)
 		log.Error("%+v", err)
-		return nil, nil, nil, nil
-	}
-	res.Success()
-	if !checkResult(res) {
 		output.SQLUpdateResult = getBoolPtr(false)
 		output.SQLUpdateError = "Header Data cannot cancel"
 		return nil, nil, nil, nil
@@ -121,45 +358,52 @@ func (c *DPFMAPICaller) headerCancel(
 		return header, nil, nil, nil
 	}
 
-	items := c.ItemsRead(input, log)
+	items := s.orders.ItemsRead(input, log)
+	if items == nil {
+		s.fail(xerrors.Errorf("order item read error: %w", errSQL))
+		return nil, nil, nil, nil
+	}
 	for i := range *items {
+		itemBefore := (*items)[i]
 		(*items)[i].IsCancelled = input.Header.IsCancelled
-		res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": (*items)[i], "function": "OrdersItem", "runtime_session_id": sessionID})
+		(*items)[i].CancellationReasonCode = input.Header.CancellationReasonCode
+		(*items)[i].CancellationComment = input.Header.CancellationComment
+		err := c.executeItem(s, (*items)[i], itemBefore)
 		if err != nil {
-			err = xerrors.Errorf("rmq error: %w", err)
 			log.Error("%+v", err)
-			return nil, nil, nil, nil
-		}
-		res.Success()
-		if !checkResult(res) {
 			output.SQLUpdateResult = getBoolPtr(false)
 			output.SQLUpdateError = "Order Item Data cannot cancel"
 			return nil, nil, nil, nil
 		}
 	}
 
-	itemScheduleLines := c.ItemScheduleLineRead(input, log)
+	itemScheduleLines, err := c.itemScheduleLineRead(s, input, log)
+	if err != nil {
+		s.fail(err)
+		return nil, nil, nil, nil
+	}
 	productStocks := make([]dpfm_api_output_formatter.ProductStock, 0)
 	for i := range *itemScheduleLines {
-		confirmedOrderQuantityByPDTAvailCheckInBaseUnit := float32(0)
-		productStock := new(dpfm_api_output_formatter.ProductStock)
+		itemScheduleLineBefore := (*itemScheduleLines)[i]
+		confirmedOrderQuantityByPDTAvailCheckInBaseUnit := decimal.Zero
+		var productStock *[]dpfm_api_output_formatter.ProductStock
 		if *input.Header.IsCancelled {
-			productStock, confirmedOrderQuantityByPDTAvailCheckInBaseUnit = c.releaseInventoryReservation(input, output, (*itemScheduleLines)[i], log)
+			productStock, confirmedOrderQuantityByPDTAvailCheckInBaseUnit = c.releaseInventoryReservation(s, output, (*itemScheduleLines)[i], (*itemScheduleLines)[i].ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, log)
 		} else if !*input.Header.IsCancelled {
-			productStock, confirmedOrderQuantityByPDTAvailCheckInBaseUnit = c.inventoryReservation(input, output, (*itemScheduleLines)[i], log)
+			productStock, confirmedOrderQuantityByPDTAvailCheckInBaseUnit = c.inventoryReservation(s, output, (*itemScheduleLines)[i], log)
 		}
-		productStocks = append(productStocks, *productStock)
+		if productStock == nil {
+			return nil, nil, nil, nil
+		}
+		productStocks = append(productStocks, *productStock...)
 
-		(*itemScheduleLines)[i].ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit = confirmedOrderQuantityByPDTAvailCheckInBaseUnit
+		(*itemScheduleLines)[i].ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit = dpfm_api_output_formatter.NewQuantity(confirmedOrderQuantityByPDTAvailCheckInBaseUnit)
 		(*itemScheduleLines)[i].IsCancelled = input.Header.IsCancelled
-		res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": (*itemScheduleLines)[i], "function": "OrdersItemScheduleLine", "runtime_session_id": sessionID})
+		(*itemScheduleLines)[i].CancellationReasonCode = input.Header.CancellationReasonCode
+		(*itemScheduleLines)[i].CancellationComment = input.Header.CancellationComment
+		err := c.executeItemScheduleLine(s, (*itemScheduleLines)[i], itemScheduleLineBefore, cancelAction(*input.Header.IsCancelled), nil)
 		if err != nil {
-			err = xerrors.Errorf("rmq error: %w", err)
 			log.Error("%+v", err)
-			return nil, nil, nil, nil
-		}
-		res.Success()
-		if !checkResult(res) {
 			output.SQLUpdateResult = getBoolPtr(false)
 			output.SQLUpdateError = "Order Item Schedule Line Data cannot cancel"
 			return nil, nil, nil, nil
@@ -170,78 +414,101 @@ func (c *DPFMAPICaller) headerCancel(
 }
 
 func (c *DPFMAPICaller) itemCancel(
+	s *saga,
 	input *dpfm_api_input_reader.SDC,
 	output *dpfm_api_output_formatter.SDC,
 	log *logger.Logger,
 ) (*[]dpfm_api_output_formatter.Item, *[]dpfm_api_output_formatter.ItemScheduleLine, *[]dpfm_api_output_formatter.ProductStock) {
-	sessionID := input.RuntimeSessionID
-	itemScheduleLines := c.ItemScheduleLineRead(input, log)
+	// 明細が指定されていない場合は、キャンセルする明細がないものとする
+	if len(input.Header.Item) == 0 {
+		return nil, nil, nil
+	}
+	if input.Header.Item[0].IsCancelled == nil {
+		s.fail(xerrors.New("IsCancelled of Orders.Item is required"))
+		return nil, nil, nil
+	}
+	itemScheduleLines, err := c.itemScheduleLineRead(s, input, log)
+	if err != nil {
+		s.fail(err)
+		return nil, nil, nil
+	}
 	item := input.Header.Item[0]
+	itemCancellationReasonCode, itemCancellationComment := inheritCancellationReason(item.CancellationReasonCode, item.CancellationComment, input.Header.CancellationReasonCode, input.Header.CancellationComment)
 	productStocks := make([]dpfm_api_output_formatter.ProductStock, 0)
 	for _, v := range *itemScheduleLines {
-		confirmedOrderQuantityByPDTAvailCheckInBaseUnit := float32(0)
-		productStock := new(dpfm_api_output_formatter.ProductStock)
+		itemScheduleLineBefore := v
+		confirmedOrderQuantityByPDTAvailCheckInBaseUnit := decimal.Zero
+		var productStock *[]dpfm_api_output_formatter.ProductStock
 		ordersCancel := false
 		if input.Header.IsCancelled != nil {
 			ordersCancel = *input.Header.IsCancelled
 		}
 		if ordersCancel {
-			productStock, confirmedOrderQuantityByPDTAvailCheckInBaseUnit = c.releaseInventoryReservation(input, output, v, log)
+			productStock, confirmedOrderQuantityByPDTAvailCheckInBaseUnit = c.releaseInventoryReservation(s, output, v, v.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, log)
 		} else if !ordersCancel {
-			productStock, confirmedOrderQuantityByPDTAvailCheckInBaseUnit = c.inventoryReservation(input, output, v, log)
+			productStock, confirmedOrderQuantityByPDTAvailCheckInBaseUnit = c.inventoryReservation(s, output, v, log)
+		}
+		if productStock == nil {
+			return nil, nil, nil
 		}
-		productStocks = append(productStocks, *productStock)
+		productStocks = append(productStocks, *productStock...)
 
-		v.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit = confirmedOrderQuantityByPDTAvailCheckInBaseUnit
+		v.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit = dpfm_api_output_formatter.NewQuantity(confirmedOrderQuantityByPDTAvailCheckInBaseUnit)
 		v.IsCancelled = item.IsCancelled
-		res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": v, "function": "OrdersItemScheduleLine", "runtime_session_id": sessionID})
+		v.CancellationReasonCode = itemCancellationReasonCode
+		v.CancellationComment = itemCancellationComment
+		err := c.executeItemScheduleLine(s, v, itemScheduleLineBefore, cancelAction(*item.IsCancelled), nil)
 		if err != nil {
-			err = xerrors.Errorf("rmq error: %w", err)
 			log.Error("%+v", err)
-			return nil, nil, nil
-		}
-		res.Success()
-		if !checkResult(res) {
 			output.SQLUpdateResult = getBoolPtr(false)
 			output.SQLUpdateError = "Order Item Schedule Line Data cannot cancel"
 			return nil, nil, nil
 		}
 	}
 
+	itemsBefore := s.orders.ItemsRead(input, log)
 	items := make([]dpfm_api_output_formatter.Item, 0)
 	for _, v := range input.Header.Item {
+		cancellationReasonCode, cancellationComment := inheritCancellationReason(v.CancellationReasonCode, v.CancellationComment, input.Header.CancellationReasonCode, input.Header.CancellationComment)
 		data := dpfm_api_output_formatter.Item{
-			OrderID:            input.Header.OrderID,
-			OrderItem:          v.OrderItem,
-			ItemDeliveryStatus: nil,
-			IsCancelled:        v.IsCancelled,
+			OrderID:                input.Header.OrderID,
+			OrderItem:              v.OrderItem,
+			ItemDeliveryStatus:     nil,
+			IsCancelled:            v.IsCancelled,
+			CancellationReasonCode: cancellationReasonCode,
+			CancellationComment:    cancellationComment,
+		}
+		itemBefore := findItem(itemsBefore, v.OrderItem)
+		if itemBefore == nil {
+			s.fail(xerrors.Errorf("order item %d: %w", v.OrderItem, errNotFound))
+			output.SQLUpdateResult = getBoolPtr(false)
+			output.SQLUpdateError = "Order Item Data cannot cancel"
+			return nil, nil, nil
 		}
-		res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": data, "function": "OrdersItem", "runtime_session_id": sessionID})
+		err := c.executeItem(s, data, *itemBefore)
 		if err != nil {
-			err = xerrors.Errorf("rmq error: %w", err)
 			log.Error("%+v", err)
-			return nil, nil, nil
-		}
-		res.Success()
-		if !checkResult(res) {
 			output.SQLUpdateResult = getBoolPtr(false)
 			output.SQLUpdateError = "Order Item Data cannot cancel"
 			return nil, nil, nil
 		}
+		items = append(items, data)
 	}
 
 	// itemがキャンセル取り消しされた場合、headerのキャンセルも取り消す
-	if !*input.Header.Item[0].IsCancelled {
-		header := c.HeaderRead(input, log)
-		header.IsCancelled = input.Header.Item[0].IsCancelled
-		res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": header, "function": "OrdersHeader", "runtime_session_id": sessionID})
-		if err != nil {
-			err = xerrors.Errorf("rmq error: %w", err)
-			log.Error("%+v", err)
+	if !*item.IsCancelled {
+		header := s.orders.HeaderRead(input, log)
+		if header == nil {
+			s.fail(xerrors.Errorf("header read error: %w", errSQL))
 			return nil, nil, nil
 		}
-		res.Success()
-		if !checkResult(res) {
+		headerBefore := *header
+		header.IsCancelled = item.IsCancelled
+		header.CancellationReasonCode = input.Header.CancellationReasonCode
+		header.CancellationComment = input.Header.CancellationComment
+		err := c.executeHeader(s, header, headerBefore)
+		if err != nil {
+			log.Error("%+v", err)
 			output.SQLUpdateResult = getBoolPtr(false)
 			output.SQLUpdateError = "Header Data cannot cancel"
 			return nil, nil, nil
@@ -252,186 +519,139 @@ func (c *DPFMAPICaller) itemCancel(
 }
 
 func (c *DPFMAPICaller) itemScheduleLineCancel(
+	s *saga,
 	input *dpfm_api_input_reader.SDC,
 	output *dpfm_api_output_formatter.SDC,
 	log *logger.Logger,
-) *[]dpfm_api_output_formatter.ItemScheduleLine {
-	sessionID := input.RuntimeSessionID
+) (*[]dpfm_api_output_formatter.ItemScheduleLine, *[]dpfm_api_output_formatter.ProductStock) {
+	itemScheduleLinesBefore, err := c.itemScheduleLineRead(s, input, log)
+	if err != nil {
+		s.fail(err)
+		output.SQLUpdateResult = getBoolPtr(false)
+		output.SQLUpdateError = "Order Item Schedule Line Data cannot cancel"
+		return nil, nil
+	}
 	itemScheduleLines := make([]dpfm_api_output_formatter.ItemScheduleLine, 0)
+	productStocks := make([]dpfm_api_output_formatter.ProductStock, 0)
 	for _, item := range input.Header.Item {
-		for _, itemScheduleLine := range item.ItemSchedulingLine {
+		itemCancellationReasonCode, itemCancellationComment := inheritCancellationReason(item.CancellationReasonCode, item.CancellationComment, input.Header.CancellationReasonCode, input.Header.CancellationComment)
+		for _, itemScheduleLine := range item.ItemScheduleLine {
+			cancellationReasonCode, cancellationComment := inheritCancellationReason(itemScheduleLine.CancellationReasonCode, itemScheduleLine.CancellationComment, itemCancellationReasonCode, itemCancellationComment)
 			data := dpfm_api_output_formatter.ItemScheduleLine{
-				OrderID:      input.Header.OrderID,
-				OrderItem:    item.OrderItem,
-				ScheduleLine: itemScheduleLine.ScheduleLine,
-				IsCancelled:  itemScheduleLine.IsCancelled,
+				OrderID:                input.Header.OrderID,
+				OrderItem:              item.OrderItem,
+				ScheduleLine:           itemScheduleLine.ScheduleLine,
+				IsCancelled:            itemScheduleLine.IsCancelled,
+				CancellationReasonCode: cancellationReasonCode,
+				CancellationComment:    cancellationComment,
+			}
+			itemScheduleLineBefore := findItemScheduleLine(itemScheduleLinesBefore, item.OrderItem, itemScheduleLine.ScheduleLine)
+			if itemScheduleLineBefore == nil {
+				s.fail(xerrors.Errorf("order item schedule line %d-%d: %w", item.OrderItem, itemScheduleLine.ScheduleLine, errNotFound))
+				output.SQLUpdateResult = getBoolPtr(false)
+				output.SQLUpdateError = "Order Item Schedule Line Data cannot cancel"
+				return nil, nil
+			}
+
+			// 数量が指定された場合は、スケジュール行の一部の数量のみをキャンセルし、その分の在庫引当を解除する
+			var partialCancelledQuantity *decimal.Decimal
+			if itemScheduleLine.CancelledQuantityInBaseUnit != nil {
+				cancelledQuantity := *itemScheduleLine.CancelledQuantityInBaseUnit
+				if cancelledQuantity.Sign() <= 0 {
+					s.fail(xerrors.Errorf("order item schedule line %d-%d: cancelled quantity must be positive", item.OrderItem, itemScheduleLine.ScheduleLine))
+					output.SQLUpdateResult = getBoolPtr(false)
+					output.SQLUpdateError = "Order Item Schedule Line Data cannot cancel"
+					return nil, nil
+				}
+				// 基本数量単位の小数桁数を超える数量は、丸めずにエラーとする
+				scale, err := c.quantityScale(*itemScheduleLineBefore)
+				if err != nil {
+					s.fail(err)
+					output.SQLUpdateResult = getBoolPtr(false)
+					output.SQLUpdateError = "Order Item Schedule Line Data cannot cancel"
+					return nil, nil
+				}
+				if !cancelledQuantity.Equal(cancelledQuantity.Round(scale)) {
+					s.fail(xerrors.Errorf("order item schedule line %d-%d: cancelled quantity %s exceeds %d decimal places of the base unit", item.OrderItem, itemScheduleLine.ScheduleLine, cancelledQuantity, scale))
+					output.SQLUpdateResult = getBoolPtr(false)
+					output.SQLUpdateError = "Order Item Schedule Line Data cannot cancel"
+					return nil, nil
+				}
+				if cancelledQuantity.GreaterThan(itemScheduleLineBefore.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal) {
+					s.fail(xerrors.Errorf("order item schedule line %d-%d: cancelled quantity %s exceeds confirmed quantity %s", item.OrderItem, itemScheduleLine.ScheduleLine, cancelledQuantity, itemScheduleLineBefore.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit))
+					output.SQLUpdateResult = getBoolPtr(false)
+					output.SQLUpdateError = "Order Item Schedule Line Data cannot cancel"
+					return nil, nil
+				}
+
+				productStock, remainingQuantity := c.releaseInventoryReservation(s, output, *itemScheduleLineBefore, cancelledQuantity, log)
+				if productStock == nil {
+					return nil, nil
+				}
+				productStocks = append(productStocks, *productStock...)
+
+				// キャンセル済みの数量は、これまでにキャンセルした数量との合計を返す
+				totalCancelledQuantity := cancelledQuantity
+				if itemScheduleLineBefore.CancelledQuantityInBaseUnit != nil {
+					totalCancelledQuantity = totalCancelledQuantity.Add(itemScheduleLineBefore.CancelledQuantityInBaseUnit.Decimal)
+				}
+				data = *itemScheduleLineBefore
+				data.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit = dpfm_api_output_formatter.NewQuantity(remainingQuantity)
+				data.CancelledQuantityInBaseUnit = &dpfm_api_output_formatter.Quantity{Decimal: totalCancelledQuantity}
+				partialCancelledQuantity = &cancelledQuantity
+				data.CancellationReasonCode = cancellationReasonCode
+				data.CancellationComment = cancellationComment
+				if remainingQuantity.IsZero() {
+					data.IsCancelled = getBoolPtr(true)
+				}
 			}
 
-			res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": data, "function": "OrdersItemScheduleLine", "runtime_session_id": sessionID})
+			a := cancelAction(isTrue(itemScheduleLine.IsCancelled))
+			if partialCancelledQuantity != nil {
+				a = actionPartialCancel
+			}
+			err := c.executeItemScheduleLine(s, data, *itemScheduleLineBefore, a, partialCancelledQuantity)
 			if err != nil {
-				err = xerrors.Errorf("rmq error: %w", err)
 				log.Error("%+v", err)
-				return nil
-			}
-			res.Success()
-			if !checkResult(res) {
 				output.SQLUpdateResult = getBoolPtr(false)
 				output.SQLUpdateError = "Order Item Schedule Line Data cannot cancel"
-				return nil
+				return nil, nil
 			}
 			itemScheduleLines = append(itemScheduleLines, data)
 		}
 	}
-	return &itemScheduleLines
+	return &itemScheduleLines, &productStocks
 }
 
-func (c *DPFMAPICaller) releaseInventoryReservation(
-	input *dpfm_api_input_reader.SDC,
-	output *dpfm_api_output_formatter.SDC,
-	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
-	log *logger.Logger,
-) (*dpfm_api_output_formatter.ProductStock, float32) {
-	sessionID := input.RuntimeSessionID
-
-	if itemScheduleLine.StockConfirmationPlantBatch == nil {
-		productStock := c.ProductStockAvailabilityRead(itemScheduleLine, log)
-		availableProductStock := productStock.AvailableProductStock
-		confirmedOrderQuantityByPDTAvailCheckInBaseUnit := itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit
-		recalculatedAvailableProductStock := availableProductStock + confirmedOrderQuantityByPDTAvailCheckInBaseUnit
-
-		data := dpfm_api_output_formatter.ProductStock{
-			Product:                      productStock.Product,
-			BusinessPartner:              productStock.BusinessPartner,
-			Plant:                        productStock.Plant,
-			ProductStockAvailabilityDate: productStock.ProductStockAvailabilityDate,
-			AvailableProductStock:        recalculatedAvailableProductStock,
-		}
-
-		res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": data, "function": "ProductStockAvailability", "runtime_session_id": sessionID})
-		if err != nil {
-			err = xerrors.Errorf("rmq error: %w", err)
-			log.Error("%+v", err)
-			return nil, 0
-		}
-		res.Success()
-		if !checkResult(res) {
-			output.SQLUpdateResult = getBoolPtr(false)
-			output.SQLUpdateError = "Product Stock Availability Data cannot update"
-			return nil, 0
-		}
-
-		return &data, 0
-	} else {
-		productStock := c.ProductStockAvailabilityByBatchRead(itemScheduleLine, log)
-		availableProductStock := productStock.AvailableProductStock
-		confirmedOrderQuantityByPDTAvailCheckInBaseUnit := itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit
-		recalculatedAvailableProductStock := availableProductStock + confirmedOrderQuantityByPDTAvailCheckInBaseUnit
-
-		data := dpfm_api_output_formatter.ProductStock{
-			Product:                      productStock.Product,
-			BusinessPartner:              productStock.BusinessPartner,
-			Plant:                        productStock.Plant,
-			Batch:                        productStock.Batch,
-			ProductStockAvailabilityDate: productStock.ProductStockAvailabilityDate,
-			AvailableProductStock:        recalculatedAvailableProductStock,
-		}
-
-		res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": data, "function": "ProductStockAvailabilityByBatch", "runtime_session_id": sessionID})
-		if err != nil {
-			err = xerrors.Errorf("rmq error: %w", err)
-			log.Error("%+v", err)
-			return nil, 0
-		}
-		res.Success()
-		if !checkResult(res) {
-			output.SQLUpdateResult = getBoolPtr(false)
-			output.SQLUpdateError = "Product Stock Availability By Batch Data cannot update"
-			return nil, 0
+func findItem(
+	items *[]dpfm_api_output_formatter.Item,
+	orderItem int,
+) *dpfm_api_output_formatter.Item {
+	if items == nil {
+		return nil
+	}
+	for i := range *items {
+		if (*items)[i].OrderItem == orderItem {
+			return &(*items)[i]
 		}
-
-		return &data, 0
 	}
+	return nil
 }
 
-func (c *DPFMAPICaller) inventoryReservation(
-	input *dpfm_api_input_reader.SDC,
-	output *dpfm_api_output_formatter.SDC,
-	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
-	log *logger.Logger,
-) (*dpfm_api_output_formatter.ProductStock, float32) {
-	sessionID := input.RuntimeSessionID
-
-	if itemScheduleLine.StockConfirmationPlantBatch == nil {
-		productStock := c.ProductStockAvailabilityRead(itemScheduleLine, log)
-		availableProductStock := productStock.AvailableProductStock
-		confirmedOrderQuantityByPDTAvailCheckInBaseUnit := itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit
-		recalculatedAvailableProductStock := float32(0)
-		if availableProductStock >= confirmedOrderQuantityByPDTAvailCheckInBaseUnit {
-			recalculatedAvailableProductStock = availableProductStock - confirmedOrderQuantityByPDTAvailCheckInBaseUnit
-		}
-
-		data := dpfm_api_output_formatter.ProductStock{
-			Product:                      productStock.Product,
-			BusinessPartner:              productStock.BusinessPartner,
-			Plant:                        productStock.Plant,
-			ProductStockAvailabilityDate: productStock.ProductStockAvailabilityDate,
-			AvailableProductStock:        recalculatedAvailableProductStock,
-		}
-
-		res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": data, "function": "ProductStockAvailability", "runtime_session_id": sessionID})
-		if err != nil {
-			err = xerrors.Errorf("rmq error: %w", err)
-			log.Error("%+v", err)
-			return nil, 0
-		}
-		res.Success()
-		if !checkResult(res) {
-			output.SQLUpdateResult = getBoolPtr(false)
-			output.SQLUpdateError = "Product Stock Availability Data cannot update"
-			return nil, 0
-		}
-
-		if availableProductStock >= recalculatedAvailableProductStock {
-			return productStock, confirmedOrderQuantityByPDTAvailCheckInBaseUnit
-		}
-
-		return &data, availableProductStock
-	} else {
-		productStock := c.ProductStockAvailabilityByBatchRead(itemScheduleLine, log)
-		availableProductStock := productStock.AvailableProductStock
-		confirmedOrderQuantityByPDTAvailCheckInBaseUnit := itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit
-		recalculatedAvailableProductStock := float32(0)
-		if availableProductStock >= confirmedOrderQuantityByPDTAvailCheckInBaseUnit {
-			recalculatedAvailableProductStock = availableProductStock - confirmedOrderQuantityByPDTAvailCheckInBaseUnit
-		}
-
-		data := dpfm_api_output_formatter.ProductStock{
-			Product:                      productStock.Product,
-			BusinessPartner:              productStock.BusinessPartner,
-			Plant:                        productStock.Plant,
-			Batch:                        productStock.Batch,
-			ProductStockAvailabilityDate: productStock.ProductStockAvailabilityDate,
-			AvailableProductStock:        recalculatedAvailableProductStock,
-		}
-
-		res, err := c.rmq.SessionKeepRequest(nil, c.conf.RMQ.QueueToSQL()[0], map[string]interface{}{"message": data, "function": "ProductStockAvailabilityByBatch", "runtime_session_id": sessionID})
-		if err != nil {
-			err = xerrors.Errorf("rmq error: %w", err)
-			log.Error("%+v", err)
-			return nil, 0
-		}
-		res.Success()
-		if !checkResult(res) {
-			output.SQLUpdateResult = getBoolPtr(false)
-			output.SQLUpdateError = "Product Stock Availability By Batch Data cannot update"
-			return nil, 0
-		}
-
-		if availableProductStock >= recalculatedAvailableProductStock {
-			return productStock, confirmedOrderQuantityByPDTAvailCheckInBaseUnit
+func findItemScheduleLine(
+	itemScheduleLines *[]dpfm_api_output_formatter.ItemScheduleLine,
+	orderItem int,
+	scheduleLine int,
+) *dpfm_api_output_formatter.ItemScheduleLine {
+	if itemScheduleLines == nil {
+		return nil
+	}
+	for i := range *itemScheduleLines {
+		if (*itemScheduleLines)[i].OrderItem == orderItem && (*itemScheduleLines)[i].ScheduleLine == scheduleLine {
+			return &(*itemScheduleLines)[i]
 		}
-		return &data, availableProductStock
 	}
+	return nil
 }
 
 func checkResult(msg rabbitmq.RabbitmqMessage) bool {
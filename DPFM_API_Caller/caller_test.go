@@ -0,0 +1,678 @@
+package dpfm_api_caller
+
+import (
+	"context"
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"data-platform-api-orders-cancels-rmq-kube/config"
+	"data-platform-api-orders-cancels-rmq-kube/metrics"
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	rabbitmq "github.com/latonaio/rabbitmq-golang-client-for-data-platform"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+	"golang.org/x/xerrors"
+)
+
+const (
+	testOrderID = 265
+	testBuyer   = 101
+	testSeller  = 201
+	testProduct = "A3750#01"
+	testPlant   = "AB01"
+	testDate    = "2022-10-01"
+)
+
+type testCaller struct {
+	*DPFMAPICaller
+	orders *MemoryOrdersRepository
+	stocks *MemoryStockRepository
+	log    *logger.Logger
+}
+
+func newTestCaller(t *testing.T, stocks ...dpfm_api_output_formatter.ProductStock) *testCaller {
+	t.Helper()
+	if _, ok := os.LookupEnv("QUANTITY_UNIT_SCALES"); !ok {
+		t.Setenv("QUANTITY_UNIT_SCALES", `{"PC": 3}`)
+	}
+	orders := NewMemoryOrdersRepository()
+	orders.Seed(testOrder(decimal.NewFromInt(10), decimal.NewFromInt(10)))
+	productStocks := NewMemoryStockRepository()
+	productStocks.Seed(stocks...)
+	return &testCaller{
+		DPFMAPICaller: NewDPFMAPICaller(config.NewConf(), nil, NewMemoryStores(orders, productStocks)),
+		orders:        orders,
+		stocks:        productStocks,
+		log:           logger.NewLogger(),
+	}
+}
+
+// testOrder は、明細 1 件・スケジュール行 1 件のキャンセルされていないオーダーです
+func testOrder(ordered, confirmed decimal.Decimal) MemoryOrder {
+	return MemoryOrder{
+		Header: dpfm_api_output_formatter.Header{
+			OrderID:              testOrderID,
+			HeaderDeliveryStatus: getStringPtr("NP"),
+			HeaderBillingStatus:  getStringPtr("NP"),
+			IsCancelled:          getBoolPtr(false),
+			IsMarkedForDeletion:  getBoolPtr(false),
+		},
+		Buyer:  testBuyer,
+		Seller: testSeller,
+		Item: []dpfm_api_output_formatter.Item{{
+			OrderID:             testOrderID,
+			OrderItem:           1,
+			ItemDeliveryStatus:  getStringPtr("NP"),
+			ItemBillingStatus:   getStringPtr("NP"),
+			IsCancelled:         getBoolPtr(false),
+			IsMarkedForDeletion: getBoolPtr(false),
+		}},
+		ItemScheduleLine: []dpfm_api_output_formatter.ItemScheduleLine{{
+			OrderID:                             testOrderID,
+			OrderItem:                           1,
+			ScheduleLine:                        1,
+			Product:                             testProduct,
+			StockConfirmationBusinessPartner:    testSeller,
+			StockConfirmationPlant:              testPlant,
+			RequestedDeliveryDate:               getStringPtr(testDate),
+			BaseUnit:                            getStringPtr("PC"),
+			ScheduleLineOrderQuantityInBaseUnit: &dpfm_api_output_formatter.Quantity{Decimal: ordered},
+			ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit: dpfm_api_output_formatter.NewQuantity(confirmed),
+			IsCancelled:         getBoolPtr(false),
+			IsMarkedForDeletion: getBoolPtr(false),
+		}},
+	}
+}
+
+// failingWriter は、failScheduleLine の場合にスケジュール行の更新を失敗させます
+type failingWriter struct {
+	SQLWriter
+	failScheduleLine bool
+}
+
+func (w *failingWriter) UpdateScheduleLine(sessionID string, itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine) error {
+	if w.failScheduleLine {
+		return xerrors.Errorf("schedule line update error: %w", errSQL)
+	}
+	return w.SQLWriter.UpdateScheduleLine(sessionID, itemScheduleLine)
+}
+
+// testRMQ は、RabbitMQ への問い合わせ・送信を記録し、キューごとに respond の応答を返します
+type testRMQ struct {
+	mtx      sync.Mutex
+	requests []string
+	sent     []string
+	respond  map[string]func(payload interface{}) (map[string]interface{}, error)
+	// hang のキューは、ctx の期限まで応答しない
+	hang map[string]bool
+}
+
+func newTestRMQ() *testRMQ {
+	return &testRMQ{
+		respond: make(map[string]func(payload interface{}) (map[string]interface{}, error)),
+		hang:    make(map[string]bool),
+	}
+}
+
+func (r *testRMQ) SessionKeepRequest(ctx context.Context, queue string, payload interface{}) (rabbitmq.RabbitmqMessage, error) {
+	r.mtx.Lock()
+	r.requests = append(r.requests, queue)
+	respond, ok := r.respond[queue]
+	hang := r.hang[queue]
+	r.mtx.Unlock()
+	if hang && ctx != nil {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if !ok {
+		return nil, xerrors.Errorf("queue %s does not respond", queue)
+	}
+	data, err := respond(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &testRMQMessage{data: data}, nil
+}
+
+func (r *testRMQ) Send(queue string, payload interface{}) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.sent = append(r.sent, queue)
+	return nil
+}
+
+type testRMQMessage struct {
+	rabbitmq.RabbitmqMessage
+	data map[string]interface{}
+}
+
+func (m *testRMQMessage) Data() map[string]interface{} {
+	return m.data
+}
+
+func (m *testRMQMessage) Success() error {
+	return nil
+}
+
+func testStock(date string, quantity int64) dpfm_api_output_formatter.ProductStock {
+	return dpfm_api_output_formatter.ProductStock{
+		Product:                      testProduct,
+		BusinessPartner:              testSeller,
+		Plant:                        testPlant,
+		ProductStockAvailabilityDate: date,
+		AvailableProductStock:        dpfm_api_output_formatter.NewQuantity(decimal.NewFromInt(quantity)),
+	}
+}
+
+// testInput は、売り手がオーダー全体を isCancelled でキャンセル（またはキャンセル取消）する入力です
+func testInput(apiType string, sessionID string, isCancelled bool) *dpfm_api_input_reader.SDC {
+	return &dpfm_api_input_reader.SDC{
+		RuntimeSessionID: sessionID,
+		BusinessPartner:  testSeller,
+		APIType:          apiType,
+		Header: dpfm_api_input_reader.Header{
+			OrderID:     testOrderID,
+			IsCancelled: getBoolPtr(isCancelled),
+			Item: []dpfm_api_input_reader.Item{{
+				OrderID:     testOrderID,
+				OrderItem:   1,
+				IsCancelled: getBoolPtr(isCancelled),
+			}},
+		},
+		Accepter: []string{"Header", "Item", "ItemScheduleLine"},
+	}
+}
+
+func (c *testCaller) call(t *testing.T, input *dpfm_api_input_reader.SDC) (*dpfm_api_output_formatter.Message, []error) {
+	t.Helper()
+	res, errs := c.AsyncCancels(input.Accepter, input, &dpfm_api_output_formatter.SDC{}, c.log)
+	message, _ := res.(*dpfm_api_output_formatter.Message)
+	return message, errs
+}
+
+func (c *testCaller) stock(t *testing.T, date string) decimal.Decimal {
+	t.Helper()
+	stock := c.stocks.ProductStockAvailabilityRead(dpfm_api_output_formatter.ItemScheduleLine{
+		Product:                          testProduct,
+		StockConfirmationBusinessPartner: testSeller,
+		StockConfirmationPlant:           testPlant,
+		RequestedDeliveryDate:            getStringPtr(date),
+	}, c.log)
+	return stock.AvailableProductStock.Decimal
+}
+
+func (c *testCaller) itemScheduleLine(t *testing.T) dpfm_api_output_formatter.ItemScheduleLine {
+	t.Helper()
+	itemScheduleLines := c.orders.ItemScheduleLineRead(&dpfm_api_input_reader.SDC{
+		BusinessPartner: testBuyer,
+		Header:          dpfm_api_input_reader.Header{OrderID: testOrderID},
+	}, c.log)
+	if itemScheduleLines == nil || len(*itemScheduleLines) != 1 {
+		t.Fatalf("schedule line of order %d is not found", testOrderID)
+	}
+	return (*itemScheduleLines)[0]
+}
+
+func (c *testCaller) header(t *testing.T) dpfm_api_output_formatter.Header {
+	t.Helper()
+	header := c.orders.HeaderRead(&dpfm_api_input_reader.SDC{
+		BusinessPartner: testBuyer,
+		Header:          dpfm_api_input_reader.Header{OrderID: testOrderID},
+	}, c.log)
+	if header == nil {
+		t.Fatalf("order %d is not found", testOrderID)
+	}
+	return *header
+}
+
+func (c *testCaller) item(t *testing.T) dpfm_api_output_formatter.Item {
+	t.Helper()
+	items := c.orders.ItemsRead(&dpfm_api_input_reader.SDC{
+		BusinessPartner: testBuyer,
+		Header:          dpfm_api_input_reader.Header{OrderID: testOrderID},
+	}, c.log)
+	if items == nil || len(*items) != 1 {
+		t.Fatalf("item of order %d is not found", testOrderID)
+	}
+	return (*items)[0]
+}
+
+// partialCancelInput は、売り手がスケジュール行の数量のうち quantity のみをキャンセルする入力です
+func partialCancelInput(sessionID string, quantity int64) *dpfm_api_input_reader.SDC {
+	cancelledQuantity := decimal.NewFromInt(quantity)
+	return &dpfm_api_input_reader.SDC{
+		RuntimeSessionID: sessionID,
+		BusinessPartner:  testSeller,
+		APIType:          "cancels",
+		Header: dpfm_api_input_reader.Header{
+			OrderID: testOrderID,
+			Item: []dpfm_api_input_reader.Item{{
+				OrderID:   testOrderID,
+				OrderItem: 1,
+				ItemScheduleLine: []dpfm_api_input_reader.ItemScheduleLine{{
+					OrderID:                     testOrderID,
+					OrderItem:                   1,
+					ScheduleLine:                1,
+					CancelledQuantityInBaseUnit: &cancelledQuantity,
+				}},
+			}},
+		},
+		Accepter: []string{"ItemScheduleLine"},
+	}
+}
+
+func mustNoErrors(t *testing.T, errs []error) {
+	t.Helper()
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+func assertQuantity(t *testing.T, name string, got decimal.Decimal, want int64) {
+	t.Helper()
+	if !got.Equal(decimal.NewFromInt(want)) {
+		t.Errorf("%s = %s, want %d", name, got, want)
+	}
+}
+
+func TestCancelsPreviewMatchesCancels(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	preview, errs := c.call(t, testInput("cancels-preview", "preview", true))
+	mustNoErrors(t, errs)
+	assertQuantity(t, "stock after preview", c.stock(t, testDate), 100)
+	if isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Fatal("preview cancelled the schedule line")
+	}
+
+	cancelled, errs := c.call(t, testInput("cancels", "cancel", true))
+	mustNoErrors(t, errs)
+	assertQuantity(t, "stock after cancel", c.stock(t, testDate), 110)
+
+	want, _ := json.Marshal(cancelled)
+	got, _ := json.Marshal(preview)
+	if string(got) != string(want) {
+		t.Errorf("preview differs from cancel\npreview: %s\ncancel:  %s", got, want)
+	}
+}
+
+// existenceResponse は、存在性チェックの応答です
+func existenceResponse(exist bool) func(payload interface{}) (map[string]interface{}, error) {
+	return func(payload interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"message": map[string]interface{}{
+				"Orders": map[string]interface{}{"ExistenceConf": exist},
+			},
+		}, nil
+	}
+}
+
+// testSubfuncResponse は、testOrder の明細・スケジュール行を返すサブファンクションの応答です
+func testSubfuncResponse(payload interface{}) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"message": map[string]interface{}{
+			"Item":             []interface{}{map[string]interface{}{"OrderID": testOrderID, "OrderItem": 1}},
+			"ItemScheduleLine": []interface{}{map[string]interface{}{"OrderID": testOrderID, "OrderItem": 1, "ScheduleLine": 1}},
+		},
+	}, nil
+}
+
+// newTestCallerWithRMQ は、存在性チェック・サブファンクションのキューを設定し、問い合わせを testRMQ に送る testCaller です
+func newTestCallerWithRMQ(t *testing.T, stocks ...dpfm_api_output_formatter.ProductStock) (*testCaller, *testRMQ) {
+	t.Helper()
+	t.Setenv("RMQ_QUEUE_TO_EX_CONF", "exconf")
+	t.Setenv("RMQ_QUEUE_TO_HEADERS_SUB_FUNC", "headers-sub-func")
+	t.Setenv("RMQ_QUEUE_TO_ITEMS_SUB_FUNC", "items-sub-func")
+	c := newTestCaller(t, stocks...)
+	rmq := newTestRMQ()
+	rmq.respond["exconf"] = existenceResponse(true)
+	rmq.respond["headers-sub-func"] = testSubfuncResponse
+	rmq.respond["items-sub-func"] = testSubfuncResponse
+	c.rmq = rmq
+	return c, rmq
+}
+
+// headerCancelInput は、明細を指定せずにサブファンクションで補完するキャンセルの入力です
+func headerCancelInput(apiType string, sessionID string) *dpfm_api_input_reader.SDC {
+	input := testInput(apiType, sessionID, true)
+	input.Header.Item = nil
+	input.Accepter = []string{"Item", "ItemScheduleLine"}
+	return input
+}
+
+func TestCancelsPreviewSendsNoRMQRequest(t *testing.T) {
+	c, rmq := newTestCallerWithRMQ(t, testStock(testDate, 100))
+
+	preview, errs := c.call(t, headerCancelInput("cancels-preview", "preview"))
+	mustNoErrors(t, errs)
+	if len(rmq.requests) != 0 {
+		t.Fatalf("preview sent RMQ requests to %v", rmq.requests)
+	}
+	assertQuantity(t, "stock after preview", c.stock(t, testDate), 100)
+
+	cancelled, errs := c.call(t, headerCancelInput("cancels", "cancel"))
+	mustNoErrors(t, errs)
+	if len(rmq.requests) == 0 {
+		t.Fatal("cancel sent no RMQ requests")
+	}
+	want, _ := json.Marshal(cancelled)
+	got, _ := json.Marshal(preview)
+	if string(got) != string(want) {
+		t.Errorf("preview differs from cancel\npreview: %s\ncancel:  %s", got, want)
+	}
+}
+
+func TestBuyerPreviewReturnsCancellationRequest(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	input := testInput("cancels-preview", "preview", true)
+	input.BusinessPartner = testBuyer
+	res, errs := c.call(t, input)
+	mustNoErrors(t, errs)
+	if res.CancellationRequest == nil || res.CancellationRequest.Status != CancellationRequestPending || res.CancellationRequest.CancellationRequestID != 0 {
+		t.Fatalf("preview does not return the cancellation request: %+v", res)
+	}
+	if res.ItemScheduleLine != nil || res.ProductStock != nil {
+		t.Errorf("buyer preview returns the cancel by the seller: %+v", res)
+	}
+	requests, err := c.cancellationRequests.List(testBuyer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("preview registered cancellation requests: %+v", requests)
+	}
+	assertQuantity(t, "stock after preview", c.stock(t, testDate), 100)
+}
+
+func TestCancelsWithoutProductStockRecordsNoMovement(t *testing.T) {
+	c := newTestCaller(t)
+
+	res, errs := c.call(t, testInput("cancels", "cancel", true))
+	mustNoErrors(t, errs)
+	if res.ProductStock != nil && len(*res.ProductStock) != 0 {
+		t.Errorf("product stock that does not exist is updated: %+v", *res.ProductStock)
+	}
+	movements, err := c.stockLedger.List(StockMovementQuery{OrderID: getIntPtr(testOrderID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movements) != 0 {
+		t.Errorf("stock movements = %+v, want none", movements)
+	}
+}
+
+func TestStockQuantityIsRecordedOnlyForCommittedCancels(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+	writer := &failingWriter{SQLWriter: c.writer, failScheduleLine: true}
+	c.writer = writer
+	released := metrics.StockQuantity.WithLabelValues(metrics.StockReleased)
+	before := testutil.ToFloat64(released)
+
+	if _, errs := c.call(t, testInput("cancels", "failed", true)); len(errs) == 0 {
+		t.Fatal("cancel succeeded though the schedule line cannot be updated")
+	}
+	if got := testutil.ToFloat64(released) - before; got != 0 {
+		t.Errorf("released stock quantity after compensation = %v, want 0", got)
+	}
+
+	writer.failScheduleLine = false
+	_, errs := c.call(t, testInput("cancels", "cancel", true))
+	mustNoErrors(t, errs)
+	if got := testutil.ToFloat64(released) - before; got != 10 {
+		t.Errorf("released stock quantity after cancel = %v, want 10", got)
+	}
+}
+
+func TestBuyerCancelIsConfirmedBySeller(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	input := testInput("cancels", "request", true)
+	input.BusinessPartner = testBuyer
+	requested, errs := c.call(t, input)
+	mustNoErrors(t, errs)
+	if requested.CancellationRequest == nil || requested.CancellationRequest.Status != CancellationRequestPending {
+		t.Fatalf("cancellation request is not pending: %+v", requested)
+	}
+	assertQuantity(t, "stock after request", c.stock(t, testDate), 100)
+	if isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Fatal("buyer cancelled the schedule line before the seller confirms")
+	}
+
+	confirm := &dpfm_api_input_reader.SDC{
+		RuntimeSessionID:      "confirm",
+		BusinessPartner:       testBuyer,
+		APIType:               "cancellation-requests-confirm",
+		CancellationRequestID: &requested.CancellationRequest.CancellationRequestID,
+	}
+	if _, errs := c.call(t, confirm); len(errs) == 0 {
+		t.Fatal("buyer confirmed own cancellation request")
+	}
+
+	confirm.BusinessPartner = testSeller
+	_, errs = c.call(t, confirm)
+	mustNoErrors(t, errs)
+	assertQuantity(t, "stock after confirm", c.stock(t, testDate), 110)
+	if !isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Error("schedule line is not cancelled after the seller confirms")
+	}
+
+	// 再配送された確定は、在庫を二重に戻さない
+	_, errs = c.call(t, confirm)
+	mustNoErrors(t, errs)
+	assertQuantity(t, "stock after redelivered confirm", c.stock(t, testDate), 110)
+}
+
+func TestHeaderCancelCascadesToItemsAndScheduleLines(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	input := testInput("cancels", "cancel", true)
+	input.Header.Item = nil
+	input.Accepter = []string{"Header"}
+	res, errs := c.call(t, input)
+	mustNoErrors(t, errs)
+
+	if !isTrue(c.header(t).IsCancelled) || !isTrue(c.item(t).IsCancelled) {
+		t.Error("header cancel does not cascade to the item")
+	}
+	itemScheduleLine := c.itemScheduleLine(t)
+	if !isTrue(itemScheduleLine.IsCancelled) {
+		t.Error("header cancel does not cascade to the schedule line")
+	}
+	assertQuantity(t, "confirmed quantity after cancel", itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, 0)
+	assertQuantity(t, "stock after cancel", c.stock(t, testDate), 110)
+	if res.Item == nil || len(*res.Item) != 1 || res.ItemScheduleLine == nil || len(*res.ItemScheduleLine) != 1 {
+		t.Errorf("cascaded item and schedule line are not returned: %+v", res)
+	}
+
+	// キャンセル取消も明細・スケジュール行に連鎖し、在庫を再引当する
+	_, errs = c.call(t, testInput("cancels", "reactivate", false))
+	mustNoErrors(t, errs)
+	if isTrue(c.header(t).IsCancelled) || isTrue(c.item(t).IsCancelled) || isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Error("order is still cancelled after reactivation")
+	}
+	assertQuantity(t, "confirmed quantity after reactivation", c.itemScheduleLine(t).ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, 10)
+	assertQuantity(t, "stock after reactivation", c.stock(t, testDate), 100)
+}
+
+func TestPartialCancelAccumulatesCancelledQuantity(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	_, errs := c.call(t, partialCancelInput("partial-1", 5))
+	mustNoErrors(t, errs)
+	_, errs = c.call(t, partialCancelInput("partial-2", 3))
+	mustNoErrors(t, errs)
+
+	itemScheduleLine := c.itemScheduleLine(t)
+	if itemScheduleLine.CancelledQuantityInBaseUnit == nil {
+		t.Fatal("cancelled quantity is not recorded")
+	}
+	assertQuantity(t, "cancelled quantity", itemScheduleLine.CancelledQuantityInBaseUnit.Decimal, 8)
+	assertQuantity(t, "confirmed quantity", itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, 2)
+	if isTrue(itemScheduleLine.IsCancelled) {
+		t.Error("partially cancelled schedule line is cancelled")
+	}
+	assertQuantity(t, "stock after partial cancels", c.stock(t, testDate), 108)
+
+	// 確定数量を超える数量はキャンセルできない
+	if _, errs := c.call(t, partialCancelInput("partial-3", 3)); len(errs) == 0 {
+		t.Fatal("cancelled quantity exceeding the confirmed quantity is accepted")
+	}
+	assertQuantity(t, "confirmed quantity after rejected cancel", c.itemScheduleLine(t).ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, 2)
+	assertQuantity(t, "stock after rejected cancel", c.stock(t, testDate), 108)
+
+	// 残りの数量をすべてキャンセルすると、スケジュール行はキャンセル済みになる
+	_, errs = c.call(t, partialCancelInput("partial-4", 2))
+	mustNoErrors(t, errs)
+	itemScheduleLine = c.itemScheduleLine(t)
+	if !isTrue(itemScheduleLine.IsCancelled) {
+		t.Error("schedule line without remaining quantity is not cancelled")
+	}
+	assertQuantity(t, "cancelled quantity", itemScheduleLine.CancelledQuantityInBaseUnit.Decimal, 10)
+	assertQuantity(t, "stock after cancelling the remaining quantity", c.stock(t, testDate), 110)
+}
+
+func TestReactivationReportsShortfall(t *testing.T) {
+	const laterDate = "2022-10-05"
+	c := newTestCaller(t, testStock(testDate, 4), testStock(laterDate, 3))
+	order := testOrder(decimal.NewFromInt(10), decimal.Zero)
+	order.Header.IsCancelled = getBoolPtr(true)
+	order.Item[0].IsCancelled = getBoolPtr(true)
+	order.ItemScheduleLine[0].IsCancelled = getBoolPtr(true)
+	c.orders.Seed(order)
+
+	res, errs := c.call(t, testInput("cancels", "reactivate", false))
+	mustNoErrors(t, errs)
+
+	// 要求納入日付の在庫を先に引き当て、不足分を後の日付の在庫から引き当てる
+	assertQuantity(t, "stock of the requested date", c.stock(t, testDate), 0)
+	assertQuantity(t, "stock of the later date", c.stock(t, laterDate), 0)
+	assertQuantity(t, "confirmed quantity", c.itemScheduleLine(t).ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, 7)
+	if res.StockReservation == nil || len(*res.StockReservation) != 1 {
+		t.Fatalf("stock reservation is not returned: %+v", res)
+	}
+	reservation := (*res.StockReservation)[0]
+	assertQuantity(t, "reserved quantity", reservation.ConfirmedQuantityInBaseUnit.Decimal, 7)
+	assertQuantity(t, "shortfall quantity", reservation.ShortfallQuantityInBaseUnit.Decimal, 3)
+	if len(reservation.StockAllocation) != 2 ||
+		reservation.StockAllocation[0].ProductStockAvailabilityDate != testDate ||
+		reservation.StockAllocation[1].ProductStockAvailabilityDate != laterDate {
+		t.Errorf("stock allocation = %+v, want %s then %s", reservation.StockAllocation, testDate, laterDate)
+	}
+
+	// 再度キャンセルすると、引き当てた日付の在庫にそれぞれ戻す
+	_, errs = c.call(t, testInput("cancels", "cancel", true))
+	mustNoErrors(t, errs)
+	assertQuantity(t, "stock of the requested date after cancel", c.stock(t, testDate), 4)
+	assertQuantity(t, "stock of the later date after cancel", c.stock(t, laterDate), 3)
+}
+
+func TestReactivationWithoutOrderQuantityReservesNothing(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+	order := testOrder(decimal.Zero, decimal.Zero)
+	order.Header.IsCancelled = getBoolPtr(true)
+	order.Item[0].IsCancelled = getBoolPtr(true)
+	order.ItemScheduleLine[0].IsCancelled = getBoolPtr(true)
+	order.ItemScheduleLine[0].ScheduleLineOrderQuantityInBaseUnit = nil
+	c.orders.Seed(order)
+
+	_, errs := c.call(t, testInput("cancels", "reactivate", false))
+	mustNoErrors(t, errs)
+	itemScheduleLine := c.itemScheduleLine(t)
+	if isTrue(itemScheduleLine.IsCancelled) || itemScheduleLine.ScheduleLineOrderQuantityInBaseUnit != nil {
+		t.Errorf("schedule line after reactivation = %+v", itemScheduleLine)
+	}
+	assertQuantity(t, "stock after reactivation", c.stock(t, testDate), 100)
+}
+
+func TestItemCancelWithoutItemsIsNotFound(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	input := testInput("cancels", "cancel", true)
+	input.Header.IsCancelled = nil
+	input.Header.Item = nil
+	input.Accepter = []string{"Item"}
+	res, errs := c.call(t, input)
+	mustNoErrors(t, errs)
+	if res.Item == nil || len(*res.Item) != 0 {
+		t.Errorf("items are cancelled though no item is specified: %+v", res)
+	}
+	if isTrue(c.item(t).IsCancelled) {
+		t.Error("item is cancelled though no item is specified")
+	}
+
+	input = testInput("cancels", "missing", true)
+	input.Header.Item[0].IsCancelled = nil
+	input.Accepter = []string{"Item"}
+	if _, errs := c.call(t, input); len(errs) == 0 {
+		t.Error("item cancel without IsCancelled succeeded")
+	}
+}
+
+func TestFailedCancelIsCompensated(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+	c.writer = &failingWriter{SQLWriter: c.writer, failScheduleLine: true}
+
+	if _, errs := c.call(t, testInput("cancels", "failed", true)); len(errs) == 0 {
+		t.Fatal("cancel succeeded though the schedule line cannot be updated")
+	}
+	if isTrue(c.header(t).IsCancelled) || isTrue(c.item(t).IsCancelled) || isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Error("updates applied before the failure are not compensated")
+	}
+	assertQuantity(t, "confirmed quantity after compensation", c.itemScheduleLine(t).ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, 10)
+	assertQuantity(t, "stock after compensation", c.stock(t, testDate), 100)
+	movements, err := c.stockLedger.List(StockMovementQuery{OrderID: getIntPtr(testOrderID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 引当解除の記録は、補償処理の記録で打ち消される
+	if len(movements) != 2 || movements[1].MovementType != StockMovementCompensation || !movements[0].Quantity.Add(movements[1].Quantity).IsZero() {
+		t.Errorf("stock movements = %+v, want a release cancelled out by a compensation", movements)
+	}
+}
+
+func getStringPtr(s string) *string {
+	return &s
+}
+
+func getIntPtr(i int) *int {
+	return &i
+}
+
+// 明細のキャンセルで Orders の IsCancelled が指定されていない場合は、スケジュール行の引当を解除しない
+func TestItemCancelWithoutHeaderIsCancelledKeepsReservation(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	input := testInput("cancels", "cancel", true)
+	input.Header.IsCancelled = nil
+	input.Accepter = []string{"Item"}
+	_, errs := c.call(t, input)
+	mustNoErrors(t, errs)
+	if !isTrue(c.item(t).IsCancelled) {
+		t.Error("item is not cancelled")
+	}
+	assertQuantity(t, "confirmed quantity", c.itemScheduleLine(t).ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, 10)
+	assertQuantity(t, "stock after item cancel", c.stock(t, testDate), 100)
+}
+
+func TestCancelWithUnconfiguredBaseUnitFails(t *testing.T) {
+	t.Setenv("QUANTITY_UNIT_SCALES", `{"KG": 3}`)
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	if _, errs := c.call(t, testInput("cancels", "cancel", true)); len(errs) == 0 {
+		t.Fatal("cancel of a schedule line with an unconfigured base unit succeeded")
+	}
+	if isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Error("schedule line is cancelled")
+	}
+	assertQuantity(t, "stock after failed cancel", c.stock(t, testDate), 100)
+
+	if _, errs := c.call(t, partialCancelInput("partial", 5)); len(errs) == 0 {
+		t.Fatal("partial cancel of a schedule line with an unconfigured base unit succeeded")
+	}
+	assertQuantity(t, "stock after failed partial cancel", c.stock(t, testDate), 100)
+}
@@ -0,0 +1,58 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+
+	"golang.org/x/xerrors"
+)
+
+// validateCancellationReasonCodes は、入力のキャンセル理由コードがマスタに存在するかを検証します
+func (c *DPFMAPICaller) validateCancellationReasonCodes(
+	input *dpfm_api_input_reader.SDC,
+) error {
+	reasonCodes := c.conf.Cancellation.ReasonCodes()
+	if len(reasonCodes) == 0 {
+		return nil
+	}
+	valid := make(map[string]struct{}, len(reasonCodes))
+	for _, v := range reasonCodes {
+		valid[v] = struct{}{}
+	}
+	check := func(reasonCode *string) error {
+		if reasonCode == nil {
+			return nil
+		}
+		if _, ok := valid[*reasonCode]; !ok {
+			return xerrors.Errorf("invalid cancellation reason code: %s", *reasonCode)
+		}
+		return nil
+	}
+
+	if err := check(input.Header.CancellationReasonCode); err != nil {
+		return err
+	}
+	for _, item := range input.Header.Item {
+		if err := check(item.CancellationReasonCode); err != nil {
+			return err
+		}
+		for _, itemScheduleLine := range item.ItemScheduleLine {
+			if err := check(itemScheduleLine.CancellationReasonCode); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inheritCancellationReason は、キャンセル理由が指定されていない場合に上位の階層の理由を引き継ぎます
+func inheritCancellationReason(
+	reasonCode *string,
+	comment *string,
+	parentReasonCode *string,
+	parentComment *string,
+) (*string, *string) {
+	if reasonCode != nil {
+		return reasonCode, comment
+	}
+	return parentReasonCode, parentComment
+}
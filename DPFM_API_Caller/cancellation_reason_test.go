@@ -0,0 +1,76 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	"testing"
+)
+
+func TestUnknownCancellationReasonCodeIsRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		input func() *dpfm_api_input_reader.SDC
+	}{
+		{
+			name: "Header",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "header", true)
+				input.Header.CancellationReasonCode = getStringPtr("UNKNOWN")
+				return input
+			},
+		},
+		{
+			name: "Item",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "item", true)
+				input.Header.Item[0].CancellationReasonCode = getStringPtr("UNKNOWN")
+				return input
+			},
+		},
+		{
+			name: "ItemScheduleLine",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := partialCancelInput("item-schedule-line", 5)
+				input.Header.Item[0].ItemScheduleLine[0].CancellationReasonCode = getStringPtr("UNKNOWN")
+				return input
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CANCELLATION_REASON_CODES", "CUSTOMER_REQUEST,OUT_OF_STOCK")
+			c := newTestCaller(t, testStock(testDate, 100))
+
+			if _, errs := c.call(t, tt.input()); len(errs) == 0 {
+				t.Fatal("unknown cancellation reason code is accepted")
+			}
+			if isTrue(c.header(t).IsCancelled) || isTrue(c.itemScheduleLine(t).IsCancelled) {
+				t.Error("order is cancelled with an unknown reason code")
+			}
+			assertQuantity(t, "stock after rejected cancel", c.stock(t, testDate), 100)
+		})
+	}
+}
+
+func TestCancellationReasonIsInherited(t *testing.T) {
+	t.Setenv("CANCELLATION_REASON_CODES", "CUSTOMER_REQUEST,OUT_OF_STOCK")
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	input := testInput("cancels", "cancel", true)
+	input.Header.CancellationReasonCode = getStringPtr("CUSTOMER_REQUEST")
+	input.Header.CancellationComment = getStringPtr("ordered by mistake")
+	input.Header.Item[0].CancellationReasonCode = getStringPtr("OUT_OF_STOCK")
+	_, errs := c.call(t, input)
+	mustNoErrors(t, errs)
+
+	if v := c.header(t).CancellationReasonCode; v == nil || *v != "CUSTOMER_REQUEST" {
+		t.Errorf("header reason code = %v, want CUSTOMER_REQUEST", v)
+	}
+	item := c.item(t)
+	if item.CancellationReasonCode == nil || *item.CancellationReasonCode != "OUT_OF_STOCK" || item.CancellationComment != nil {
+		t.Errorf("item reason = %v %v, want OUT_OF_STOCK without comment", item.CancellationReasonCode, item.CancellationComment)
+	}
+	// 理由が指定されていないスケジュール行は、明細の理由を引き継ぐ
+	if v := c.itemScheduleLine(t).CancellationReasonCode; v == nil || *v != "OUT_OF_STOCK" {
+		t.Errorf("schedule line reason code = %v, want OUT_OF_STOCK", v)
+	}
+}
@@ -0,0 +1,178 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"encoding/json"
+	"time"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"golang.org/x/xerrors"
+)
+
+// requestCancellation は、買い手のみの呼び出し元によるキャンセルを、売り手が確定するまで保留する依頼として登録します
+// 依頼として登録しない場合は false を返します。preview の場合は登録せずに、登録される依頼を返す
+func (c *DPFMAPICaller) requestCancellation(
+	input *dpfm_api_input_reader.SDC,
+	accepter []string,
+	preview bool,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.Message, bool, error) {
+	headerPartner, roles, err := partnerRoles(c.orders, input, log)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(roles) != 1 || roles[0] != roleBuyer {
+		return nil, false, nil
+	}
+	actions := requestedActions(input, accepter)
+	if len(actions) != 1 || actions[0] != actionCancel {
+		// キャンセル取消を含む場合は、権限の検証でエラーにする
+		return nil, false, nil
+	}
+	if err := c.validateCancellationReasonCodes(input); err != nil {
+		return nil, false, err
+	}
+
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return nil, false, xerrors.Errorf("cancellation request input marshal error: %w", err)
+	}
+	request := &CancellationRequest{
+		RuntimeSessionID: input.RuntimeSessionID,
+		OrderID:          input.Header.OrderID,
+		Buyer:            headerPartner.Buyer,
+		Seller:           headerPartner.Seller,
+		Accepter:         accepter,
+		Input:            raw,
+	}
+	if preview {
+		request.Status = CancellationRequestPending
+		request.CreationDateTime = time.Now().UTC().Truncate(time.Second)
+		return &dpfm_api_output_formatter.Message{
+			CancellationRequest: convertToCancellationRequest(request),
+		}, true, nil
+	}
+	request, err = c.cancellationRequests.Save(request)
+	if err != nil {
+		return nil, false, err
+	}
+	log.Info("order %d: cancellation is requested by buyer %d (request %d)", request.OrderID, request.Buyer, request.CancellationRequestID)
+	return &dpfm_api_output_formatter.Message{
+		CancellationRequest: convertToCancellationRequest(request),
+	}, true, nil
+}
+
+func (c *DPFMAPICaller) cancellationRequestList(
+	input *dpfm_api_input_reader.SDC,
+) (*[]dpfm_api_output_formatter.CancellationRequest, error) {
+	var orderID *int
+	if input.Header.OrderID != 0 {
+		orderID = &input.Header.OrderID
+	}
+	requests, err := c.cancellationRequests.List(input.BusinessPartner, orderID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dpfm_api_output_formatter.CancellationRequest, 0, len(requests))
+	for i := range requests {
+		res = append(res, *convertToCancellationRequest(&requests[i]))
+	}
+	return &res, nil
+}
+
+// cancellationRequestConfirm は、売り手が確定したキャンセル依頼を、依頼時の入力で売り手のキャンセルとして実行します
+// キャンセルに失敗した場合は、依頼を保留中に戻します
+func (c *DPFMAPICaller) cancellationRequestConfirm(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.Message, error) {
+	request, err := c.sellerCancellationRequest(input)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := c.cancellationRequests.Transition(request.CancellationRequestID, CancellationRequestPending, CancellationRequestConfirmed)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		request, err = c.cancellationRequests.Get(request.CancellationRequestID)
+		if err != nil {
+			return nil, err
+		}
+		// 確定済みの依頼は、再配送されたメッセージとして前回のキャンセル結果を返す
+		if request.Status != CancellationRequestConfirmed {
+			return nil, xerrors.Errorf("cancellation request %d is %s", request.CancellationRequestID, request.Status)
+		}
+	}
+
+	var requestedInput dpfm_api_input_reader.SDC
+	var output dpfm_api_output_formatter.SDC
+	if err := json.Unmarshal(request.Input, &requestedInput); err != nil {
+		return nil, xerrors.Errorf("cancellation request input unmarshal error: %w", err)
+	}
+	if err := json.Unmarshal(request.Input, &output); err != nil {
+		return nil, xerrors.Errorf("cancellation request input unmarshal error: %w", err)
+	}
+	requestedInput.BusinessPartner = request.Seller
+	res, err := c.cancels(&requestedInput, &output, request.Accepter, log)
+	if err != nil {
+		if ok {
+			if _, rerr := c.cancellationRequests.Transition(request.CancellationRequestID, CancellationRequestConfirmed, CancellationRequestPending); rerr != nil {
+				log.Error("%+v", rerr)
+			}
+		}
+		return res, err
+	}
+	log.Info("cancellation request %d is confirmed by seller %d", request.CancellationRequestID, request.Seller)
+	return res, nil
+}
+
+func (c *DPFMAPICaller) cancellationRequestReject(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.CancellationRequest, error) {
+	request, err := c.sellerCancellationRequest(input)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := c.cancellationRequests.Transition(request.CancellationRequestID, CancellationRequestPending, CancellationRequestRejected)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, xerrors.Errorf("pending cancellation request %d: %w", request.CancellationRequestID, errNotFound)
+	}
+	log.Info("cancellation request %d is rejected by seller %d", request.CancellationRequestID, request.Seller)
+	request.Status = CancellationRequestRejected
+	return convertToCancellationRequest(request), nil
+}
+
+// sellerCancellationRequest は、呼び出し元が売り手であるキャンセル依頼を返します
+func (c *DPFMAPICaller) sellerCancellationRequest(
+	input *dpfm_api_input_reader.SDC,
+) (*CancellationRequest, error) {
+	if input.CancellationRequestID == nil {
+		return nil, xerrors.New("CancellationRequestID is required")
+	}
+	request, err := c.cancellationRequests.Get(*input.CancellationRequestID)
+	if err != nil {
+		return nil, err
+	}
+	if request.Seller != input.BusinessPartner {
+		return nil, xerrors.Errorf("business partner %d is not the seller of cancellation request %d", input.BusinessPartner, request.CancellationRequestID)
+	}
+	return request, nil
+}
+
+func convertToCancellationRequest(request *CancellationRequest) *dpfm_api_output_formatter.CancellationRequest {
+	return &dpfm_api_output_formatter.CancellationRequest{
+		CancellationRequestID: request.CancellationRequestID,
+		OrderID:               request.OrderID,
+		Buyer:                 request.Buyer,
+		Seller:                request.Seller,
+		Accepter:              request.Accepter,
+		Status:                request.Status,
+		CreationDateTime:      request.CreationDateTime.Format(time.RFC3339),
+	}
+}
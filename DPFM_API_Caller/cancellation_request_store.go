@@ -0,0 +1,244 @@
+package dpfm_api_caller
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/latonaio/golang-mysql-network-connector"
+	"golang.org/x/xerrors"
+)
+
+const (
+	CancellationRequestPending   = "Pending"
+	CancellationRequestConfirmed = "Confirmed"
+	CancellationRequestRejected  = "Rejected"
+)
+
+// CancellationRequestStore は、買い手が依頼したキャンセルを売り手が確定または却下するまで保持します
+type CancellationRequestStore interface {
+	// Save は、キャンセル依頼を登録します。同じ依頼が登録済みの場合は登録済みのものを返します
+	Save(request *CancellationRequest) (*CancellationRequest, error)
+	Get(id int) (*CancellationRequest, error)
+	// List は、businessPartner が買い手または売り手であるキャンセル依頼を返します
+	List(businessPartner int, orderID *int) ([]CancellationRequest, error)
+	// Transition は、状態が from のキャンセル依頼のみを to に更新します。更新できなかった場合は false を返します
+	Transition(id int, from, to string) (bool, error)
+}
+
+type CancellationRequest struct {
+	CancellationRequestID int
+	RuntimeSessionID      string
+	OrderID               int
+	Buyer                 int
+	Seller                int
+	Accepter              []string
+	Input                 []byte
+	Status                string
+	CreationDateTime      time.Time
+}
+
+type MySQLCancellationRequestStore struct {
+	db *database.Mysql
+}
+
+func NewMySQLCancellationRequestStore(db *database.Mysql) (*MySQLCancellationRequestStore, error) {
+	_, err := db.Exec(
+		`CREATE TABLE IF NOT EXISTS DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_cancellation_request_data (
+			CancellationRequestID int(16) NOT NULL AUTO_INCREMENT,
+			RuntimeSessionID varchar(100) NOT NULL,
+			OrderID int(16) NOT NULL,
+			Buyer int(12) NOT NULL,
+			Seller int(12) NOT NULL,
+			Accepter varchar(100) NOT NULL,
+			Input json NOT NULL,
+			Status varchar(20) NOT NULL,
+			CreationDateTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			LastChangeDateTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (CancellationRequestID),
+			UNIQUE KEY (RuntimeSessionID, OrderID, Accepter),
+			KEY (Buyer, OrderID),
+			KEY (Seller, OrderID)
+		);`,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("cancellation request table create error: %w", err)
+	}
+	return &MySQLCancellationRequestStore{db: db}, nil
+}
+
+const cancellationRequestColumns = `CancellationRequestID, RuntimeSessionID, OrderID, Buyer, Seller, Accepter, Input, Status, CreationDateTime`
+
+func (s *MySQLCancellationRequestStore) Save(request *CancellationRequest) (*CancellationRequest, error) {
+	accepter := strings.Join(request.Accepter, ",")
+	_, err := s.db.Exec(
+		`INSERT IGNORE INTO DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_cancellation_request_data
+		(RuntimeSessionID, OrderID, Buyer, Seller, Accepter, Input, Status) VALUES (?, ?, ?, ?, ?, ?, ?);`,
+		request.RuntimeSessionID, request.OrderID, request.Buyer, request.Seller, accepter, request.Input, CancellationRequestPending,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("cancellation request write error: %w", err)
+	}
+	saved, err := s.query(`WHERE (RuntimeSessionID, OrderID, Accepter) = (?, ?, ?)`, request.RuntimeSessionID, request.OrderID, accepter)
+	if err != nil {
+		return nil, err
+	}
+	if len(saved) == 0 {
+		return nil, xerrors.Errorf("cancellation request read error: %w", errNotFound)
+	}
+	return &saved[0], nil
+}
+
+func (s *MySQLCancellationRequestStore) Get(id int) (*CancellationRequest, error) {
+	requests, err := s.query(`WHERE CancellationRequestID = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return nil, xerrors.Errorf("cancellation request %d: %w", id, errNotFound)
+	}
+	return &requests[0], nil
+}
+
+func (s *MySQLCancellationRequestStore) List(businessPartner int, orderID *int) ([]CancellationRequest, error) {
+	where := `WHERE (Buyer = ? OR Seller = ?)`
+	args := []interface{}{businessPartner, businessPartner}
+	if orderID != nil {
+		where += ` AND OrderID = ?`
+		args = append(args, *orderID)
+	}
+	return s.query(where, args...)
+}
+
+func (s *MySQLCancellationRequestStore) Transition(id int, from, to string) (bool, error) {
+	res, err := s.db.Exec(
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_cancellation_request_data
+		SET Status = ? WHERE CancellationRequestID = ? AND Status = ?;`, to, id, from,
+	)
+	if err != nil {
+		return false, xerrors.Errorf("cancellation request %d write error: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, xerrors.Errorf("cancellation request %d write error: %w", id, err)
+	}
+	return n == 1, nil
+}
+
+func (s *MySQLCancellationRequestStore) query(where string, args ...interface{}) ([]CancellationRequest, error) {
+	rows, err := s.db.Query(
+		`SELECT `+cancellationRequestColumns+`
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_cancellation_request_data
+		`+where+` ORDER BY CancellationRequestID;`, args...,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("cancellation request read error: %w", err)
+	}
+	return scanCancellationRequests(rows)
+}
+
+func scanCancellationRequests(rows *sql.Rows) ([]CancellationRequest, error) {
+	defer rows.Close()
+	requests := make([]CancellationRequest, 0)
+	for rows.Next() {
+		var (
+			r                CancellationRequest
+			accepter         string
+			creationDateTime string
+		)
+		err := rows.Scan(
+			&r.CancellationRequestID,
+			&r.RuntimeSessionID,
+			&r.OrderID,
+			&r.Buyer,
+			&r.Seller,
+			&accepter,
+			&r.Input,
+			&r.Status,
+			&creationDateTime,
+		)
+		if err != nil {
+			return nil, xerrors.Errorf("cancellation request scan error: %w", err)
+		}
+		r.Accepter = strings.Split(accepter, ",")
+		if r.CreationDateTime, err = time.ParseInLocation(scheduledDateTimeLayout, creationDateTime, time.UTC); err != nil {
+			return nil, xerrors.Errorf("cancellation request scan error: %w", err)
+		}
+		requests = append(requests, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("cancellation request scan error: %w", err)
+	}
+	return requests, nil
+}
+
+type MemoryCancellationRequestStore struct {
+	mtx      sync.Mutex
+	lastID   int
+	requests map[int]*CancellationRequest
+}
+
+func NewMemoryCancellationRequestStore() *MemoryCancellationRequestStore {
+	return &MemoryCancellationRequestStore{
+		requests: make(map[int]*CancellationRequest),
+	}
+}
+
+func (s *MemoryCancellationRequestStore) Save(request *CancellationRequest) (*CancellationRequest, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	accepter := strings.Join(request.Accepter, ",")
+	for _, r := range s.requests {
+		if r.RuntimeSessionID == request.RuntimeSessionID && r.OrderID == request.OrderID && strings.Join(r.Accepter, ",") == accepter {
+			saved := *r
+			return &saved, nil
+		}
+	}
+	s.lastID++
+	r := *request
+	r.CancellationRequestID = s.lastID
+	r.Status = CancellationRequestPending
+	r.CreationDateTime = time.Now().UTC().Truncate(time.Second)
+	s.requests[r.CancellationRequestID] = &r
+	saved := r
+	return &saved, nil
+}
+
+func (s *MemoryCancellationRequestStore) Get(id int) (*CancellationRequest, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	r, ok := s.requests[id]
+	if !ok {
+		return nil, xerrors.Errorf("cancellation request %d: %w", id, errNotFound)
+	}
+	request := *r
+	return &request, nil
+}
+
+func (s *MemoryCancellationRequestStore) List(businessPartner int, orderID *int) ([]CancellationRequest, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	requests := make([]CancellationRequest, 0)
+	for _, r := range s.requests {
+		if (r.Buyer == businessPartner || r.Seller == businessPartner) && (orderID == nil || r.OrderID == *orderID) {
+			requests = append(requests, *r)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CancellationRequestID < requests[j].CancellationRequestID
+	})
+	return requests, nil
+}
+
+func (s *MemoryCancellationRequestStore) Transition(id int, from, to string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	r, ok := s.requests[id]
+	if !ok || r.Status != from {
+		return false, nil
+	}
+	r.Status = to
+	return true, nil
+}
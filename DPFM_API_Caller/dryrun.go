@@ -0,0 +1,183 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+)
+
+// dryRunStore は、プレビューの更新を書き込まずに保持し、以降の読み込みに反映します
+// 同じ処理内で先に更新したヘッダ・明細・スケジュール行・在庫を、更新後の値で読み込むために使用する
+type dryRunStore struct {
+	orders            OrdersRepository
+	stocks            StockRepository
+	headers           map[int]dpfm_api_output_formatter.Header
+	items             map[[2]int]dpfm_api_output_formatter.Item
+	itemScheduleLines map[[3]int][]dpfm_api_output_formatter.ItemScheduleLine
+	productStocks     map[productStockKey]dpfm_api_output_formatter.ProductStock
+}
+
+func newDryRunStore(orders OrdersRepository, stocks StockRepository) *dryRunStore {
+	return &dryRunStore{
+		orders:            orders,
+		stocks:            stocks,
+		headers:           make(map[int]dpfm_api_output_formatter.Header),
+		items:             make(map[[2]int]dpfm_api_output_formatter.Item),
+		itemScheduleLines: make(map[[3]int][]dpfm_api_output_formatter.ItemScheduleLine),
+		productStocks:     make(map[productStockKey]dpfm_api_output_formatter.ProductStock),
+	}
+}
+
+func (d *dryRunStore) HeaderRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.Header {
+	header := d.orders.HeaderRead(input, log)
+	if header == nil {
+		return nil
+	}
+	if updated, ok := d.headers[header.OrderID]; ok {
+		header.IsCancelled = updated.IsCancelled
+		header.CancellationReasonCode = updated.CancellationReasonCode
+		header.CancellationComment = updated.CancellationComment
+	}
+	return header
+}
+
+func (d *dryRunStore) HeaderPartnerRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.HeaderPartner {
+	return d.orders.HeaderPartnerRead(input, log)
+}
+
+func (d *dryRunStore) OrdersByCriteriaRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *[]int {
+	return d.orders.OrdersByCriteriaRead(input, log)
+}
+
+func (d *dryRunStore) ItemsRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *[]dpfm_api_output_formatter.Item {
+	items := d.orders.ItemsRead(input, log)
+	if items == nil {
+		return nil
+	}
+	for i := range *items {
+		item := &(*items)[i]
+		if updated, ok := d.items[[2]int{item.OrderID, item.OrderItem}]; ok {
+			item.IsCancelled = updated.IsCancelled
+			item.CancellationReasonCode = updated.CancellationReasonCode
+			item.CancellationComment = updated.CancellationComment
+		}
+	}
+	return items
+}
+
+func (d *dryRunStore) ItemScheduleLineRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *[]dpfm_api_output_formatter.ItemScheduleLine {
+	itemScheduleLines := d.orders.ItemScheduleLineRead(input, log)
+	if itemScheduleLines == nil {
+		return nil
+	}
+	for i := range *itemScheduleLines {
+		itemScheduleLine := &(*itemScheduleLines)[i]
+		for _, update := range d.itemScheduleLines[[3]int{itemScheduleLine.OrderID, itemScheduleLine.OrderItem, itemScheduleLine.ScheduleLine}] {
+			applyScheduleLineUpdate(itemScheduleLine, update)
+		}
+	}
+	return itemScheduleLines
+}
+
+func (d *dryRunStore) ProductStockAvailabilityRead(
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.ProductStock {
+	if productStock, ok := d.productStock(itemScheduleLine, ""); ok {
+		return productStock
+	}
+	return d.stocks.ProductStockAvailabilityRead(itemScheduleLine, log)
+}
+
+func (d *dryRunStore) ProductStockAvailabilityByBatchRead(
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.ProductStock {
+	if itemScheduleLine.StockConfirmationPlantBatch != nil {
+		if productStock, ok := d.productStock(itemScheduleLine, *itemScheduleLine.StockConfirmationPlantBatch); ok {
+			return productStock
+		}
+	}
+	return d.stocks.ProductStockAvailabilityByBatchRead(itemScheduleLine, log)
+}
+
+func (d *dryRunStore) ProductStockCandidatesRead(
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	availabilityDateTo *string,
+	otherBatches bool,
+	log *logger.Logger,
+) *[]dpfm_api_output_formatter.ProductStock {
+	productStocks := d.stocks.ProductStockCandidatesRead(itemScheduleLine, availabilityDateTo, otherBatches, log)
+	if productStocks == nil {
+		return nil
+	}
+	for i := range *productStocks {
+		if updated, ok := d.productStocks[keyOfProductStock((*productStocks)[i])]; ok {
+			(*productStocks)[i] = updated
+		}
+	}
+	return productStocks
+}
+
+func (d *dryRunStore) productStock(
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	batch string,
+) (*dpfm_api_output_formatter.ProductStock, bool) {
+	if itemScheduleLine.RequestedDeliveryDate == nil {
+		return nil, false
+	}
+	productStock, ok := d.productStocks[productStockKey{
+		Product:                      itemScheduleLine.Product,
+		BusinessPartner:              itemScheduleLine.StockConfirmationBusinessPartner,
+		Plant:                        itemScheduleLine.StockConfirmationPlant,
+		Batch:                        batch,
+		ProductStockAvailabilityDate: *itemScheduleLine.RequestedDeliveryDate,
+	}]
+	return &productStock, ok
+}
+
+// dryRunStore の書き込みは、MemorySQLWriter と同様にキャンセル処理で変更する項目のみを更新します
+
+func (d *dryRunStore) UpdateHeader(sessionID string, header dpfm_api_output_formatter.Header) error {
+	d.headers[header.OrderID] = header
+	return nil
+}
+
+func (d *dryRunStore) UpdateItem(sessionID string, item dpfm_api_output_formatter.Item) error {
+	d.items[[2]int{item.OrderID, item.OrderItem}] = item
+	return nil
+}
+
+func (d *dryRunStore) UpdateScheduleLine(sessionID string, itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine) error {
+	// 更新ごとに変更する項目が異なるため、すべての更新を順に読み込み時に適用する
+	key := [3]int{itemScheduleLine.OrderID, itemScheduleLine.OrderItem, itemScheduleLine.ScheduleLine}
+	d.itemScheduleLines[key] = append(d.itemScheduleLines[key], itemScheduleLine)
+	return nil
+}
+
+func (d *dryRunStore) UpdateStock(sessionID string, productStock dpfm_api_output_formatter.ProductStock) error {
+	productStock.Batch = ""
+	d.productStocks[keyOfProductStock(productStock)] = productStock
+	return nil
+}
+
+func (d *dryRunStore) UpdateStockByBatch(sessionID string, productStock dpfm_api_output_formatter.ProductStock) error {
+	d.productStocks[keyOfProductStock(productStock)] = productStock
+	return nil
+}
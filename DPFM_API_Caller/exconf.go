@@ -0,0 +1,100 @@
+package dpfm_api_caller
+
+import (
+	"context"
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"strings"
+	"sync"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"golang.org/x/xerrors"
+)
+
+// exconfProcess は、キャンセル対象のオーダーと取引先の存在確認を、存在性チェックのキューに並行して依頼します
+// すべての応答を待ち、1 つでも存在しない場合は ExconfResult を false にしてキャンセルを行わない
+func (c *DPFMAPICaller) exconfProcess(
+	input *dpfm_api_input_reader.SDC,
+	output *dpfm_api_output_formatter.SDC,
+	log *logger.Logger,
+) error {
+	queues := c.conf.RMQ.QueueToExConf()
+	if len(queues) == 0 {
+		return nil
+	}
+	payload := map[string]interface{}{
+		"runtime_session_id": input.RuntimeSessionID,
+		"business_partner":   input.BusinessPartner,
+		"service_label":      input.ServiceLabel,
+		"api_type":           input.APIType,
+		"Orders": map[string]interface{}{
+			"OrderID": input.Header.OrderID,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.conf.RMQ.ExconfTimeout())
+	defer cancel()
+	wg := sync.WaitGroup{}
+	errs := make([]error, len(queues))
+	for i, queue := range queues {
+		wg.Add(1)
+		go func(i int, queue string) {
+			defer wg.Done()
+			res, err := c.rmq.SessionKeepRequest(ctx, queue, payload)
+			if err != nil {
+				errs[i] = xerrors.Errorf("%s: %v: %w", queue, err, errRMQ)
+				return
+			}
+			res.Success()
+			exist, ok := existenceConf(res.Data())
+			if !ok {
+				errs[i] = xerrors.Errorf("%s: ExistenceConf is not in the response: %w", queue, errRMQ)
+				return
+			}
+			if !exist {
+				errs[i] = xerrors.Errorf("%s: %w", queue, errNotExist)
+			}
+		}(i, queue)
+	}
+	wg.Wait()
+
+	notExist := make([]string, 0)
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !xerrors.Is(err, errNotExist) {
+			output.ExconfResult = getBoolPtr(false)
+			output.ExconfError = err.Error()
+			return err
+		}
+		notExist = append(notExist, queues[i])
+	}
+	if len(notExist) != 0 {
+		output.ExconfResult = getBoolPtr(false)
+		output.ExconfError = "data does not exist: " + strings.Join(notExist, ", ")
+		log.Info("order %d: %s", input.Header.OrderID, output.ExconfError)
+		return xerrors.Errorf("order %d existence confirmation failed: %w", input.Header.OrderID, errNotExist)
+	}
+	output.ExconfResult = getBoolPtr(true)
+	return nil
+}
+
+// existenceConf は、存在性チェックの応答に含まれる ExistenceConf をすべて確認します
+// ExistenceConf は応答のデータ種別ごとにネストされているため、再帰的に探索する
+func existenceConf(data map[string]interface{}) (bool, bool) {
+	exist, found := true, false
+	for k, v := range data {
+		switch d := v.(type) {
+		case bool:
+			if k == "ExistenceConf" {
+				exist, found = exist && d, true
+			}
+		case map[string]interface{}:
+			if e, ok := existenceConf(d); ok {
+				exist, found = exist && e, true
+			}
+		}
+	}
+	return exist, found
+}
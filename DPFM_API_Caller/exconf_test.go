@@ -0,0 +1,92 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+// newTestCallerWithExconf は、存在性チェックのキュー orders・business-partner に問い合わせる testCaller です
+func newTestCallerWithExconf(t *testing.T) (*testCaller, *testRMQ) {
+	t.Helper()
+	t.Setenv("RMQ_QUEUE_TO_EX_CONF", "orders,business-partner")
+	t.Setenv("EXCONF_TIMEOUT_SECONDS", "1")
+	c := newTestCaller(t, testStock(testDate, 100))
+	rmq := newTestRMQ()
+	rmq.respond["orders"] = existenceResponse(true)
+	rmq.respond["business-partner"] = existenceResponse(true)
+	c.rmq = rmq
+	return c, rmq
+}
+
+func TestExconfConfirmsEveryQueue(t *testing.T) {
+	c, rmq := newTestCallerWithExconf(t)
+
+	input := testInput("cancels", "cancel", true)
+	output := &dpfm_api_output_formatter.SDC{}
+	_, errs := c.AsyncCancels(input.Accepter, input, output, c.log)
+	mustNoErrors(t, errs)
+	if len(rmq.requests) != 2 {
+		t.Errorf("exconf requests = %v, want both queues", rmq.requests)
+	}
+	if output.ExconfResult == nil || !*output.ExconfResult {
+		t.Errorf("ExconfResult = %v, want true", output.ExconfResult)
+	}
+	assertQuantity(t, "stock after cancel", c.stock(t, testDate), 110)
+}
+
+func TestExconfFailureStopsCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(rmq *testRMQ)
+		wantErr error
+	}{
+		{
+			name: "not exist",
+			setup: func(rmq *testRMQ) {
+				rmq.respond["business-partner"] = existenceResponse(false)
+			},
+			wantErr: errNotExist,
+		},
+		{
+			name: "timeout",
+			setup: func(rmq *testRMQ) {
+				rmq.hang["business-partner"] = true
+			},
+			wantErr: errRMQ,
+		},
+		{
+			name: "ExistenceConf is not in the response",
+			setup: func(rmq *testRMQ) {
+				rmq.respond["business-partner"] = func(payload interface{}) (map[string]interface{}, error) {
+					return map[string]interface{}{"message": map[string]interface{}{}}, nil
+				}
+			},
+			wantErr: errRMQ,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rmq := newTestCallerWithExconf(t)
+			tt.setup(rmq)
+
+			input := testInput("cancels", "cancel", true)
+			output := &dpfm_api_output_formatter.SDC{}
+			_, errs := c.AsyncCancels(input.Accepter, input, output, c.log)
+			if len(errs) == 0 {
+				t.Fatal("cancel succeeded despite the exconf failure")
+			}
+			if !xerrors.Is(errs[0], tt.wantErr) {
+				t.Errorf("error = %v, want %v", errs[0], tt.wantErr)
+			}
+			if output.ExconfResult == nil || *output.ExconfResult || output.ExconfError == "" {
+				t.Errorf("ExconfResult = %v, ExconfError = %q, want the failure", output.ExconfResult, output.ExconfError)
+			}
+			if isTrue(c.itemScheduleLine(t).IsCancelled) {
+				t.Error("schedule line is cancelled")
+			}
+			assertQuantity(t, "stock after failed exconf", c.stock(t, testDate), 100)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package dpfm_api_caller
+
+import (
+	"crypto/sha256"
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	database "github.com/latonaio/golang-mysql-network-connector"
+	"golang.org/x/xerrors"
+)
+
+// errIdempotencyConflict は、処理済みの runtime_session_id で異なる内容のキャンセルが要求されたことを表します
+var errIdempotencyConflict = xerrors.New("runtime_session_id is already used for a different request")
+
+// IdempotencyStore は、処理済みのキャンセル要求とその結果を保持し、再配送されたメッセージの再処理を防ぎます
+type IdempotencyStore interface {
+	Load(key IdempotencyKey) (*IdempotencyRecord, bool, error)
+	Save(key IdempotencyKey, record *IdempotencyRecord) error
+}
+
+type IdempotencyKey struct {
+	RuntimeSessionID string
+	OrderID          int
+	Accepter         string
+}
+
+func NewIdempotencyKey(input *dpfm_api_input_reader.SDC, accepter []string) IdempotencyKey {
+	return IdempotencyKey{
+		RuntimeSessionID: input.RuntimeSessionID,
+		OrderID:          input.Header.OrderID,
+		Accepter:         strings.Join(accepter, ","),
+	}
+}
+
+// IdempotencyRecord は、処理済みのキャンセル要求の内容のハッシュと処理結果です
+type IdempotencyRecord struct {
+	PayloadHash string
+	Message     *dpfm_api_output_formatter.Message
+}
+
+// payloadHash は、キャンセル要求の Header・Item・ItemScheduleLine のハッシュです
+// 再配送されたメッセージと、同じ runtime_session_id で内容が異なる要求を区別するために使う
+func payloadHash(input *dpfm_api_input_reader.SDC) (string, error) {
+	raw, err := json.Marshal(input.Header)
+	if err != nil {
+		return "", xerrors.Errorf("idempotency payload marshal error: %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type MySQLIdempotencyStore struct {
+	db *database.Mysql
+}
+
+func NewMySQLIdempotencyStore(db *database.Mysql) (*MySQLIdempotencyStore, error) {
+	_, err := db.Exec(
+		`CREATE TABLE IF NOT EXISTS DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_idempotency_data (
+			RuntimeSessionID varchar(100) NOT NULL,
+			OrderID int(16) NOT NULL,
+			Accepter varchar(100) NOT NULL,
+			PayloadHash char(64) NOT NULL,
+			Message json NOT NULL,
+			CreationDateTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (RuntimeSessionID, OrderID, Accepter)
+		);`,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("idempotency table create error: %w", err)
+	}
+	return &MySQLIdempotencyStore{db: db}, nil
+}
+
+func (s *MySQLIdempotencyStore) Load(key IdempotencyKey) (*IdempotencyRecord, bool, error) {
+	var (
+		payloadHash string
+		raw         []byte
+	)
+	err := s.db.QueryRow(
+		`SELECT PayloadHash, Message
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_idempotency_data
+		WHERE (RuntimeSessionID, OrderID, Accepter) = (?, ?, ?);`, key.RuntimeSessionID, key.OrderID, key.Accepter,
+	).Scan(&payloadHash, &raw)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, xerrors.Errorf("idempotency read error: %w", err)
+	}
+
+	return unmarshalIdempotencyRecord(payloadHash, raw)
+}
+
+func (s *MySQLIdempotencyStore) Save(key IdempotencyKey, record *IdempotencyRecord) error {
+	return insertIdempotency(s.db, key, record)
+}
+
+func insertIdempotency(db execer, key IdempotencyKey, record *IdempotencyRecord) error {
+	raw, err := json.Marshal(record.Message)
+	if err != nil {
+		return xerrors.Errorf("idempotency message marshal error: %w", err)
+	}
+	_, err = db.Exec(
+		`INSERT IGNORE INTO DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_idempotency_data
+		(RuntimeSessionID, OrderID, Accepter, PayloadHash, Message) VALUES (?, ?, ?, ?, ?);`, key.RuntimeSessionID, key.OrderID, key.Accepter, record.PayloadHash, raw,
+	)
+	if err != nil {
+		return xerrors.Errorf("idempotency write error: %w", err)
+	}
+	return nil
+}
+
+func unmarshalIdempotencyRecord(payloadHash string, raw []byte) (*IdempotencyRecord, bool, error) {
+	message := dpfm_api_output_formatter.Message{}
+	if err := json.Unmarshal(raw, &message); err != nil {
+		return nil, false, xerrors.Errorf("idempotency message unmarshal error: %w", err)
+	}
+	return &IdempotencyRecord{PayloadHash: payloadHash, Message: &message}, true, nil
+}
+
+type memoryIdempotencyRecord struct {
+	payloadHash string
+	message     []byte
+}
+
+type MemoryIdempotencyStore struct {
+	mtx     sync.Mutex
+	records map[IdempotencyKey]memoryIdempotencyRecord
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: make(map[IdempotencyKey]memoryIdempotencyRecord),
+	}
+}
+
+func (s *MemoryIdempotencyStore) Load(key IdempotencyKey) (*IdempotencyRecord, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	record, ok := s.records[key]
+	if !ok {
+		return nil, false, nil
+	}
+	return unmarshalIdempotencyRecord(record.payloadHash, record.message)
+}
+
+func (s *MemoryIdempotencyStore) Save(key IdempotencyKey, record *IdempotencyRecord) error {
+	raw, err := json.Marshal(record.Message)
+	if err != nil {
+		return xerrors.Errorf("idempotency message marshal error: %w", err)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := s.records[key]; !ok {
+		s.records[key] = memoryIdempotencyRecord{payloadHash: record.PayloadHash, message: raw}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package dpfm_api_caller
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"golang.org/x/xerrors"
+)
+
+// failingIdempotencyStore は、処理済みの記録を失敗させます
+type failingIdempotencyStore struct {
+	IdempotencyStore
+}
+
+func (s *failingIdempotencyStore) Save(key IdempotencyKey, record *IdempotencyRecord) error {
+	return xerrors.Errorf("idempotency write error: %w", errSQL)
+}
+
+func TestRedeliveredCancelDoesNotReleaseStockTwice(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	first, errs := c.call(t, partialCancelInput("partial", 4))
+	mustNoErrors(t, errs)
+	redelivered, errs := c.call(t, partialCancelInput("partial", 4))
+	mustNoErrors(t, errs)
+
+	assertQuantity(t, "stock after redelivery", c.stock(t, testDate), 104)
+	assertQuantity(t, "confirmed quantity after redelivery", c.itemScheduleLine(t).ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, 6)
+	if len(*redelivered.ItemScheduleLine) != len(*first.ItemScheduleLine) || !(*redelivered.ItemScheduleLine)[0].ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Equal(decimal.NewFromInt(6)) {
+		t.Errorf("redelivered result = %+v, want the first result", redelivered)
+	}
+}
+
+func TestReusedRuntimeSessionIDWithDifferentPayloadIsRejected(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	_, errs := c.call(t, partialCancelInput("partial", 4))
+	mustNoErrors(t, errs)
+	_, errs = c.call(t, partialCancelInput("partial", 3))
+	if len(errs) == 0 || !xerrors.Is(errs[0], errIdempotencyConflict) {
+		t.Fatalf("errors = %v, want %v", errs, errIdempotencyConflict)
+	}
+	assertQuantity(t, "stock after rejected request", c.stock(t, testDate), 104)
+}
+
+func TestCancelSucceedsWhenIdempotencyCannotBeRecorded(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+	c.idempotency = &failingIdempotencyStore{IdempotencyStore: c.idempotency}
+
+	res, errs := c.call(t, testInput("cancels", "cancel", true))
+	mustNoErrors(t, errs)
+	if res == nil || !isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Fatalf("applied cancel is not returned as succeeded: %+v", res)
+	}
+	assertQuantity(t, "stock after cancel", c.stock(t, testDate), 110)
+}
@@ -0,0 +1,46 @@
+package dpfm_api_caller
+
+import (
+	"sync"
+)
+
+// keyedMutex は、キーごとに排他制御を行います
+// 同じオーダーや同じ在庫を更新する処理が並行して実行されないようにするために使用します
+type keyedMutex struct {
+	mtx   sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mtx  sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{
+		locks: make(map[string]*keyedLock),
+	}
+}
+
+// Lock は、キーのロックを取得し、ロックを解放する関数を返します
+func (m *keyedMutex) Lock(key string) func() {
+	m.mtx.Lock()
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mtx.Unlock()
+
+	l.mtx.Lock()
+	return func() {
+		l.mtx.Unlock()
+		m.mtx.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mtx.Unlock()
+	}
+}
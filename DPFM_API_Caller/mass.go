@@ -0,0 +1,255 @@
+package dpfm_api_caller
+
+import (
+	"context"
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"encoding/json"
+	"time"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"golang.org/x/xerrors"
+)
+
+// massCancels は、条件に一致するキャンセルされていないオーダーを一括キャンセルします
+// preview の場合は、一致したオーダーの件数と OrderID のみを返す
+// それ以外の場合は一括キャンセルを登録して、バックグラウンドで一定件数ずつキャンセルする（進捗は mass-cancels-status で参照する）
+func (c *DPFMAPICaller) massCancels(
+	input *dpfm_api_input_reader.SDC,
+	preview bool,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.MassCancellation, error) {
+	criteria := input.Criteria
+	if criteria.Buyer == nil && criteria.Seller == nil && criteria.RequestedDeliveryDateTo == nil && criteria.Product == nil && criteria.Plant == nil {
+		return nil, xerrors.New("at least one criteria is required for mass cancellation")
+	}
+	if !preview {
+		return c.registerMassCancellation(input, log)
+	}
+
+	orderIDs := c.orders.OrdersByCriteriaRead(input, log)
+	if orderIDs == nil {
+		return nil, xerrors.Errorf("orders by criteria read error: %w", errSQL)
+	}
+	return &dpfm_api_output_formatter.MassCancellation{
+		Matched:        len(*orderIDs),
+		MatchedOrderID: *orderIDs,
+		Batch:          make([]dpfm_api_output_formatter.MassCancellationBatch, 0),
+	}, nil
+}
+
+func (c *DPFMAPICaller) registerMassCancellation(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.MassCancellation, error) {
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return nil, xerrors.Errorf("mass cancellation input marshal error: %w", err)
+	}
+	mass, err := c.massCancellations.Save(&MassCancellation{
+		RuntimeSessionID: input.RuntimeSessionID,
+		BusinessPartner:  input.BusinessPartner,
+		Input:            raw,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info("mass cancellation %d is registered", mass.MassCancellationID)
+
+	select {
+	case c.massNotify <- struct{}{}:
+	default:
+	}
+	return convertToMassCancellation(mass)
+}
+
+func (c *DPFMAPICaller) massCancellationStatus(
+	input *dpfm_api_input_reader.SDC,
+) (*dpfm_api_output_formatter.MassCancellation, error) {
+	if input.MassCancellationID == nil {
+		return nil, xerrors.New("MassCancellationID is required")
+	}
+	mass, err := c.massCancellations.Get(*input.MassCancellationID)
+	if err != nil {
+		return nil, err
+	}
+	if mass.BusinessPartner != input.BusinessPartner {
+		return nil, xerrors.Errorf("mass cancellation %d of business partner %d: %w", mass.MassCancellationID, input.BusinessPartner, errNotFound)
+	}
+	return convertToMassCancellation(mass)
+}
+
+// RunMassCancellations は、停止の通知を受けるまで、登録された一括キャンセルを 1 件ずつ実行します
+// メッセージの受信ワーカーとは別に実行するため、長時間の一括キャンセルでも受信ワーカーは停止とみなされない
+func (c *DPFMAPICaller) RunMassCancellations(ctx context.Context, log *logger.Logger) {
+	ticker := time.NewTicker(c.conf.Cancellation.SchedulerInterval())
+	defer ticker.Stop()
+	for {
+		c.executeMassCancellations(ctx, log)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.massNotify:
+		}
+	}
+}
+
+func (c *DPFMAPICaller) executeMassCancellations(ctx context.Context, log *logger.Logger) {
+	claimTimeout := c.conf.Cancellation.SchedulerClaimTimeout()
+	pending, err := c.massCancellations.Pending(claimTimeout)
+	if err != nil {
+		log.Error("%+v", err)
+		return
+	}
+	for _, mass := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		ok, err := c.massCancellations.Claim(mass.MassCancellationID, claimTimeout)
+		if err != nil {
+			log.Error("%+v", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if mass.Status != MassCancellationPending {
+			// 中断した一括キャンセルは、キャンセルされていない残りのオーダーのみを再度キャンセルする
+			log.Info("mass cancellation %d is resumed from %s", mass.MassCancellationID, mass.Status)
+		}
+		if err := c.executeMassCancellation(ctx, &mass, log); err != nil {
+			log.Error("mass cancellation %d: %+v", mass.MassCancellationID, err)
+		}
+	}
+}
+
+// executeMassCancellation は、登録時の条件に一致するオーダーを BatchSize 件ずつキャンセルし、バッチごとに進捗を記録します
+// 停止の通知を受けた場合は、処理中のバッチの完了後に Interrupted として終了する
+// Interrupted の一括キャンセルは再度実行され、キャンセルされていない残りのオーダーのみがキャンセルされる
+func (c *DPFMAPICaller) executeMassCancellation(
+	ctx context.Context,
+	mass *MassCancellation,
+	log *logger.Logger,
+) error {
+	var input dpfm_api_input_reader.SDC
+	var output dpfm_api_output_formatter.SDC
+	if err := json.Unmarshal(mass.Input, &input); err != nil {
+		return c.finishMassCancellation(mass, MassCancellationFailed, nil, xerrors.Errorf("mass cancellation input unmarshal error: %w", err))
+	}
+	if err := json.Unmarshal(mass.Input, &output); err != nil {
+		return c.finishMassCancellation(mass, MassCancellationFailed, nil, xerrors.Errorf("mass cancellation input unmarshal error: %w", err))
+	}
+
+	orderIDs := c.orders.OrdersByCriteriaRead(&input, log)
+	if orderIDs == nil {
+		return c.finishMassCancellation(mass, MassCancellationFailed, nil, xerrors.Errorf("orders by criteria read error: %w", errSQL))
+	}
+	result := &dpfm_api_output_formatter.MassCancellation{
+		Matched:        len(*orderIDs),
+		MatchedOrderID: *orderIDs,
+		Batch:          make([]dpfm_api_output_formatter.MassCancellationBatch, 0),
+	}
+
+	criteria := input.Criteria
+	batchSize := c.conf.Cancellation.MassBatchSize()
+	if criteria.BatchSize != nil && *criteria.BatchSize > 0 {
+		batchSize = *criteria.BatchSize
+	}
+	for start := 0; start < len(*orderIDs); start += batchSize {
+		end := start + batchSize
+		if end > len(*orderIDs) {
+			end = len(*orderIDs)
+		}
+		if start != 0 {
+			select {
+			case <-ctx.Done():
+				log.Info("mass cancellation %d is interrupted: %d/%d processed", mass.MassCancellationID, result.Processed, result.Matched)
+				return c.finishMassCancellation(mass, MassCancellationInterrupted, result, nil)
+			case <-time.After(c.conf.Cancellation.MassBatchInterval()):
+			}
+		}
+
+		orders := make([]dpfm_api_input_reader.Header, 0, end-start)
+		for _, orderID := range (*orderIDs)[start:end] {
+			orders = append(orders, dpfm_api_input_reader.Header{
+				OrderID:                orderID,
+				IsCancelled:            getBoolPtr(true),
+				CancellationReasonCode: criteria.CancellationReasonCode,
+				CancellationComment:    criteria.CancellationComment,
+			})
+		}
+		bulk := c.cancelOrders(&input, &output, orders, []string{"Header"}, log)
+
+		result.Processed += len(orders)
+		result.Succeeded += bulk.Succeeded
+		result.Failed += bulk.Failed
+		result.Batch = append(result.Batch, dpfm_api_output_formatter.MassCancellationBatch{
+			Batch:       len(result.Batch) + 1,
+			Succeeded:   bulk.Succeeded,
+			Failed:      bulk.Failed,
+			OrderResult: bulk.OrderResult,
+		})
+		log.Info("mass cancellation %d progress: %d/%d processed, %d failed", mass.MassCancellationID, result.Processed, result.Matched, result.Failed)
+		if end < len(*orderIDs) {
+			if err := c.progressMassCancellation(mass, MassCancellationRunning, result); err != nil {
+				log.Error("%+v", err)
+			}
+		}
+	}
+	return c.finishMassCancellation(mass, MassCancellationCompleted, result, nil)
+}
+
+// finishMassCancellation は、一括キャンセルを status で終了します。処理できなかった場合は err を Error に記録します
+func (c *DPFMAPICaller) finishMassCancellation(
+	mass *MassCancellation,
+	status string,
+	result *dpfm_api_output_formatter.MassCancellation,
+	err error,
+) error {
+	if result == nil {
+		result = &dpfm_api_output_formatter.MassCancellation{
+			MatchedOrderID: make([]int, 0),
+			Batch:          make([]dpfm_api_output_formatter.MassCancellationBatch, 0),
+		}
+	}
+	if err != nil {
+		msg := err.Error()
+		result.Error = &msg
+	}
+	if perr := c.progressMassCancellation(mass, status, result); perr != nil {
+		if err != nil {
+			return xerrors.Errorf("%v, progress cannot be recorded: %w", err, perr)
+		}
+		return perr
+	}
+	return err
+}
+
+func (c *DPFMAPICaller) progressMassCancellation(
+	mass *MassCancellation,
+	status string,
+	result *dpfm_api_output_formatter.MassCancellation,
+) error {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return xerrors.Errorf("mass cancellation result marshal error: %w", err)
+	}
+	return c.massCancellations.Progress(mass.MassCancellationID, status, raw)
+}
+
+func convertToMassCancellation(mass *MassCancellation) (*dpfm_api_output_formatter.MassCancellation, error) {
+	res := &dpfm_api_output_formatter.MassCancellation{
+		MatchedOrderID: make([]int, 0),
+		Batch:          make([]dpfm_api_output_formatter.MassCancellationBatch, 0),
+	}
+	if mass.Result != nil {
+		if err := json.Unmarshal(mass.Result, res); err != nil {
+			return nil, xerrors.Errorf("mass cancellation result unmarshal error: %w", err)
+		}
+	}
+	res.MassCancellationID = mass.MassCancellationID
+	res.Status = mass.Status
+	res.CreationDateTime = mass.CreationDateTime.Format(time.RFC3339)
+	return res, nil
+}
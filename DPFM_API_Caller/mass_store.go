@@ -0,0 +1,280 @@
+package dpfm_api_caller
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	database "github.com/latonaio/golang-mysql-network-connector"
+	"golang.org/x/xerrors"
+)
+
+const (
+	MassCancellationPending     = "Pending"
+	MassCancellationRunning     = "Running"
+	MassCancellationCompleted   = "Completed"
+	MassCancellationInterrupted = "Interrupted"
+	MassCancellationFailed      = "Failed"
+)
+
+// MassCancellationStore は、バックグラウンドで実行する条件指定の一括キャンセルと、その進捗を保持します
+type MassCancellationStore interface {
+	// Save は、一括キャンセルを登録します。同じ一括キャンセルが登録済みの場合は登録済みのものを返します
+	Save(mass *MassCancellation) (*MassCancellation, error)
+	Get(id int) (*MassCancellation, error)
+	// Pending は、未実行または中断した一括キャンセルと、進捗を記録してから claimTimeout を過ぎた実行中の一括キャンセルを登録した順に返します
+	Pending(claimTimeout time.Duration) ([]MassCancellation, error)
+	// Claim は、未実行または中断した一括キャンセルを実行中にします。他のレプリカが実行中にした場合は false を返します
+	// 進捗を記録してから claimTimeout を過ぎた一括キャンセルは、実行したレプリカが停止したものとみなして再度実行中にします
+	Claim(id int, claimTimeout time.Duration) (bool, error)
+	// Progress は、一括キャンセルの状態と、それまでの処理結果を更新します
+	Progress(id int, status string, result []byte) error
+}
+
+// MassCancellation は、一括キャンセルの要求です。Result は MassCancellation の出力の JSON です
+type MassCancellation struct {
+	MassCancellationID int
+	RuntimeSessionID   string
+	BusinessPartner    int
+	Input              []byte
+	Status             string
+	Result             []byte
+	CreationDateTime   time.Time
+}
+
+type MySQLMassCancellationStore struct {
+	db *database.Mysql
+}
+
+func NewMySQLMassCancellationStore(db *database.Mysql) (*MySQLMassCancellationStore, error) {
+	_, err := db.Exec(
+		`CREATE TABLE IF NOT EXISTS DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_mass_cancellation_data (
+			MassCancellationID int(16) NOT NULL AUTO_INCREMENT,
+			RuntimeSessionID varchar(100) NOT NULL,
+			BusinessPartner int(12) NOT NULL,
+			Input json NOT NULL,
+			Status varchar(20) NOT NULL,
+			Result json DEFAULT NULL,
+			CreationDateTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			LastChangeDateTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (MassCancellationID),
+			UNIQUE KEY (RuntimeSessionID, BusinessPartner),
+			KEY (Status, MassCancellationID)
+		);`,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("mass cancellation table create error: %w", err)
+	}
+	return &MySQLMassCancellationStore{db: db}, nil
+}
+
+const massCancellationColumns = `MassCancellationID, RuntimeSessionID, BusinessPartner, Input, Status, Result, CreationDateTime`
+
+func (s *MySQLMassCancellationStore) Save(mass *MassCancellation) (*MassCancellation, error) {
+	_, err := s.db.Exec(
+		`INSERT IGNORE INTO DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_mass_cancellation_data
+		(RuntimeSessionID, BusinessPartner, Input, Status) VALUES (?, ?, ?, ?);`,
+		mass.RuntimeSessionID, mass.BusinessPartner, mass.Input, MassCancellationPending,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("mass cancellation write error: %w", err)
+	}
+	saved, err := s.query(`WHERE RuntimeSessionID = ? AND BusinessPartner = ?`, mass.RuntimeSessionID, mass.BusinessPartner)
+	if err != nil {
+		return nil, err
+	}
+	if len(saved) == 0 {
+		return nil, xerrors.Errorf("mass cancellation read error: %w", errNotFound)
+	}
+	return &saved[0], nil
+}
+
+func (s *MySQLMassCancellationStore) Get(id int) (*MassCancellation, error) {
+	masses, err := s.query(`WHERE MassCancellationID = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(masses) == 0 {
+		return nil, xerrors.Errorf("mass cancellation %d: %w", id, errNotFound)
+	}
+	return &masses[0], nil
+}
+
+func (s *MySQLMassCancellationStore) Pending(claimTimeout time.Duration) ([]MassCancellation, error) {
+	return s.query(
+		`WHERE Status IN (?, ?) OR (Status = ? AND LastChangeDateTime <= CURRENT_TIMESTAMP - INTERVAL ? SECOND)`,
+		MassCancellationPending, MassCancellationInterrupted, MassCancellationRunning, int(claimTimeout.Seconds()),
+	)
+}
+
+// Claim は、実行中にした日時を LastChangeDateTime に記録します
+// 状態が変わらない再度の実行中でも記録されるよう、LastChangeDateTime は明示的に更新する
+func (s *MySQLMassCancellationStore) Claim(id int, claimTimeout time.Duration) (bool, error) {
+	res, err := s.db.Exec(
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_mass_cancellation_data
+		SET Status = ?, LastChangeDateTime = CURRENT_TIMESTAMP
+		WHERE MassCancellationID = ?
+		AND (Status IN (?, ?) OR (Status = ? AND LastChangeDateTime <= CURRENT_TIMESTAMP - INTERVAL ? SECOND));`,
+		MassCancellationRunning, id,
+		MassCancellationPending, MassCancellationInterrupted, MassCancellationRunning, int(claimTimeout.Seconds()),
+	)
+	if err != nil {
+		return false, xerrors.Errorf("mass cancellation %d write error: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, xerrors.Errorf("mass cancellation %d write error: %w", id, err)
+	}
+	return n == 1, nil
+}
+
+func (s *MySQLMassCancellationStore) Progress(id int, status string, result []byte) error {
+	_, err := s.db.Exec(
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_mass_cancellation_data
+		SET Status = ?, Result = ?, LastChangeDateTime = CURRENT_TIMESTAMP WHERE MassCancellationID = ?;`, status, result, id,
+	)
+	if err != nil {
+		return xerrors.Errorf("mass cancellation %d write error: %w", id, err)
+	}
+	return nil
+}
+
+func (s *MySQLMassCancellationStore) query(where string, args ...interface{}) ([]MassCancellation, error) {
+	rows, err := s.db.Query(
+		`SELECT `+massCancellationColumns+`
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_mass_cancellation_data
+		`+where+` ORDER BY MassCancellationID;`, args...,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("mass cancellation read error: %w", err)
+	}
+	return scanMassCancellations(rows)
+}
+
+func scanMassCancellations(rows *sql.Rows) ([]MassCancellation, error) {
+	defer rows.Close()
+	masses := make([]MassCancellation, 0)
+	for rows.Next() {
+		var (
+			m                MassCancellation
+			creationDateTime string
+		)
+		err := rows.Scan(
+			&m.MassCancellationID,
+			&m.RuntimeSessionID,
+			&m.BusinessPartner,
+			&m.Input,
+			&m.Status,
+			&m.Result,
+			&creationDateTime,
+		)
+		if err != nil {
+			return nil, xerrors.Errorf("mass cancellation scan error: %w", err)
+		}
+		if m.CreationDateTime, err = time.ParseInLocation(scheduledDateTimeLayout, creationDateTime, time.UTC); err != nil {
+			return nil, xerrors.Errorf("mass cancellation scan error: %w", err)
+		}
+		masses = append(masses, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("mass cancellation scan error: %w", err)
+	}
+	return masses, nil
+}
+
+type MemoryMassCancellationStore struct {
+	mtx    sync.Mutex
+	lastID int
+	masses map[int]*MassCancellation
+	// 実行中にした日時、または最後に進捗を記録した日時
+	claimed map[int]time.Time
+}
+
+func NewMemoryMassCancellationStore() *MemoryMassCancellationStore {
+	return &MemoryMassCancellationStore{
+		masses:  make(map[int]*MassCancellation),
+		claimed: make(map[int]time.Time),
+	}
+}
+
+func (s *MemoryMassCancellationStore) Save(mass *MassCancellation) (*MassCancellation, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, m := range s.masses {
+		if m.RuntimeSessionID == mass.RuntimeSessionID && m.BusinessPartner == mass.BusinessPartner {
+			saved := *m
+			return &saved, nil
+		}
+	}
+	s.lastID++
+	m := *mass
+	m.MassCancellationID = s.lastID
+	m.Status = MassCancellationPending
+	m.Result = nil
+	m.CreationDateTime = time.Now().UTC().Truncate(time.Second)
+	s.masses[m.MassCancellationID] = &m
+	saved := m
+	return &saved, nil
+}
+
+func (s *MemoryMassCancellationStore) Get(id int) (*MassCancellation, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	m, ok := s.masses[id]
+	if !ok {
+		return nil, xerrors.Errorf("mass cancellation %d: %w", id, errNotFound)
+	}
+	mass := *m
+	return &mass, nil
+}
+
+func (s *MemoryMassCancellationStore) Pending(claimTimeout time.Duration) ([]MassCancellation, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	masses := make([]MassCancellation, 0)
+	for _, m := range s.masses {
+		if s.claimable(m, claimTimeout) {
+			masses = append(masses, *m)
+		}
+	}
+	sort.Slice(masses, func(i, j int) bool {
+		return masses[i].MassCancellationID < masses[j].MassCancellationID
+	})
+	return masses, nil
+}
+
+func (s *MemoryMassCancellationStore) Claim(id int, claimTimeout time.Duration) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	m, ok := s.masses[id]
+	if !ok || !s.claimable(m, claimTimeout) {
+		return false, nil
+	}
+	m.Status = MassCancellationRunning
+	s.claimed[id] = time.Now()
+	return true, nil
+}
+
+func (s *MemoryMassCancellationStore) claimable(m *MassCancellation, claimTimeout time.Duration) bool {
+	switch m.Status {
+	case MassCancellationPending, MassCancellationInterrupted:
+		return true
+	case MassCancellationRunning:
+		return time.Since(s.claimed[m.MassCancellationID]) >= claimTimeout
+	}
+	return false
+}
+
+func (s *MemoryMassCancellationStore) Progress(id int, status string, result []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	m, ok := s.masses[id]
+	if !ok {
+		return xerrors.Errorf("mass cancellation %d: %w", id, errNotFound)
+	}
+	m.Status = status
+	m.Result = result
+	s.claimed[id] = time.Now()
+	return nil
+}
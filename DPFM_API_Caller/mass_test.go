@@ -0,0 +1,187 @@
+package dpfm_api_caller
+
+import (
+	"context"
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func testMassInput(sessionID string, batchSize int) *dpfm_api_input_reader.SDC {
+	seller := testSeller
+	return &dpfm_api_input_reader.SDC{
+		RuntimeSessionID: sessionID,
+		BusinessPartner:  testSeller,
+		APIType:          "mass-cancels",
+		Criteria: dpfm_api_input_reader.Criteria{
+			Seller:    &seller,
+			BatchSize: &batchSize,
+		},
+	}
+}
+
+func (c *testCaller) massStatus(t *testing.T, id int) *dpfm_api_output_formatter.MassCancellation {
+	t.Helper()
+	input := &dpfm_api_input_reader.SDC{
+		BusinessPartner:    testSeller,
+		APIType:            "mass-cancels-status",
+		MassCancellationID: &id,
+	}
+	res, errs := c.AsyncCancels(input.Accepter, input, &dpfm_api_output_formatter.SDC{}, c.log)
+	mustNoErrors(t, errs)
+	return res.(*dpfm_api_output_formatter.MassCancellation)
+}
+
+func (c *testCaller) registerMass(t *testing.T, input *dpfm_api_input_reader.SDC) *dpfm_api_output_formatter.MassCancellation {
+	t.Helper()
+	res, errs := c.AsyncCancels(input.Accepter, input, &dpfm_api_output_formatter.SDC{}, c.log)
+	mustNoErrors(t, errs)
+	mass := res.(*dpfm_api_output_formatter.MassCancellation)
+	if mass.Status != MassCancellationPending || mass.Processed != 0 {
+		t.Fatalf("mass cancellation is processed when registered: %+v", mass)
+	}
+	return mass
+}
+
+func TestMassCancelsRunInBackground(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+
+	mass := c.registerMass(t, testMassInput("mass", 10))
+	if isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Fatal("mass cancellation cancelled the order before it runs")
+	}
+
+	c.executeMassCancellations(context.Background(), c.log)
+	mass = c.massStatus(t, mass.MassCancellationID)
+	if mass.Status != MassCancellationCompleted || mass.Matched != 1 || mass.Succeeded != 1 {
+		t.Errorf("mass cancellation = %+v, want 1 order completed", mass)
+	}
+	if !isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Error("order is not cancelled by mass cancellation")
+	}
+	assertQuantity(t, "stock after mass cancel", c.stock(t, testDate), 110)
+
+	// 再配送された登録は、実行済みの一括キャンセルを返し再実行しない
+	redelivered := testMassInput("mass", 10)
+	res, errs := c.AsyncCancels(redelivered.Accepter, redelivered, &dpfm_api_output_formatter.SDC{}, c.log)
+	mustNoErrors(t, errs)
+	if got := res.(*dpfm_api_output_formatter.MassCancellation); got.MassCancellationID != mass.MassCancellationID || got.Status != MassCancellationCompleted {
+		t.Errorf("redelivered mass cancellation = %+v", got)
+	}
+}
+
+func TestMassCancelsStopBetweenBatchesOnShutdown(t *testing.T) {
+	c := newTestCaller(t)
+	other := testOrder(decimal.NewFromInt(10), decimal.NewFromInt(10))
+	other.Header.OrderID = testOrderID + 1
+	other.Item[0].OrderID = testOrderID + 1
+	other.ItemScheduleLine[0].OrderID = testOrderID + 1
+	c.orders.Seed(other)
+
+	mass := c.registerMass(t, testMassInput("mass", 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	claimed, err := c.massCancellations.Claim(mass.MassCancellationID, time.Hour)
+	if err != nil || !claimed {
+		t.Fatalf("mass cancellation cannot be claimed: %v", err)
+	}
+	stored, err := c.massCancellations.Get(mass.MassCancellationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.executeMassCancellation(ctx, stored, c.log); err != nil {
+		t.Fatal(err)
+	}
+
+	mass = c.massStatus(t, mass.MassCancellationID)
+	if mass.Status != MassCancellationInterrupted || mass.Matched != 2 || mass.Processed != 1 {
+		t.Errorf("mass cancellation = %+v, want interrupted after 1 of 2 orders", mass)
+	}
+}
+
+func TestInterruptedMassCancellationIsResumed(t *testing.T) {
+	c := newTestCaller(t)
+	other := testOrder(decimal.NewFromInt(10), decimal.NewFromInt(10))
+	other.Header.OrderID = testOrderID + 1
+	other.Item[0].OrderID = testOrderID + 1
+	other.ItemScheduleLine[0].OrderID = testOrderID + 1
+	c.orders.Seed(other)
+
+	mass := c.registerMass(t, testMassInput("mass", 1))
+	if _, err := c.massCancellations.Claim(mass.MassCancellationID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := c.massCancellations.Get(mass.MassCancellationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.executeMassCancellation(ctx, stored, c.log); err != nil {
+		t.Fatal(err)
+	}
+
+	// 再配送された登録は、中断した一括キャンセルを返す
+	redelivered := testMassInput("mass", 1)
+	res, errs := c.AsyncCancels(redelivered.Accepter, redelivered, &dpfm_api_output_formatter.SDC{}, c.log)
+	mustNoErrors(t, errs)
+	if got := res.(*dpfm_api_output_formatter.MassCancellation); got.MassCancellationID != mass.MassCancellationID || got.Status != MassCancellationInterrupted {
+		t.Fatalf("redelivered mass cancellation = %+v", got)
+	}
+
+	c.executeMassCancellations(context.Background(), c.log)
+	mass = c.massStatus(t, mass.MassCancellationID)
+	if mass.Status != MassCancellationCompleted || mass.Matched != 1 || mass.Succeeded != 1 {
+		t.Errorf("resumed mass cancellation = %+v, want the remaining order completed", mass)
+	}
+	for _, orderID := range []int{testOrderID, testOrderID + 1} {
+		header := c.orders.HeaderRead(&dpfm_api_input_reader.SDC{BusinessPartner: testSeller, Header: dpfm_api_input_reader.Header{OrderID: orderID}}, c.log)
+		if header == nil || !isTrue(header.IsCancelled) {
+			t.Errorf("order %d is not cancelled after resume", orderID)
+		}
+	}
+}
+
+func TestRunningMassCancellationIsClaimedAgainAfterTimeout(t *testing.T) {
+	s := NewMemoryMassCancellationStore()
+	mass, err := s.Save(&MassCancellation{RuntimeSessionID: "mass", BusinessPartner: testSeller, Input: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := mass.MassCancellationID
+
+	if ok, err := s.Claim(id, time.Hour); err != nil || !ok {
+		t.Fatalf("pending mass cancellation cannot be claimed: %v", err)
+	}
+	if ok, _ := s.Claim(id, time.Hour); ok {
+		t.Error("running mass cancellation is claimed twice before the timeout")
+	}
+	if pending, _ := s.Pending(time.Hour); len(pending) != 0 {
+		t.Errorf("running mass cancellation is pending before the timeout: %+v", pending)
+	}
+
+	if pending, _ := s.Pending(0); len(pending) != 1 {
+		t.Errorf("running mass cancellation is not pending after the timeout: %+v", pending)
+	}
+	if ok, err := s.Claim(id, 0); err != nil || !ok {
+		t.Fatalf("running mass cancellation cannot be claimed after the timeout: %v", err)
+	}
+
+	if err := s.Progress(id, MassCancellationCompleted, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Claim(id, 0); ok {
+		t.Error("completed mass cancellation is claimed again")
+	}
+}
+
+func TestMassCancelsRequireRuntimeSessionID(t *testing.T) {
+	input := testMassInput("", 1)
+	errs := Validate(input.Accepter, input)
+	if len(errs) != 1 || errs[0].Field != "runtime_session_id" || errs[0].Code != ValidationRequired {
+		t.Errorf("validation errors = %+v, want runtime_session_id %s", errs, ValidationRequired)
+	}
+}
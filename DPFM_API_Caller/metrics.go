@@ -0,0 +1,45 @@
+package dpfm_api_caller
+
+import (
+	"data-platform-api-orders-cancels-rmq-kube/metrics"
+
+	"golang.org/x/xerrors"
+)
+
+func recordCancellations(s *saga, accepter []string, outcome string) {
+	if s.dryRun {
+		return
+	}
+	for _, a := range accepter {
+		metrics.Cancellations.WithLabelValues(a, outcome).Inc()
+	}
+}
+
+// recordStockQuantities は、確定した在庫の更新について、在庫の増加を引当解除、減少を引当として記録します
+// 補償処理・ロールバックで取り消した更新は記録しないよう、コミット後にのみ呼び出す
+func recordStockQuantities(s *saga) {
+	for _, step := range s.steps {
+		if step.itemScheduleLine == nil {
+			continue
+		}
+		if step.delta.Sign() > 0 {
+			metrics.StockQuantity.WithLabelValues(metrics.StockReleased).Add(step.delta.InexactFloat64())
+		} else if step.delta.Sign() < 0 {
+			metrics.StockQuantity.WithLabelValues(metrics.StockReserved).Add(step.delta.Neg().InexactFloat64())
+		}
+	}
+}
+
+func outcomeOf(err error) string {
+	switch {
+	case err == nil:
+		return metrics.OutcomeSuccess
+	case xerrors.Is(err, errRMQ):
+		return metrics.OutcomeRMQFailure
+	case xerrors.Is(err, errSQL):
+		return metrics.OutcomeSQLFailure
+	case xerrors.Is(err, errNotFound), xerrors.Is(err, errNotExist):
+		return metrics.OutcomeNotFound
+	}
+	return metrics.OutcomeRejected
+}
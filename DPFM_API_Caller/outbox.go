@@ -0,0 +1,165 @@
+package dpfm_api_caller
+
+import (
+	"context"
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"time"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"golang.org/x/xerrors"
+)
+
+const (
+	EventOrderCancelled                          = "OrderCancelled"
+	EventOrderReactivated                        = "OrderReactivated"
+	EventOrderItemCancelled                      = "OrderItemCancelled"
+	EventOrderItemReactivated                    = "OrderItemReactivated"
+	EventOrderItemScheduleLineCancelled          = "OrderItemScheduleLineCancelled"
+	EventOrderItemScheduleLinePartiallyCancelled = "OrderItemScheduleLinePartiallyCancelled"
+	EventOrderItemScheduleLineReactivated        = "OrderItemScheduleLineReactivated"
+	EventProductStockReleased                    = "ProductStockReleased"
+	EventProductStockReserved                    = "ProductStockReserved"
+
+	outboxPublishBatchSize = 100
+)
+
+// recordDomainEvents は、キャンセルで適用した更新からドメインイベントを作成し、outbox に記録します
+// 記録に失敗した場合は、下流のサービスと不整合にならないように適用した更新を取り消す
+// トランザクションで更新しない場合（SQL_WRITE_MODE=rmq）は、記録と更新が同じトランザクションにならないため、取り消しにも失敗すると更新のみが残る
+func (c *DPFMAPICaller) recordDomainEvents(
+	s *saga,
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) error {
+	if len(c.conf.RMQ.QueueToEvents()) == 0 {
+		return nil
+	}
+	events := domainEvents(s, input, time.Now())
+	if len(events) == 0 {
+		return nil
+	}
+	appendEvents := c.outbox.Append
+	if s.tx != nil {
+		// トランザクションで更新する場合は、イベントも同じトランザクションで記録する
+		appendEvents = s.tx.AppendEvents
+	}
+	if err := appendEvents(events); err != nil {
+		if errs := c.compensate(s, log); len(errs) != 0 {
+			return xerrors.Errorf("domain events cannot be recorded: %v, compensation failed: %v", err, errs[0])
+		}
+		return xerrors.Errorf("cancel rolled back because domain events cannot be recorded: %w", err)
+	}
+
+	select {
+	case c.outboxNotify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func domainEvents(s *saga, input *dpfm_api_input_reader.SDC, now time.Time) []dpfm_api_output_formatter.DomainEvent {
+	events := make([]dpfm_api_output_formatter.DomainEvent, 0, len(s.steps))
+	for _, step := range s.steps {
+		event := dpfm_api_output_formatter.DomainEvent{
+			RuntimeSessionID: input.RuntimeSessionID,
+			BusinessPartner:  input.BusinessPartner,
+			Data:             step.message,
+			OccurredDateTime: now.Format(time.RFC3339),
+		}
+		switch data := step.message.(type) {
+		case *dpfm_api_output_formatter.Header:
+			event.EventType = cancelledOrReactivated(data.IsCancelled, EventOrderCancelled, EventOrderReactivated)
+			event.OrderID = data.OrderID
+		case dpfm_api_output_formatter.Item:
+			event.EventType = cancelledOrReactivated(data.IsCancelled, EventOrderItemCancelled, EventOrderItemReactivated)
+			event.OrderID = data.OrderID
+			event.OrderItem = &data.OrderItem
+		case dpfm_api_output_formatter.ItemScheduleLine:
+			// 一部キャンセルで残りの数量が 0 になりキャンセル済みになった場合も、一部キャンセルとして通知する
+			switch step.action {
+			case actionPartialCancel:
+				event.EventType = EventOrderItemScheduleLinePartiallyCancelled
+				event.Quantity = data.CancelledQuantityInBaseUnit
+			case actionCancel:
+				event.EventType = EventOrderItemScheduleLineCancelled
+			default:
+				event.EventType = EventOrderItemScheduleLineReactivated
+			}
+			event.OrderID = data.OrderID
+			event.OrderItem = &data.OrderItem
+			event.ScheduleLine = &data.ScheduleLine
+		case dpfm_api_output_formatter.ProductStock:
+			if step.itemScheduleLine == nil || step.delta.IsZero() {
+				continue
+			}
+			event.EventType = EventProductStockReleased
+			quantity := step.delta
+			if quantity.Sign() < 0 {
+				event.EventType = EventProductStockReserved
+				quantity = quantity.Neg()
+			}
+			event.OrderID = step.itemScheduleLine.OrderID
+			event.OrderItem = &step.itemScheduleLine.OrderItem
+			event.ScheduleLine = &step.itemScheduleLine.ScheduleLine
+			event.Quantity = &dpfm_api_output_formatter.Quantity{Decimal: quantity}
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func cancelledOrReactivated(isCancelled *bool, cancelled, reactivated string) string {
+	if isCancelled != nil && *isCancelled {
+		return cancelled
+	}
+	return reactivated
+}
+
+// RunOutboxPublisher は、停止の通知を受けるまで未送信のドメインイベントを記録した順に送信します
+// 送信後に送信済みにできなかったイベントは再送されるため、受信側は EventID で重複を除外してください
+func (c *DPFMAPICaller) RunOutboxPublisher(ctx context.Context, log *logger.Logger) {
+	ticker := time.NewTicker(c.conf.Cancellation.OutboxPublishInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.outboxNotify:
+		}
+		c.publishDomainEvents(ctx, log)
+	}
+}
+
+func (c *DPFMAPICaller) publishDomainEvents(ctx context.Context, log *logger.Logger) {
+	queues := c.conf.RMQ.QueueToEvents()
+	if len(queues) == 0 {
+		return
+	}
+	for ctx.Err() == nil {
+		events, err := c.outbox.Pending(outboxPublishBatchSize)
+		if err != nil {
+			log.Error("%+v", err)
+			return
+		}
+		for _, event := range events {
+			for _, queue := range queues {
+				// 送信に失敗した場合は、イベントの順序を保つために以降のイベントも次回に送信する
+				if err := c.rmq.Send(queue, event); err != nil {
+					log.Error("event %d publish error: %+v", event.EventID, err)
+					return
+				}
+			}
+			if err := c.outbox.MarkPublished(event.EventID); err != nil {
+				log.Error("%+v", err)
+				return
+			}
+		}
+		if len(events) < outboxPublishBatchSize {
+			return
+		}
+	}
+}
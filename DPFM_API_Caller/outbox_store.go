@@ -0,0 +1,166 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"encoding/json"
+	"sync"
+
+	database "github.com/latonaio/golang-mysql-network-connector"
+	"golang.org/x/xerrors"
+)
+
+// OutboxStore は、送信前のドメインイベントを保持します
+// キャンセルの更新がすべて成功した場合のみ記録し、送信に成功するまで保持し続ける
+type OutboxStore interface {
+	// Append は、1 回のキャンセルで発生したイベントをすべて記録するか、まったく記録しないかのいずれかです
+	Append(events []dpfm_api_output_formatter.DomainEvent) error
+	// Pending は、未送信のイベントを記録した順に返します
+	Pending(limit int) ([]dpfm_api_output_formatter.DomainEvent, error)
+	MarkPublished(eventID int) error
+}
+
+type MySQLOutboxStore struct {
+	db *database.Mysql
+}
+
+func NewMySQLOutboxStore(db *database.Mysql) (*MySQLOutboxStore, error) {
+	_, err := db.Exec(
+		`CREATE TABLE IF NOT EXISTS DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_outbox_data (
+			EventID int(16) NOT NULL AUTO_INCREMENT,
+			EventType varchar(100) NOT NULL,
+			RuntimeSessionID varchar(100) NOT NULL,
+			OrderID int(16) NOT NULL,
+			Payload json NOT NULL,
+			CreationDateTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PublishedDateTime datetime DEFAULT NULL,
+			PRIMARY KEY (EventID),
+			KEY (PublishedDateTime, EventID)
+		);`,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("outbox table create error: %w", err)
+	}
+	return &MySQLOutboxStore{db: db}, nil
+}
+
+func (s *MySQLOutboxStore) Append(events []dpfm_api_output_formatter.DomainEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return xerrors.Errorf("outbox transaction begin error: %w", err)
+	}
+	if err := insertOutboxEvents(tx, events); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("outbox transaction commit error: %w", err)
+	}
+	return nil
+}
+
+func insertOutboxEvents(db execer, events []dpfm_api_output_formatter.DomainEvent) error {
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return xerrors.Errorf("outbox event marshal error: %w", err)
+		}
+		_, err = db.Exec(
+			`INSERT INTO DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_outbox_data
+			(EventType, RuntimeSessionID, OrderID, Payload) VALUES (?, ?, ?, ?);`, event.EventType, event.RuntimeSessionID, event.OrderID, payload,
+		)
+		if err != nil {
+			return xerrors.Errorf("outbox write error: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *MySQLOutboxStore) Pending(limit int) ([]dpfm_api_output_formatter.DomainEvent, error) {
+	rows, err := s.db.Query(
+		`SELECT EventID, Payload
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_outbox_data
+		WHERE PublishedDateTime IS NULL
+		ORDER BY EventID LIMIT ?;`, limit,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("outbox read error: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]dpfm_api_output_formatter.DomainEvent, 0)
+	for rows.Next() {
+		var (
+			eventID int
+			payload []byte
+		)
+		if err := rows.Scan(&eventID, &payload); err != nil {
+			return nil, xerrors.Errorf("outbox scan error: %w", err)
+		}
+		event := dpfm_api_output_formatter.DomainEvent{}
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, xerrors.Errorf("outbox event unmarshal error: %w", err)
+		}
+		event.EventID = eventID
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("outbox scan error: %w", err)
+	}
+	return events, nil
+}
+
+func (s *MySQLOutboxStore) MarkPublished(eventID int) error {
+	_, err := s.db.Exec(
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_outbox_data
+		SET PublishedDateTime = CURRENT_TIMESTAMP WHERE EventID = ?;`, eventID,
+	)
+	if err != nil {
+		return xerrors.Errorf("outbox write error: %w", err)
+	}
+	return nil
+}
+
+type MemoryOutboxStore struct {
+	mtx       sync.Mutex
+	events    []dpfm_api_output_formatter.DomainEvent
+	published map[int]bool
+}
+
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{
+		events:    make([]dpfm_api_output_formatter.DomainEvent, 0),
+		published: make(map[int]bool),
+	}
+}
+
+func (s *MemoryOutboxStore) Append(events []dpfm_api_output_formatter.DomainEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, event := range events {
+		event.EventID = len(s.events) + 1
+		s.events = append(s.events, event)
+	}
+	return nil
+}
+
+func (s *MemoryOutboxStore) Pending(limit int) ([]dpfm_api_output_formatter.DomainEvent, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	events := make([]dpfm_api_output_formatter.DomainEvent, 0)
+	for _, event := range s.events {
+		if len(events) == limit {
+			break
+		}
+		if !s.published[event.EventID] {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *MemoryOutboxStore) MarkPublished(eventID int) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.published[eventID] = true
+	return nil
+}
@@ -0,0 +1,53 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestScheduleLineEventsFollowTheAction(t *testing.T) {
+	cancelledQuantity := dpfm_api_output_formatter.NewQuantity(decimal.NewFromInt(10))
+	tests := []struct {
+		name   string
+		action action
+		data   dpfm_api_output_formatter.ItemScheduleLine
+		want   string
+	}{
+		{
+			// 残りの数量をすべて一部キャンセルし、キャンセル済みになった場合
+			name:   "partial cancel of the remaining quantity",
+			action: actionPartialCancel,
+			data:   dpfm_api_output_formatter.ItemScheduleLine{IsCancelled: getBoolPtr(true), CancelledQuantityInBaseUnit: &cancelledQuantity},
+			want:   EventOrderItemScheduleLinePartiallyCancelled,
+		},
+		{
+			name:   "cancel",
+			action: actionCancel,
+			data:   dpfm_api_output_formatter.ItemScheduleLine{IsCancelled: getBoolPtr(true)},
+			want:   EventOrderItemScheduleLineCancelled,
+		},
+		{
+			// 一部キャンセル済みのスケジュール行のキャンセル取消
+			name:   "reactivate",
+			action: actionReactivate,
+			data:   dpfm_api_output_formatter.ItemScheduleLine{IsCancelled: getBoolPtr(false), CancelledQuantityInBaseUnit: &cancelledQuantity},
+			want:   EventOrderItemScheduleLineReactivated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &saga{steps: []sagaStep{{function: functionItemScheduleLine, message: tt.data, action: tt.action}}}
+			events := domainEvents(s, &dpfm_api_input_reader.SDC{}, time.Now())
+			if len(events) != 1 || events[0].EventType != tt.want {
+				t.Fatalf("events = %+v, want %s", events, tt.want)
+			}
+			if tt.want == EventOrderItemScheduleLinePartiallyCancelled && (events[0].Quantity == nil || !events[0].Quantity.Equal(cancelledQuantity.Decimal)) {
+				t.Errorf("quantity = %v, want %s", events[0].Quantity, cancelledQuantity)
+			}
+		})
+	}
+}
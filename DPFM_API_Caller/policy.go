@@ -0,0 +1,184 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"data-platform-api-orders-cancels-rmq-kube/config"
+	"fmt"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"golang.org/x/xerrors"
+)
+
+// CancellationPolicy は、オーダーのキャンセル可否を判定するルールです
+// キャンセルを許可しない場合は、その理由と false を返します
+type CancellationPolicy interface {
+	Name() string
+	Evaluate(rule config.CancellationPolicyRule, target CancellationPolicyTarget) (string, bool)
+}
+
+// CancellationPolicyTarget は、キャンセル可否の判定対象となるヘッダまたは明細の状態です
+type CancellationPolicyTarget struct {
+	OrderID             int
+	OrderItem           *int
+	DeliveryStatus      *string
+	BillingStatus       *string
+	IsMarkedForDeletion *bool
+}
+
+func defaultCancellationPolicies() []CancellationPolicy {
+	return []CancellationPolicy{
+		&deliveryStatusPolicy{},
+		&billingStatusPolicy{},
+		&markedForDeletionPolicy{},
+	}
+}
+
+func (c *DPFMAPICaller) RegisterCancellationPolicy(policy CancellationPolicy) {
+	c.policies = append(c.policies, policy)
+}
+
+type deliveryStatusPolicy struct{}
+
+func (p *deliveryStatusPolicy) Name() string {
+	return "DeliveryStatus"
+}
+
+func (p *deliveryStatusPolicy) Evaluate(rule config.CancellationPolicyRule, target CancellationPolicyTarget) (string, bool) {
+	if target.DeliveryStatus == nil || contains(rule.AllowedDeliveryStatuses, *target.DeliveryStatus) {
+		return "", true
+	}
+	return fmt.Sprintf("delivery status %s does not allow cancellation", *target.DeliveryStatus), false
+}
+
+type billingStatusPolicy struct{}
+
+func (p *billingStatusPolicy) Name() string {
+	return "BillingStatus"
+}
+
+func (p *billingStatusPolicy) Evaluate(rule config.CancellationPolicyRule, target CancellationPolicyTarget) (string, bool) {
+	if target.BillingStatus == nil || contains(rule.AllowedBillingStatuses, *target.BillingStatus) {
+		return "", true
+	}
+	return fmt.Sprintf("billing status %s does not allow cancellation", *target.BillingStatus), false
+}
+
+type markedForDeletionPolicy struct{}
+
+func (p *markedForDeletionPolicy) Name() string {
+	return "IsMarkedForDeletion"
+}
+
+func (p *markedForDeletionPolicy) Evaluate(rule config.CancellationPolicyRule, target CancellationPolicyTarget) (string, bool) {
+	if rule.AllowMarkedForDeletion || target.IsMarkedForDeletion == nil || !*target.IsMarkedForDeletion {
+		return "", true
+	}
+	return "data marked for deletion cannot be cancelled", false
+}
+
+// evaluateCancellationPolicies は、キャンセル対象となるヘッダ・明細に、オーダーの売り手のキャンセルポリシーを適用します
+// キャンセル取消の場合は判定しません
+func (c *DPFMAPICaller) evaluateCancellationPolicies(
+	s *saga,
+	input *dpfm_api_input_reader.SDC,
+	accepter []string,
+	log *logger.Logger,
+) ([]dpfm_api_output_formatter.CancellationPolicyViolation, error) {
+	targets := make([]CancellationPolicyTarget, 0)
+	targetItems := make(map[int]struct{})
+	for _, a := range accepter {
+		switch a {
+		case "Header":
+			if input.Header.IsCancelled == nil || !*input.Header.IsCancelled {
+				continue
+			}
+			header := s.orders.HeaderRead(input, log)
+			if header == nil {
+				continue
+			}
+			targets = append(targets, CancellationPolicyTarget{
+				OrderID:             header.OrderID,
+				DeliveryStatus:      header.HeaderDeliveryStatus,
+				BillingStatus:       header.HeaderBillingStatus,
+				IsMarkedForDeletion: header.IsMarkedForDeletion,
+			})
+			items := s.orders.ItemsRead(input, log)
+			if items == nil {
+				return nil, xerrors.Errorf("order item read error: %w", errSQL)
+			}
+			for _, item := range *items {
+				targetItems[item.OrderItem] = struct{}{}
+			}
+		case "Item":
+			for _, item := range input.Header.Item {
+				if item.IsCancelled != nil && *item.IsCancelled {
+					targetItems[item.OrderItem] = struct{}{}
+				}
+			}
+		case "ItemScheduleLine":
+			for _, item := range input.Header.Item {
+				for _, itemScheduleLine := range item.ItemScheduleLine {
+					if (itemScheduleLine.IsCancelled != nil && *itemScheduleLine.IsCancelled) || itemScheduleLine.CancelledQuantityInBaseUnit != nil {
+						targetItems[item.OrderItem] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+
+	if len(targetItems) != 0 {
+		items := s.orders.ItemsRead(input, log)
+		if items == nil {
+			return nil, xerrors.Errorf("order item read error: %w", errSQL)
+		}
+		for _, item := range *items {
+			if _, ok := targetItems[item.OrderItem]; !ok {
+				continue
+			}
+			orderItem := item.OrderItem
+			targets = append(targets, CancellationPolicyTarget{
+				OrderID:             item.OrderID,
+				OrderItem:           &orderItem,
+				DeliveryStatus:      item.ItemDeliveryStatus,
+				BillingStatus:       item.ItemBillingStatus,
+				IsMarkedForDeletion: item.IsMarkedForDeletion,
+			})
+		}
+	}
+
+	violations := make([]dpfm_api_output_formatter.CancellationPolicyViolation, 0)
+	if len(targets) == 0 {
+		return violations, nil
+	}
+	// キャンセルポリシーは、呼び出し元ではなくオーダーの売り手に設定されたものを適用する
+	headerPartner := s.orders.HeaderPartnerRead(input, log)
+	if headerPartner == nil {
+		return nil, xerrors.Errorf("order %d: %w", input.Header.OrderID, errNotFound)
+	}
+	rule := c.conf.Cancellation.PolicyRule(headerPartner.Seller)
+	for _, target := range targets {
+		for _, policy := range c.policies {
+			reason, ok := policy.Evaluate(rule, target)
+			if ok {
+				continue
+			}
+			violations = append(violations, dpfm_api_output_formatter.CancellationPolicyViolation{
+				OrderID:   target.OrderID,
+				OrderItem: target.OrderItem,
+				Rule:      policy.Name(),
+				Reason:    reason,
+			})
+		}
+	}
+	return violations, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package dpfm_api_caller
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCancellationPoliciesUseSellerRule(t *testing.T) {
+	seller := strconv.Itoa(testSeller)
+	buyer := strconv.Itoa(testBuyer)
+	tests := []struct {
+		name           string
+		rules          string
+		caller         int
+		deliveryStatus string
+		billingStatus  string
+		marked         bool
+		wantRules      []string
+	}{
+		{
+			name:           "default rule allows not processed order",
+			caller:         testSeller,
+			deliveryStatus: "NP",
+			billingStatus:  "NP",
+		},
+		{
+			name:           "default rule blocks delivered order",
+			caller:         testSeller,
+			deliveryStatus: "CL",
+			billingStatus:  "NP",
+			wantRules:      []string{"DeliveryStatus", "DeliveryStatus"},
+		},
+		{
+			name:           "default rule blocks billed and deleted order",
+			caller:         testSeller,
+			deliveryStatus: "NP",
+			billingStatus:  "CL",
+			marked:         true,
+			wantRules:      []string{"BillingStatus", "IsMarkedForDeletion", "BillingStatus", "IsMarkedForDeletion"},
+		},
+		{
+			name:           "seller rule allows delivered order",
+			rules:          `{"` + seller + `": {"AllowedDeliveryStatuses": ["NP", "CL"], "AllowedBillingStatuses": ["NP"]}}`,
+			caller:         testSeller,
+			deliveryStatus: "CL",
+			billingStatus:  "NP",
+		},
+		{
+			name:           "seller rule applies to buyer request",
+			rules:          `{"` + seller + `": {"AllowedDeliveryStatuses": ["NP", "CL"], "AllowedBillingStatuses": ["NP"]}}`,
+			caller:         testBuyer,
+			deliveryStatus: "CL",
+			billingStatus:  "NP",
+		},
+		{
+			name:           "buyer rule does not relax seller policy",
+			rules:          `{"` + buyer + `": {"AllowedDeliveryStatuses": ["NP", "CL"], "AllowedBillingStatuses": ["NP"]}}`,
+			caller:         testBuyer,
+			deliveryStatus: "CL",
+			billingStatus:  "NP",
+			wantRules:      []string{"DeliveryStatus", "DeliveryStatus"},
+		},
+		{
+			name:           "seller rule allows marked for deletion",
+			rules:          `{"` + seller + `": {"AllowedDeliveryStatuses": ["NP"], "AllowedBillingStatuses": ["NP"], "AllowMarkedForDeletion": true}}`,
+			caller:         testSeller,
+			deliveryStatus: "NP",
+			billingStatus:  "NP",
+			marked:         true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CANCELLATION_POLICY_RULES", tt.rules)
+			c := newTestCaller(t)
+			order := testOrder(decimal.NewFromInt(10), decimal.NewFromInt(10))
+			order.Header.HeaderDeliveryStatus = getStringPtr(tt.deliveryStatus)
+			order.Header.HeaderBillingStatus = getStringPtr(tt.billingStatus)
+			order.Header.IsMarkedForDeletion = getBoolPtr(tt.marked)
+			order.Item[0].ItemDeliveryStatus = getStringPtr(tt.deliveryStatus)
+			order.Item[0].ItemBillingStatus = getStringPtr(tt.billingStatus)
+			order.Item[0].IsMarkedForDeletion = getBoolPtr(tt.marked)
+			c.orders.Seed(order)
+
+			input := testInput("cancels", "policy", true)
+			input.BusinessPartner = tt.caller
+			violations, err := c.evaluateCancellationPolicies(c.newDryRunSaga(input.RuntimeSessionID), input, []string{"Header"}, c.log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(violations))
+			for _, v := range violations {
+				got = append(got, v.Rule)
+			}
+			if len(got) != len(tt.wantRules) {
+				t.Fatalf("violations = %v, want %v", got, tt.wantRules)
+			}
+			for i := range got {
+				if got[i] != tt.wantRules[i] {
+					t.Errorf("violations = %v, want %v", got, tt.wantRules)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestCancelsRejectedByPolicyKeepOrder(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+	order := testOrder(decimal.NewFromInt(10), decimal.NewFromInt(10))
+	order.Item[0].ItemDeliveryStatus = getStringPtr("CL")
+	c.orders.Seed(order)
+
+	res, errs := c.call(t, testInput("cancels", "cancel", true))
+	if len(errs) == 0 {
+		t.Fatal("cancel of delivered item is not rejected")
+	}
+	if res == nil || res.CancellationPolicyViolation == nil || len(*res.CancellationPolicyViolation) != 1 {
+		t.Fatalf("policy violations are not returned: %+v", res)
+	}
+	if isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Error("schedule line is cancelled despite the policy")
+	}
+	assertQuantity(t, "stock after rejected cancel", c.stock(t, testDate), 100)
+}
@@ -0,0 +1,50 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"golang.org/x/xerrors"
+)
+
+// quantityScale は、スケジュール行の基本数量単位で扱う数量の小数桁数です
+// QUANTITY_UNIT_SCALES に設定されていない基本数量単位は、数量を丸められないためエラーとする
+func (c *DPFMAPICaller) quantityScale(
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+) (int32, error) {
+	baseUnit := ""
+	if itemScheduleLine.BaseUnit != nil {
+		baseUnit = *itemScheduleLine.BaseUnit
+	}
+	scale, ok := c.conf.Cancellation.QuantityScale(baseUnit)
+	if !ok {
+		return 0, xerrors.Errorf("order item schedule line %d-%d-%d: quantity scale of base unit %q is not configured", itemScheduleLine.OrderID, itemScheduleLine.OrderItem, itemScheduleLine.ScheduleLine, baseUnit)
+	}
+	return scale, nil
+}
+
+// itemScheduleLineRead は、スケジュール行を読み込み、数量を基本数量単位の小数桁数に揃えます
+// 浮動小数点数で保存された数量の誤差を、在庫の計算に持ち込まないようにする
+func (c *DPFMAPICaller) itemScheduleLineRead(
+	s *saga,
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) (*[]dpfm_api_output_formatter.ItemScheduleLine, error) {
+	itemScheduleLines := s.orders.ItemScheduleLineRead(input, log)
+	if itemScheduleLines == nil {
+		return nil, xerrors.Errorf("order item schedule line read error: %w", errSQL)
+	}
+	for i := range *itemScheduleLines {
+		itemScheduleLine := &(*itemScheduleLines)[i]
+		scale, err := c.quantityScale(*itemScheduleLine)
+		if err != nil {
+			return nil, err
+		}
+		if itemScheduleLine.ScheduleLineOrderQuantityInBaseUnit != nil {
+			itemScheduleLine.ScheduleLineOrderQuantityInBaseUnit = &dpfm_api_output_formatter.Quantity{Decimal: itemScheduleLine.ScheduleLineOrderQuantityInBaseUnit.Round(scale)}
+		}
+		itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit = dpfm_api_output_formatter.NewQuantity(itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Round(scale))
+	}
+	return itemScheduleLines, nil
+}
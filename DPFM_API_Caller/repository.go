@@ -0,0 +1,316 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"golang.org/x/xerrors"
+)
+
+// OrdersRepository は、キャンセル処理で参照するオーダーの読み込みです
+// 読み込みに失敗した場合は nil を返します。ヘッダが存在しない場合は nil、明細・スケジュール行が存在しない場合は空のスライスを返します
+type OrdersRepository interface {
+	HeaderRead(input *dpfm_api_input_reader.SDC, log *logger.Logger) *dpfm_api_output_formatter.Header
+	HeaderPartnerRead(input *dpfm_api_input_reader.SDC, log *logger.Logger) *dpfm_api_output_formatter.HeaderPartner
+	OrdersByCriteriaRead(input *dpfm_api_input_reader.SDC, log *logger.Logger) *[]int
+	ItemsRead(input *dpfm_api_input_reader.SDC, log *logger.Logger) *[]dpfm_api_output_formatter.Item
+	ItemScheduleLineRead(input *dpfm_api_input_reader.SDC, log *logger.Logger) *[]dpfm_api_output_formatter.ItemScheduleLine
+}
+
+// StockRepository は、スケジュール行の在庫確認先の在庫の読み込みです
+// 読み込みに失敗した場合は nil を返し、在庫が存在しない場合はゼロ値の在庫を返します
+type StockRepository interface {
+	ProductStockAvailabilityRead(itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine, log *logger.Logger) *dpfm_api_output_formatter.ProductStock
+	ProductStockAvailabilityByBatchRead(itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine, log *logger.Logger) *dpfm_api_output_formatter.ProductStock
+	// ProductStockCandidatesRead は、在庫確認先と同じ品目・ビジネスパートナ・プラントの RequestedDeliveryDate 以降の在庫を、在庫利用可能日付・ロットの順に返します
+	// availabilityDateTo が nil の場合は日付の上限を設けず、otherBatches の場合はスケジュール行と異なるロットの在庫も含めます
+	ProductStockCandidatesRead(itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine, availabilityDateTo *string, otherBatches bool, log *logger.Logger) *[]dpfm_api_output_formatter.ProductStock
+}
+
+// MemoryOrder は、MemoryOrdersRepository に登録するオーダーです
+type MemoryOrder struct {
+	Header           dpfm_api_output_formatter.Header             `json:"Header"`
+	Buyer            int                                          `json:"Buyer"`
+	Seller           int                                          `json:"Seller"`
+	Item             []dpfm_api_output_formatter.Item             `json:"Item"`
+	ItemScheduleLine []dpfm_api_output_formatter.ItemScheduleLine `json:"ItemScheduleLine"`
+}
+
+// MemorySeed は、ローカルでの実行時に MemoryOrdersRepository・MemoryStockRepository に登録するデータです
+type MemorySeed struct {
+	Orders       []MemoryOrder                            `json:"Orders"`
+	ProductStock []dpfm_api_output_formatter.ProductStock `json:"ProductStock"`
+}
+
+// LoadMemorySeed は、JSON ファイルのオーダーと在庫を登録します
+func LoadMemorySeed(path string, orders *MemoryOrdersRepository, stocks *MemoryStockRepository) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return xerrors.Errorf("memory seed read error: %w", err)
+	}
+	seed := MemorySeed{}
+	if err := json.Unmarshal(raw, &seed); err != nil {
+		return xerrors.Errorf("memory seed unmarshal error: %w", err)
+	}
+	orders.Seed(seed.Orders...)
+	stocks.Seed(seed.ProductStock...)
+	return nil
+}
+
+// MemoryOrdersRepository は、MySQL を使わずにキャンセル処理を実行するためのオーダーの保持先です
+type MemoryOrdersRepository struct {
+	mtx    sync.RWMutex
+	orders map[int]*MemoryOrder
+}
+
+func NewMemoryOrdersRepository() *MemoryOrdersRepository {
+	return &MemoryOrdersRepository{
+		orders: make(map[int]*MemoryOrder),
+	}
+}
+
+// Seed は、オーダーを登録します。同じ OrderID のオーダーは置き換えます
+func (r *MemoryOrdersRepository) Seed(orders ...MemoryOrder) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, order := range orders {
+		o := order
+		o.Item = append([]dpfm_api_output_formatter.Item{}, order.Item...)
+		o.ItemScheduleLine = append([]dpfm_api_output_formatter.ItemScheduleLine{}, order.ItemScheduleLine...)
+		r.orders[order.Header.OrderID] = &o
+	}
+}
+
+// order は、取引先が買い手または売り手であるオーダーを返します
+func (r *MemoryOrdersRepository) order(input *dpfm_api_input_reader.SDC) *MemoryOrder {
+	order, ok := r.orders[input.Header.OrderID]
+	if !ok || (order.Buyer != input.BusinessPartner && order.Seller != input.BusinessPartner) {
+		return nil
+	}
+	return order
+}
+
+func (r *MemoryOrdersRepository) HeaderRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.Header {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	order := r.order(input)
+	if order == nil {
+		return nil
+	}
+	if input.Header.HeaderDeliveryStatus != nil && (order.Header.HeaderDeliveryStatus == nil || *order.Header.HeaderDeliveryStatus != *input.Header.HeaderDeliveryStatus) {
+		return nil
+	}
+	header := order.Header
+	return &header
+}
+
+func (r *MemoryOrdersRepository) HeaderPartnerRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.HeaderPartner {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	order, ok := r.orders[input.Header.OrderID]
+	if !ok {
+		return nil
+	}
+	return &dpfm_api_output_formatter.HeaderPartner{
+		OrderID: order.Header.OrderID,
+		Buyer:   order.Buyer,
+		Seller:  order.Seller,
+	}
+}
+
+func (r *MemoryOrdersRepository) OrdersByCriteriaRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *[]int {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	criteria := input.Criteria
+	orderIDs := make([]int, 0)
+	for _, order := range r.orders {
+		if order.Buyer != input.BusinessPartner && order.Seller != input.BusinessPartner {
+			continue
+		}
+		if isTrue(order.Header.IsCancelled) || isTrue(order.Header.IsMarkedForDeletion) {
+			continue
+		}
+		if (criteria.Buyer != nil && order.Buyer != *criteria.Buyer) || (criteria.Seller != nil && order.Seller != *criteria.Seller) {
+			continue
+		}
+		if criteria.RequestedDeliveryDateTo == nil && criteria.Product == nil && criteria.Plant == nil {
+			orderIDs = append(orderIDs, order.Header.OrderID)
+			continue
+		}
+		for _, itemScheduleLine := range order.ItemScheduleLine {
+			if criteria.RequestedDeliveryDateTo != nil && (itemScheduleLine.RequestedDeliveryDate == nil || *itemScheduleLine.RequestedDeliveryDate >= *criteria.RequestedDeliveryDateTo) {
+				continue
+			}
+			if criteria.Product != nil && itemScheduleLine.Product != *criteria.Product {
+				continue
+			}
+			if criteria.Plant != nil && itemScheduleLine.StockConfirmationPlant != *criteria.Plant {
+				continue
+			}
+			orderIDs = append(orderIDs, order.Header.OrderID)
+			break
+		}
+	}
+	sort.Ints(orderIDs)
+	return &orderIDs
+}
+
+func (r *MemoryOrdersRepository) ItemsRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *[]dpfm_api_output_formatter.Item {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	items := make([]dpfm_api_output_formatter.Item, 0)
+	if order := r.order(input); order != nil {
+		items = append(items, order.Item...)
+	}
+	return &items
+}
+
+func (r *MemoryOrdersRepository) ItemScheduleLineRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *[]dpfm_api_output_formatter.ItemScheduleLine {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	itemScheduleLines := make([]dpfm_api_output_formatter.ItemScheduleLine, 0)
+	if order := r.order(input); order != nil {
+		itemScheduleLines = append(itemScheduleLines, order.ItemScheduleLine...)
+	}
+	return &itemScheduleLines
+}
+
+// MemoryStockRepository は、MySQL を使わずにキャンセル処理を実行するための在庫の保持先です
+type MemoryStockRepository struct {
+	mtx    sync.RWMutex
+	stocks map[productStockKey]dpfm_api_output_formatter.ProductStock
+}
+
+type productStockKey struct {
+	Product                      string
+	BusinessPartner              int
+	Plant                        string
+	Batch                        string
+	ProductStockAvailabilityDate string
+}
+
+func NewMemoryStockRepository() *MemoryStockRepository {
+	return &MemoryStockRepository{
+		stocks: make(map[productStockKey]dpfm_api_output_formatter.ProductStock),
+	}
+}
+
+// Seed は、在庫を登録します。Batch が空の在庫はロット指定なしの在庫として扱います
+func (r *MemoryStockRepository) Seed(stocks ...dpfm_api_output_formatter.ProductStock) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, stock := range stocks {
+		r.stocks[keyOfProductStock(stock)] = stock
+	}
+}
+
+func (r *MemoryStockRepository) ProductStockAvailabilityRead(
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.ProductStock {
+	return r.read(itemScheduleLine, "")
+}
+
+func (r *MemoryStockRepository) ProductStockAvailabilityByBatchRead(
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.ProductStock {
+	if itemScheduleLine.StockConfirmationPlantBatch == nil {
+		return &dpfm_api_output_formatter.ProductStock{}
+	}
+	return r.read(itemScheduleLine, *itemScheduleLine.StockConfirmationPlantBatch)
+}
+
+func (r *MemoryStockRepository) read(
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	batch string,
+) *dpfm_api_output_formatter.ProductStock {
+	if itemScheduleLine.RequestedDeliveryDate == nil {
+		return &dpfm_api_output_formatter.ProductStock{}
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	stock, ok := r.stocks[productStockKey{
+		Product:                      itemScheduleLine.Product,
+		BusinessPartner:              itemScheduleLine.StockConfirmationBusinessPartner,
+		Plant:                        itemScheduleLine.StockConfirmationPlant,
+		Batch:                        batch,
+		ProductStockAvailabilityDate: *itemScheduleLine.RequestedDeliveryDate,
+	}]
+	if !ok {
+		return &dpfm_api_output_formatter.ProductStock{}
+	}
+	return &stock
+}
+
+func (r *MemoryStockRepository) ProductStockCandidatesRead(
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	availabilityDateTo *string,
+	otherBatches bool,
+	log *logger.Logger,
+) *[]dpfm_api_output_formatter.ProductStock {
+	stocks := make([]dpfm_api_output_formatter.ProductStock, 0)
+	if itemScheduleLine.RequestedDeliveryDate == nil {
+		return &stocks
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	for key, stock := range r.stocks {
+		if key.Product != itemScheduleLine.Product ||
+			key.BusinessPartner != itemScheduleLine.StockConfirmationBusinessPartner ||
+			key.Plant != itemScheduleLine.StockConfirmationPlant ||
+			key.ProductStockAvailabilityDate < *itemScheduleLine.RequestedDeliveryDate ||
+			availabilityDateTo != nil && key.ProductStockAvailabilityDate > *availabilityDateTo {
+			continue
+		}
+		if itemScheduleLine.StockConfirmationPlantBatch == nil {
+			if key.Batch != "" {
+				continue
+			}
+		} else if key.Batch == "" || !otherBatches && key.Batch != *itemScheduleLine.StockConfirmationPlantBatch {
+			continue
+		}
+		stocks = append(stocks, stock)
+	}
+	sort.Slice(stocks, func(i, j int) bool {
+		if stocks[i].ProductStockAvailabilityDate != stocks[j].ProductStockAvailabilityDate {
+			return stocks[i].ProductStockAvailabilityDate < stocks[j].ProductStockAvailabilityDate
+		}
+		return stocks[i].Batch < stocks[j].Batch
+	})
+	return &stocks
+}
+
+func keyOfProductStock(stock dpfm_api_output_formatter.ProductStock) productStockKey {
+	return productStockKey{
+		Product:                      stock.Product,
+		BusinessPartner:              stock.BusinessPartner,
+		Plant:                        stock.Plant,
+		Batch:                        stock.Batch,
+		ProductStockAvailabilityDate: stock.ProductStockAvailabilityDate,
+	}
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
@@ -0,0 +1,299 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"time"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"github.com/shopspring/decimal"
+	"golang.org/x/xerrors"
+)
+
+const stockAvailabilityDateLayout = "2006-01-02"
+
+// stockAllocation は、スケジュール行の確定数量のうち、要求納入日付・ロット以外の在庫から引き当てた数量です
+// itemScheduleLine は、RequestedDeliveryDate・StockConfirmationPlantBatch を引当先の在庫に置き換えたスケジュール行です
+type stockAllocation struct {
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine
+	quantity         decimal.Decimal
+}
+
+// releaseInventoryReservation は、スケジュール行の確定数量のうち releasedQuantity の在庫引当を解除し、解除後の確定数量を返します
+// 再引当で要求納入日付・ロット以外の在庫から引き当てた数量は、在庫の増減の記録から引当先を求めて先に戻す
+func (c *DPFMAPICaller) releaseInventoryReservation(
+	s *saga,
+	output *dpfm_api_output_formatter.SDC,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	releasedQuantity decimal.Decimal,
+	log *logger.Logger,
+) (*[]dpfm_api_output_formatter.ProductStock, decimal.Decimal) {
+	allocations := c.stockAllocations(s, itemScheduleLine)
+	if allocations == nil {
+		return nil, decimal.Zero
+	}
+
+	productStocks := make([]dpfm_api_output_formatter.ProductStock, 0)
+	remainingQuantity := releasedQuantity
+	for _, allocation := range *allocations {
+		if remainingQuantity.Sign() <= 0 {
+			break
+		}
+		quantity := decimal.Min(allocation.quantity, remainingQuantity)
+		productStock := c.releaseProductStock(s, output, allocation.itemScheduleLine, quantity, log)
+		if productStock == nil {
+			return nil, decimal.Zero
+		}
+		if productStock.Product != "" {
+			productStocks = append(productStocks, *productStock)
+		}
+		remainingQuantity = remainingQuantity.Sub(quantity)
+	}
+	if remainingQuantity.Sign() > 0 || len(productStocks) == 0 {
+		productStock := c.releaseProductStock(s, output, itemScheduleLine, remainingQuantity, log)
+		if productStock == nil {
+			return nil, decimal.Zero
+		}
+		if productStock.Product != "" {
+			productStocks = append(productStocks, *productStock)
+		}
+	}
+
+	return &productStocks, itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Sub(releasedQuantity)
+}
+
+// inventoryReservation は、キャンセル取消したスケジュール行の未確定の数量を在庫に再引当し、再引当後の確定数量を返します
+// 要求納入日付の在庫が不足する場合は、後の在庫利用可能日付の在庫（設定により同じプラントの他のロットの在庫を含む）から順に引き当て、
+// 引当先と不足数量を StockReservation として返す
+func (c *DPFMAPICaller) inventoryReservation(
+	s *saga,
+	output *dpfm_api_output_formatter.SDC,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	log *logger.Logger,
+) (*[]dpfm_api_output_formatter.ProductStock, decimal.Decimal) {
+	// スケジュール行の数量が登録されていない場合は、再引当する数量はないものとする
+	requiredQuantity := decimal.Zero
+	if itemScheduleLine.ScheduleLineOrderQuantityInBaseUnit != nil {
+		requiredQuantity = decimal.Max(itemScheduleLine.ScheduleLineOrderQuantityInBaseUnit.Sub(itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal), decimal.Zero)
+	}
+	targets := c.reservationTargets(s, itemScheduleLine, requiredQuantity, log)
+	if targets == nil {
+		return nil, decimal.Zero
+	}
+
+	reservation := dpfm_api_output_formatter.StockReservation{
+		OrderID:                             itemScheduleLine.OrderID,
+		OrderItem:                           itemScheduleLine.OrderItem,
+		ScheduleLine:                        itemScheduleLine.ScheduleLine,
+		ScheduleLineOrderQuantityInBaseUnit: itemScheduleLine.ScheduleLineOrderQuantityInBaseUnit,
+		StockAllocation:                     make([]dpfm_api_output_formatter.StockAllocation, 0),
+	}
+	productStocks := make([]dpfm_api_output_formatter.ProductStock, 0)
+	reservedQuantity := decimal.Zero
+	for _, target := range *targets {
+		if reservedQuantity.GreaterThanOrEqual(requiredQuantity) {
+			break
+		}
+		productStock, quantity := c.reserveProductStock(s, output, target, requiredQuantity.Sub(reservedQuantity), log)
+		if productStock == nil {
+			return nil, decimal.Zero
+		}
+		if quantity.IsZero() {
+			continue
+		}
+		productStocks = append(productStocks, *productStock)
+		reservedQuantity = reservedQuantity.Add(quantity)
+		reservation.StockAllocation = append(reservation.StockAllocation, dpfm_api_output_formatter.StockAllocation{
+			Plant:                        productStock.Plant,
+			Batch:                        target.StockConfirmationPlantBatch,
+			ProductStockAvailabilityDate: productStock.ProductStockAvailabilityDate,
+			QuantityInBaseUnit:           dpfm_api_output_formatter.NewQuantity(quantity),
+		})
+	}
+
+	confirmedQuantity := itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Add(reservedQuantity)
+	reservation.ConfirmedQuantityInBaseUnit = dpfm_api_output_formatter.NewQuantity(confirmedQuantity)
+	reservation.ShortfallQuantityInBaseUnit = dpfm_api_output_formatter.NewQuantity(requiredQuantity.Sub(reservedQuantity))
+	if reservation.ShortfallQuantityInBaseUnit.Sign() > 0 {
+		log.Info("order %d item %d schedule line %d: %s in base unit is not confirmed by stock", itemScheduleLine.OrderID, itemScheduleLine.OrderItem, itemScheduleLine.ScheduleLine, reservation.ShortfallQuantityInBaseUnit)
+	}
+	s.reservations = append(s.reservations, reservation)
+
+	return &productStocks, confirmedQuantity
+}
+
+// reservationTargets は、再引当で在庫を探すスケジュール行を、要求納入日付・ロットの在庫を先頭に引き当てる順で返します
+func (c *DPFMAPICaller) reservationTargets(
+	s *saga,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	requiredQuantity decimal.Decimal,
+	log *logger.Logger,
+) *[]dpfm_api_output_formatter.ItemScheduleLine {
+	targets := []dpfm_api_output_formatter.ItemScheduleLine{itemScheduleLine}
+	searchDays := c.conf.Cancellation.ReservationSearchDays()
+	otherBatches := c.conf.Cancellation.ReservationOtherBatches() && itemScheduleLine.StockConfirmationPlantBatch != nil
+	if requiredQuantity.Sign() <= 0 || itemScheduleLine.RequestedDeliveryDate == nil || searchDays == 0 && !otherBatches {
+		return &targets
+	}
+
+	var availabilityDateTo *string
+	if searchDays == 0 {
+		availabilityDateTo = itemScheduleLine.RequestedDeliveryDate
+	} else if searchDays > 0 {
+		requestedDeliveryDate, err := time.Parse(stockAvailabilityDateLayout, *itemScheduleLine.RequestedDeliveryDate)
+		if err != nil {
+			s.fail(xerrors.Errorf("order item schedule line %d-%d: requested delivery date %s is invalid: %w", itemScheduleLine.OrderItem, itemScheduleLine.ScheduleLine, *itemScheduleLine.RequestedDeliveryDate, err))
+			return nil
+		}
+		dateTo := requestedDeliveryDate.AddDate(0, 0, searchDays).Format(stockAvailabilityDateLayout)
+		availabilityDateTo = &dateTo
+	}
+	candidates := s.stocks.ProductStockCandidatesRead(itemScheduleLine, availabilityDateTo, otherBatches, log)
+	if candidates == nil {
+		s.fail(xerrors.Errorf("product stock candidates read error: %w", errSQL))
+		return nil
+	}
+
+	requestedStockKey := stockKey(itemScheduleLine)
+	for _, candidate := range *candidates {
+		target := itemScheduleLine
+		productStockAvailabilityDate := candidate.ProductStockAvailabilityDate
+		target.RequestedDeliveryDate = &productStockAvailabilityDate
+		if itemScheduleLine.StockConfirmationPlantBatch != nil {
+			batch := candidate.Batch
+			target.StockConfirmationPlantBatch = &batch
+		}
+		if stockKey(target) == requestedStockKey {
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return &targets
+}
+
+// stockAllocations は、在庫の増減の記録から、スケジュール行が要求納入日付・ロット以外の在庫から引き当てている数量を引き当てた順に返します
+func (c *DPFMAPICaller) stockAllocations(
+	s *saga,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+) *[]stockAllocation {
+	listStockMovements := c.stockLedger.List
+	if s.tx != nil {
+		listStockMovements = s.tx.StockMovements
+	}
+	movements, err := listStockMovements(StockMovementQuery{OrderID: &itemScheduleLine.OrderID})
+	if err != nil {
+		s.fail(err)
+		return nil
+	}
+
+	requestedStockKey := stockKey(itemScheduleLine)
+	allocations := make([]stockAllocation, 0)
+	indexes := make(map[string]int)
+	for _, movement := range movements {
+		if movement.OrderItem != itemScheduleLine.OrderItem || movement.ScheduleLine != itemScheduleLine.ScheduleLine {
+			continue
+		}
+		target := itemScheduleLine
+		productStockAvailabilityDate := movement.ProductStockAvailabilityDate
+		target.RequestedDeliveryDate = &productStockAvailabilityDate
+		target.StockConfirmationPlantBatch = movement.Batch
+		key := stockKey(target)
+		if key == requestedStockKey {
+			continue
+		}
+		i, ok := indexes[key]
+		if !ok {
+			i = len(allocations)
+			indexes[key] = i
+			allocations = append(allocations, stockAllocation{itemScheduleLine: target})
+		}
+		// 引当は在庫の減少として記録されている
+		allocations[i].quantity = allocations[i].quantity.Sub(movement.Quantity)
+	}
+
+	res := make([]stockAllocation, 0, len(allocations))
+	for _, allocation := range allocations {
+		if allocation.quantity.Sign() > 0 {
+			res = append(res, allocation)
+		}
+	}
+	return &res
+}
+
+// releaseProductStock は、在庫に releasedQuantity を戻し、更新後の在庫を返します
+func (c *DPFMAPICaller) releaseProductStock(
+	s *saga,
+	output *dpfm_api_output_formatter.SDC,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	releasedQuantity decimal.Decimal,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.ProductStock {
+	defer c.lockStock(s, itemScheduleLine)()
+
+	productStock, err := c.productStockRead(s, itemScheduleLine, log)
+	if err != nil {
+		s.fail(err)
+		return nil
+	}
+	// 在庫が存在しない場合や解除する数量がない場合は、更新しない
+	if productStock.Product == "" || releasedQuantity.Sign() <= 0 {
+		return productStock
+	}
+	data := *productStock
+	data.AvailableProductStock = dpfm_api_output_formatter.NewQuantity(productStock.AvailableProductStock.Add(releasedQuantity))
+
+	err = c.executeStock(s, itemScheduleLine, data, releasedQuantity, StockMovementRelease, log)
+	if err != nil {
+		log.Error("%+v", err)
+		output.SQLUpdateResult = getBoolPtr(false)
+		output.SQLUpdateError = productStockUpdateError(itemScheduleLine)
+		return nil
+	}
+	return &data
+}
+
+// reserveProductStock は、在庫から最大 requiredQuantity を引き当て、引き当てた数量を返します
+// 在庫が存在しない場合や在庫がない場合は、更新せずに 0 を返す
+func (c *DPFMAPICaller) reserveProductStock(
+	s *saga,
+	output *dpfm_api_output_formatter.SDC,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	requiredQuantity decimal.Decimal,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.ProductStock, decimal.Decimal) {
+	defer c.lockStock(s, itemScheduleLine)()
+
+	productStock, err := c.productStockRead(s, itemScheduleLine, log)
+	if err != nil {
+		s.fail(err)
+		return nil, decimal.Zero
+	}
+	reservedQuantity := decimal.Min(requiredQuantity, productStock.AvailableProductStock.Decimal)
+	if productStock.Product == "" || reservedQuantity.Sign() <= 0 {
+		return productStock, decimal.Zero
+	}
+	data := *productStock
+	data.AvailableProductStock = dpfm_api_output_formatter.NewQuantity(productStock.AvailableProductStock.Sub(reservedQuantity))
+
+	err = c.executeStock(s, itemScheduleLine, data, reservedQuantity.Neg(), StockMovementReservation, log)
+	if err != nil {
+		log.Error("%+v", err)
+		output.SQLUpdateResult = getBoolPtr(false)
+		output.SQLUpdateError = productStockUpdateError(itemScheduleLine)
+		return nil, decimal.Zero
+	}
+	return &data, reservedQuantity
+}
+
+func productStockName(itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine) string {
+	if itemScheduleLine.StockConfirmationPlantBatch == nil {
+		return "product stock availability"
+	}
+	return "product stock availability by batch"
+}
+
+func productStockUpdateError(itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine) string {
+	if itemScheduleLine.StockConfirmationPlantBatch == nil {
+		return "Product Stock Availability Data cannot update"
+	}
+	return "Product Stock Availability By Batch Data cannot update"
+}
@@ -0,0 +1,348 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"data-platform-api-orders-cancels-rmq-kube/metrics"
+	"fmt"
+	"time"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"github.com/shopspring/decimal"
+	"golang.org/x/xerrors"
+)
+
+var (
+	errSQL      = xerrors.New("sql error")
+	errRMQ      = xerrors.New("rmq error")
+	errNotFound = xerrors.New("not found")
+	errNotExist = xerrors.New("not exist")
+)
+
+// saga は、キャンセル処理で適用した更新を順に記録し、途中で失敗した場合に逆順で補償更新を行います
+// dryRun の場合は更新を送信せずに dryRunStore に保持し、以降の読み込みは保持した更新後の値を参照します
+// tx がある場合は、読み込み・更新をすべて 1 つのトランザクション内で行い、補償更新の代わりにロールバックします
+type saga struct {
+	sessionID string
+	dryRun    bool
+	orders    OrdersRepository
+	stocks    StockRepository
+	writer    SQLWriter
+	tx        Transaction
+	steps     []sagaStep
+	// キャンセル取消で再引当したスケジュール行ごとの引当結果
+	reservations []dpfm_api_output_formatter.StockReservation
+	err          error
+}
+
+type sagaStep struct {
+	function   string
+	message    interface{}
+	compensate func() error
+	// 在庫の更新の場合のみ、対象のスケジュール行と在庫の増減分を保持する
+	itemScheduleLine *dpfm_api_output_formatter.ItemScheduleLine
+	delta            decimal.Decimal
+	// スケジュール行の更新の場合のみ、ドメインイベントの種類を決める操作を保持する
+	action action
+}
+
+func (c *DPFMAPICaller) newSaga(sessionID string) (*saga, error) {
+	s := &saga{
+		sessionID: sessionID,
+		orders:    c.orders,
+		stocks:    c.stocks,
+		writer:    c.writer,
+		steps:     make([]sagaStep, 0),
+	}
+	if c.transactor == nil {
+		return s, nil
+	}
+	tx, err := c.transactor.Begin()
+	if err != nil {
+		return nil, err
+	}
+	s.tx = tx
+	s.orders = tx.Orders()
+	s.stocks = tx.Stocks()
+	s.writer = tx.Writer()
+	return s, nil
+}
+
+func (c *DPFMAPICaller) newDryRunSaga(sessionID string) *saga {
+	store := newDryRunStore(c.orders, c.stocks)
+	return &saga{
+		sessionID: sessionID,
+		dryRun:    true,
+		orders:    store,
+		stocks:    store,
+		writer:    store,
+		steps:     make([]sagaStep, 0),
+	}
+}
+
+// commit は、トランザクション内で行った更新を確定し、確定した在庫の増減をメトリクスに記録します
+func (s *saga) commit() error {
+	if s.tx != nil {
+		if err := s.tx.Commit(); err != nil {
+			return xerrors.Errorf("cancel transaction commit error: %v: %w", err, errSQL)
+		}
+		s.tx = nil
+	}
+	recordStockQuantities(s)
+	return nil
+}
+
+// rollback は、確定していないトランザクションを取り消します。確定済みの場合は何もしません
+func (s *saga) rollback() error {
+	if s.tx == nil {
+		return nil
+	}
+	tx := s.tx
+	s.tx = nil
+	s.steps = s.steps[:0]
+	if err := tx.Rollback(); err != nil {
+		return xerrors.Errorf("cancel transaction rollback error: %v: %w", err, errSQL)
+	}
+	return nil
+}
+
+func (s *saga) fail(err error) {
+	s.err = err
+}
+
+func (s *saga) failed() bool {
+	return s.err != nil
+}
+
+func (c *DPFMAPICaller) execute(
+	s *saga,
+	function string,
+	message interface{},
+	update func() error,
+	compensate func() error,
+) error {
+	if s.dryRun {
+		if err := update(); err != nil {
+			s.err = err
+			return err
+		}
+		s.steps = append(s.steps, sagaStep{
+			function:   function,
+			message:    message,
+			compensate: func() error { return nil },
+		})
+		return nil
+	}
+	start := time.Now()
+	err := update()
+	metrics.SQLUpdateDuration.WithLabelValues(function).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.err = err
+		return err
+	}
+	s.steps = append(s.steps, sagaStep{
+		function:   function,
+		message:    message,
+		compensate: compensate,
+	})
+	return nil
+}
+
+// executeHeader は、ヘッダを更新し、失敗時に更新前のヘッダを書き戻す補償処理を記録します
+func (c *DPFMAPICaller) executeHeader(
+	s *saga,
+	header *dpfm_api_output_formatter.Header,
+	before dpfm_api_output_formatter.Header,
+) error {
+	return c.execute(s, functionHeader, header,
+		func() error { return s.writer.UpdateHeader(s.sessionID, *header) },
+		func() error { return s.writer.UpdateHeader(s.sessionID, before) },
+	)
+}
+
+func (c *DPFMAPICaller) executeItem(
+	s *saga,
+	item dpfm_api_output_formatter.Item,
+	before dpfm_api_output_formatter.Item,
+) error {
+	return c.execute(s, functionItem, item,
+		func() error { return s.writer.UpdateItem(s.sessionID, item) },
+		func() error { return s.writer.UpdateItem(s.sessionID, before) },
+	)
+}
+
+// executeItemScheduleLine は、スケジュール行を更新し、失敗時に更新前のスケジュール行を書き戻す補償処理を記録します
+// cancelledQuantity は、actionPartialCancel の場合にこの更新で一部キャンセルする数量です。書き込み先はキャンセル済みの数量に加算するため、補償処理では同じ数量を減算する
+func (c *DPFMAPICaller) executeItemScheduleLine(
+	s *saga,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	before dpfm_api_output_formatter.ItemScheduleLine,
+	a action,
+	cancelledQuantity *decimal.Decimal,
+) error {
+	itemScheduleLine.CancelledQuantityInBaseUnit = nil
+	before.CancelledQuantityInBaseUnit = nil
+	if cancelledQuantity != nil {
+		itemScheduleLine.CancelledQuantityInBaseUnit = &dpfm_api_output_formatter.Quantity{Decimal: *cancelledQuantity}
+		before.CancelledQuantityInBaseUnit = &dpfm_api_output_formatter.Quantity{Decimal: cancelledQuantity.Neg()}
+	}
+	err := c.execute(s, functionItemScheduleLine, itemScheduleLine,
+		func() error { return s.writer.UpdateScheduleLine(s.sessionID, itemScheduleLine) },
+		func() error { return s.writer.UpdateScheduleLine(s.sessionID, before) },
+	)
+	if err != nil {
+		return err
+	}
+	s.steps[len(s.steps)-1].action = a
+	return nil
+}
+
+// executeStock は、スケジュール行の在庫確認先の在庫を更新し、失敗時に増減分を打ち消す補償処理を記録します
+// 更新した在庫の増減は movementType で在庫の増減の記録に残し、記録できない場合は更新も取り消す
+func (c *DPFMAPICaller) executeStock(
+	s *saga,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	data dpfm_api_output_formatter.ProductStock,
+	delta decimal.Decimal,
+	movementType string,
+	log *logger.Logger,
+) error {
+	function := functionProductStock
+	if itemScheduleLine.StockConfirmationPlantBatch != nil {
+		function = functionProductStockByBatch
+	}
+	update := func() error { return c.updateStock(s, itemScheduleLine, data) }
+	if err := c.execute(s, function, data, update, c.restoreStock(s, itemScheduleLine, delta, log)); err != nil {
+		return err
+	}
+	step := &s.steps[len(s.steps)-1]
+	step.itemScheduleLine = &itemScheduleLine
+	step.delta = delta
+	if s.dryRun {
+		return nil
+	}
+	if err := c.appendStockMovement(s, movementType, itemScheduleLine, data, delta); err != nil {
+		s.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (c *DPFMAPICaller) updateStock(
+	s *saga,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	productStock dpfm_api_output_formatter.ProductStock,
+) error {
+	if itemScheduleLine.StockConfirmationPlantBatch == nil {
+		return s.writer.UpdateStock(s.sessionID, productStock)
+	}
+	return s.writer.UpdateStockByBatch(s.sessionID, productStock)
+}
+
+// productStockRead は、スケジュール行の在庫確認先の在庫を読み込み、在庫数量をスケジュール行の基本数量単位の小数桁数に揃えます
+func (c *DPFMAPICaller) productStockRead(
+	s *saga,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.ProductStock, error) {
+	scale, err := c.quantityScale(itemScheduleLine)
+	if err != nil {
+		return nil, err
+	}
+	var productStock *dpfm_api_output_formatter.ProductStock
+	if itemScheduleLine.StockConfirmationPlantBatch == nil {
+		productStock = s.stocks.ProductStockAvailabilityRead(itemScheduleLine, log)
+	} else {
+		productStock = s.stocks.ProductStockAvailabilityByBatchRead(itemScheduleLine, log)
+	}
+	if productStock == nil {
+		return nil, xerrors.Errorf("%s read error: %w", productStockName(itemScheduleLine), errSQL)
+	}
+	productStock.AvailableProductStock = dpfm_api_output_formatter.NewQuantity(productStock.AvailableProductStock.Round(scale))
+	return productStock, nil
+}
+
+// lockStock は、同じ在庫の読み込みから更新までを他のキャンセルと並行して実行しないようにロックします
+// トランザクションで更新する場合は SELECT ... FOR UPDATE の行ロックで直列化されるため、ロックしない
+func (c *DPFMAPICaller) lockStock(
+	s *saga,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+) func() {
+	if s.tx != nil {
+		return func() {}
+	}
+	return c.stockLocks.Lock(stockKey(itemScheduleLine))
+}
+
+func stockKey(itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine) string {
+	batch := ""
+	if itemScheduleLine.StockConfirmationPlantBatch != nil {
+		batch = *itemScheduleLine.StockConfirmationPlantBatch
+	}
+	requestedDeliveryDate := ""
+	if itemScheduleLine.RequestedDeliveryDate != nil {
+		requestedDeliveryDate = *itemScheduleLine.RequestedDeliveryDate
+	}
+	return fmt.Sprintf("%s/%d/%s/%s/%s", itemScheduleLine.Product, itemScheduleLine.StockConfirmationBusinessPartner, itemScheduleLine.StockConfirmationPlant, batch, requestedDeliveryDate)
+}
+
+func (c *DPFMAPICaller) compensate(
+	s *saga,
+	log *logger.Logger,
+) []error {
+	errs := make([]error, 0)
+	if s.tx != nil {
+		if err := s.rollback(); err != nil {
+			log.Error("%+v", err)
+			errs = append(errs, err)
+		}
+		return errs
+	}
+	for i := len(s.steps) - 1; i >= 0; i-- {
+		step := s.steps[i]
+		if err := step.compensate(); err != nil {
+			err = xerrors.Errorf("%s compensation error: %w", step.function, err)
+			log.Error("%+v", err)
+			errs = append(errs, err)
+		}
+	}
+	s.steps = s.steps[:0]
+	return errs
+}
+
+// restoreStock は、在庫の増減分を打ち消す補償処理を返します
+// 在庫は他のオーダーからも更新されるため、更新前の値ではなく現在の値から差分を戻す
+func (c *DPFMAPICaller) restoreStock(
+	s *saga,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	delta decimal.Decimal,
+	log *logger.Logger,
+) func() error {
+	return func() error {
+		defer c.stockLocks.Lock(stockKey(itemScheduleLine))()
+
+		scale, err := c.quantityScale(itemScheduleLine)
+		if err != nil {
+			return err
+		}
+		if itemScheduleLine.StockConfirmationPlantBatch == nil {
+			productStock := c.stocks.ProductStockAvailabilityRead(itemScheduleLine, log)
+			if productStock == nil {
+				return xerrors.Errorf("product stock availability read error: %w", errSQL)
+			}
+			productStock.AvailableProductStock = dpfm_api_output_formatter.NewQuantity(productStock.AvailableProductStock.Round(scale).Sub(delta))
+			if err := c.writer.UpdateStock(s.sessionID, *productStock); err != nil {
+				return err
+			}
+			return c.appendStockMovement(s, StockMovementCompensation, itemScheduleLine, *productStock, delta.Neg())
+		}
+		productStock := c.stocks.ProductStockAvailabilityByBatchRead(itemScheduleLine, log)
+		if productStock == nil {
+			return xerrors.Errorf("product stock availability by batch read error: %w", errSQL)
+		}
+		productStock.AvailableProductStock = dpfm_api_output_formatter.NewQuantity(productStock.AvailableProductStock.Round(scale).Sub(delta))
+		if err := c.writer.UpdateStockByBatch(s.sessionID, *productStock); err != nil {
+			return err
+		}
+		return c.appendStockMovement(s, StockMovementCompensation, itemScheduleLine, *productStock, delta.Neg())
+	}
+}
@@ -0,0 +1,179 @@
+package dpfm_api_caller
+
+import (
+	"context"
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"encoding/json"
+	"time"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"golang.org/x/xerrors"
+)
+
+// scheduleCancels は、EffectiveDateTime に実行するキャンセル要求を登録します
+// 権限と理由コードは登録時に確認し、キャンセルポリシーは実行時のオーダーの状態で判定します
+func (c *DPFMAPICaller) scheduleCancels(
+	input *dpfm_api_input_reader.SDC,
+	accepter []string,
+	effectiveDateTime time.Time,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.ScheduledCancellation, error) {
+	if err := c.authorize(c.orders, input, accepter, true, log); err != nil {
+		return nil, err
+	}
+	if err := c.validateCancellationReasonCodes(input); err != nil {
+		return nil, err
+	}
+
+	scheduledInput := *input
+	scheduledInput.EffectiveDateTime = nil
+	raw, err := json.Marshal(scheduledInput)
+	if err != nil {
+		return nil, xerrors.Errorf("scheduled cancellation input marshal error: %w", err)
+	}
+	scheduled, err := c.scheduled.Save(&ScheduledCancellation{
+		RuntimeSessionID:  input.RuntimeSessionID,
+		OrderID:           input.Header.OrderID,
+		BusinessPartner:   input.BusinessPartner,
+		Accepter:          accepter,
+		Input:             raw,
+		EffectiveDateTime: effectiveDateTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info("order %d: cancellation is scheduled at %s", scheduled.OrderID, scheduled.EffectiveDateTime.Format(time.RFC3339))
+	return convertToScheduledCancellation(scheduled), nil
+}
+
+func (c *DPFMAPICaller) scheduledCancellationList(
+	input *dpfm_api_input_reader.SDC,
+) (*[]dpfm_api_output_formatter.ScheduledCancellation, error) {
+	var orderID *int
+	if input.Header.OrderID != 0 {
+		orderID = &input.Header.OrderID
+	}
+	scheduled, err := c.scheduled.List(input.BusinessPartner, orderID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dpfm_api_output_formatter.ScheduledCancellation, 0, len(scheduled))
+	for i := range scheduled {
+		res = append(res, *convertToScheduledCancellation(&scheduled[i]))
+	}
+	return &res, nil
+}
+
+func (c *DPFMAPICaller) scheduledCancellationRevoke(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) (*dpfm_api_output_formatter.ScheduledCancellation, error) {
+	if input.ScheduledCancellationID == nil {
+		return nil, xerrors.New("ScheduledCancellationID is required")
+	}
+	id := *input.ScheduledCancellationID
+	ok, err := c.scheduled.Revoke(id, input.BusinessPartner)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, xerrors.Errorf("pending scheduled cancellation %d of business partner %d: %w", id, input.BusinessPartner, errNotFound)
+	}
+	log.Info("scheduled cancellation %d is revoked", id)
+	return &dpfm_api_output_formatter.ScheduledCancellation{
+		ScheduledCancellationID: id,
+		BusinessPartner:         input.BusinessPartner,
+		Status:                  ScheduledCancellationRevoked,
+	}, nil
+}
+
+// RunScheduledCancellations は、停止の通知を受けるまで一定間隔で実行日時を過ぎたキャンセル要求を実行します
+func (c *DPFMAPICaller) RunScheduledCancellations(ctx context.Context, log *logger.Logger) {
+	ticker := time.NewTicker(c.conf.Cancellation.SchedulerInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.executeScheduledCancellations(ctx, log)
+		}
+	}
+}
+
+func (c *DPFMAPICaller) executeScheduledCancellations(ctx context.Context, log *logger.Logger) {
+	claimTimeout := c.conf.Cancellation.SchedulerClaimTimeout()
+	due, err := c.scheduled.Due(time.Now(), claimTimeout)
+	if err != nil {
+		log.Error("%+v", err)
+		return
+	}
+	for _, scheduled := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		ok, err := c.scheduled.Claim(scheduled.ScheduledCancellationID, claimTimeout)
+		if err != nil {
+			log.Error("%+v", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if scheduled.Status == ScheduledCancellationRunning {
+			// 実行中のまま停止したレプリカのキャンセル要求は、処理済みの記録により二重にキャンセルされない
+			log.Info("scheduled cancellation %d is claimed again because the previous claim expired", scheduled.ScheduledCancellationID)
+		}
+
+		status := ScheduledCancellationExecuted
+		var errMessage *string
+		if err := c.executeScheduledCancellation(&scheduled, log); err != nil {
+			log.Error("scheduled cancellation %d: %+v", scheduled.ScheduledCancellationID, err)
+			status = ScheduledCancellationFailed
+			msg := err.Error()
+			errMessage = &msg
+		}
+		if err := c.scheduled.Finish(scheduled.ScheduledCancellationID, status, errMessage); err != nil {
+			log.Error("%+v", err)
+		}
+	}
+}
+
+// executeScheduledCancellation は、登録時の入力で通常のキャンセルと同じ処理を行います
+func (c *DPFMAPICaller) executeScheduledCancellation(
+	scheduled *ScheduledCancellation,
+	log *logger.Logger,
+) error {
+	var input dpfm_api_input_reader.SDC
+	var output dpfm_api_output_formatter.SDC
+	if err := json.Unmarshal(scheduled.Input, &input); err != nil {
+		return xerrors.Errorf("scheduled cancellation input unmarshal error: %w", err)
+	}
+	if err := json.Unmarshal(scheduled.Input, &output); err != nil {
+		return xerrors.Errorf("scheduled cancellation input unmarshal error: %w", err)
+	}
+	_, err := c.cancels(&input, &output, scheduled.Accepter, log)
+	return err
+}
+
+func parseEffectiveDateTime(effectiveDateTime string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, effectiveDateTime)
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("EffectiveDateTime must be RFC3339: %w", err)
+	}
+	return t, nil
+}
+
+func convertToScheduledCancellation(scheduled *ScheduledCancellation) *dpfm_api_output_formatter.ScheduledCancellation {
+	return &dpfm_api_output_formatter.ScheduledCancellation{
+		ScheduledCancellationID: scheduled.ScheduledCancellationID,
+		OrderID:                 scheduled.OrderID,
+		BusinessPartner:         scheduled.BusinessPartner,
+		Accepter:                scheduled.Accepter,
+		EffectiveDateTime:       scheduled.EffectiveDateTime.Format(time.RFC3339),
+		Status:                  scheduled.Status,
+		Error:                   scheduled.Error,
+		CreationDateTime:        scheduled.CreationDateTime.Format(time.RFC3339),
+	}
+}
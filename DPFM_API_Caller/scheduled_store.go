@@ -0,0 +1,343 @@
+package dpfm_api_caller
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/latonaio/golang-mysql-network-connector"
+	"golang.org/x/xerrors"
+)
+
+const (
+	ScheduledCancellationPending  = "Pending"
+	ScheduledCancellationRunning  = "Running"
+	ScheduledCancellationExecuted = "Executed"
+	ScheduledCancellationFailed   = "Failed"
+	ScheduledCancellationRevoked  = "Revoked"
+
+	scheduledDateTimeLayout = "2006-01-02 15:04:05"
+)
+
+// ScheduledCancellationStore は、実行日時を指定されたキャンセル要求を実行まで保持します
+type ScheduledCancellationStore interface {
+	// Save は、キャンセル要求を登録します。同じ要求が登録済みの場合は登録済みのものを返します
+	Save(scheduled *ScheduledCancellation) (*ScheduledCancellation, error)
+	List(businessPartner int, orderID *int) ([]ScheduledCancellation, error)
+	// Due は、実行日時を過ぎた未実行のキャンセル要求と、実行中にしてから claimTimeout を過ぎたキャンセル要求を返します
+	Due(now time.Time, claimTimeout time.Duration) ([]ScheduledCancellation, error)
+	// Claim は、未実行のキャンセル要求を実行中にします。他で実行中または取消済みの場合は false を返します
+	// 実行中にしてから claimTimeout を過ぎたキャンセル要求は、実行したレプリカが停止したものとみなして再度実行中にします
+	Claim(id int, claimTimeout time.Duration) (bool, error)
+	Finish(id int, status string, errMessage *string) error
+	// Revoke は、登録した取引先の未実行のキャンセル要求を取り消します
+	Revoke(id int, businessPartner int) (bool, error)
+}
+
+type ScheduledCancellation struct {
+	ScheduledCancellationID int
+	RuntimeSessionID        string
+	OrderID                 int
+	BusinessPartner         int
+	Accepter                []string
+	Input                   []byte
+	EffectiveDateTime       time.Time
+	Status                  string
+	Error                   *string
+	CreationDateTime        time.Time
+}
+
+type MySQLScheduledCancellationStore struct {
+	db *database.Mysql
+}
+
+func NewMySQLScheduledCancellationStore(db *database.Mysql) (*MySQLScheduledCancellationStore, error) {
+	_, err := db.Exec(
+		`CREATE TABLE IF NOT EXISTS DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_scheduled_cancellation_data (
+			ScheduledCancellationID int(16) NOT NULL AUTO_INCREMENT,
+			RuntimeSessionID varchar(100) NOT NULL,
+			OrderID int(16) NOT NULL,
+			BusinessPartner int(12) NOT NULL,
+			Accepter varchar(100) NOT NULL,
+			Input json NOT NULL,
+			EffectiveDateTime datetime NOT NULL,
+			Status varchar(20) NOT NULL,
+			Error text DEFAULT NULL,
+			CreationDateTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			LastChangeDateTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (ScheduledCancellationID),
+			UNIQUE KEY (RuntimeSessionID, OrderID, Accepter),
+			KEY (Status, EffectiveDateTime)
+		);`,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("scheduled cancellation table create error: %w", err)
+	}
+	return &MySQLScheduledCancellationStore{db: db}, nil
+}
+
+const scheduledCancellationColumns = `ScheduledCancellationID, RuntimeSessionID, OrderID, BusinessPartner, Accepter, Input,
+	EffectiveDateTime, Status, Error, CreationDateTime`
+
+func (s *MySQLScheduledCancellationStore) Save(scheduled *ScheduledCancellation) (*ScheduledCancellation, error) {
+	accepter := strings.Join(scheduled.Accepter, ",")
+	_, err := s.db.Exec(
+		`INSERT IGNORE INTO DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_scheduled_cancellation_data
+		(RuntimeSessionID, OrderID, BusinessPartner, Accepter, Input, EffectiveDateTime, Status) VALUES (?, ?, ?, ?, ?, ?, ?);`,
+		scheduled.RuntimeSessionID, scheduled.OrderID, scheduled.BusinessPartner, accepter, scheduled.Input,
+		scheduled.EffectiveDateTime.UTC().Format(scheduledDateTimeLayout), ScheduledCancellationPending,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("scheduled cancellation write error: %w", err)
+	}
+
+	rows, err := s.db.Query(
+		`SELECT `+scheduledCancellationColumns+`
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_scheduled_cancellation_data
+		WHERE (RuntimeSessionID, OrderID, Accepter) = (?, ?, ?);`, scheduled.RuntimeSessionID, scheduled.OrderID, accepter,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("scheduled cancellation read error: %w", err)
+	}
+	saved, err := scanScheduledCancellations(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(saved) == 0 {
+		return nil, xerrors.Errorf("scheduled cancellation read error: %w", errNotFound)
+	}
+	return &saved[0], nil
+}
+
+func (s *MySQLScheduledCancellationStore) List(businessPartner int, orderID *int) ([]ScheduledCancellation, error) {
+	query := `SELECT ` + scheduledCancellationColumns + `
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_scheduled_cancellation_data
+		WHERE BusinessPartner = ?`
+	args := []interface{}{businessPartner}
+	if orderID != nil {
+		query += ` AND OrderID = ?`
+		args = append(args, *orderID)
+	}
+	rows, err := s.db.Query(query+` ORDER BY EffectiveDateTime, ScheduledCancellationID;`, args...)
+	if err != nil {
+		return nil, xerrors.Errorf("scheduled cancellation read error: %w", err)
+	}
+	return scanScheduledCancellations(rows)
+}
+
+func (s *MySQLScheduledCancellationStore) Due(now time.Time, claimTimeout time.Duration) ([]ScheduledCancellation, error) {
+	rows, err := s.db.Query(
+		`SELECT `+scheduledCancellationColumns+`
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_scheduled_cancellation_data
+		WHERE (Status = ? AND EffectiveDateTime <= ?)
+		OR (Status = ? AND LastChangeDateTime <= CURRENT_TIMESTAMP - INTERVAL ? SECOND)
+		ORDER BY EffectiveDateTime, ScheduledCancellationID;`,
+		ScheduledCancellationPending, now.UTC().Format(scheduledDateTimeLayout),
+		ScheduledCancellationRunning, int(claimTimeout.Seconds()),
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("scheduled cancellation read error: %w", err)
+	}
+	return scanScheduledCancellations(rows)
+}
+
+// Claim は、実行中にした日時を LastChangeDateTime に記録します
+// 状態が変わらない再度の実行中でも記録されるよう、LastChangeDateTime は明示的に更新する
+func (s *MySQLScheduledCancellationStore) Claim(id int, claimTimeout time.Duration) (bool, error) {
+	res, err := s.db.Exec(
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_scheduled_cancellation_data
+		SET Status = ?, LastChangeDateTime = CURRENT_TIMESTAMP
+		WHERE ScheduledCancellationID = ?
+		AND (Status = ? OR (Status = ? AND LastChangeDateTime <= CURRENT_TIMESTAMP - INTERVAL ? SECOND));`,
+		ScheduledCancellationRunning, id,
+		ScheduledCancellationPending, ScheduledCancellationRunning, int(claimTimeout.Seconds()),
+	)
+	if err != nil {
+		return false, xerrors.Errorf("scheduled cancellation %d write error: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, xerrors.Errorf("scheduled cancellation %d write error: %w", id, err)
+	}
+	return n == 1, nil
+}
+
+func (s *MySQLScheduledCancellationStore) Finish(id int, status string, errMessage *string) error {
+	_, err := s.db.Exec(
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_scheduled_cancellation_data
+		SET Status = ?, Error = ? WHERE ScheduledCancellationID = ?;`, status, errMessage, id,
+	)
+	if err != nil {
+		return xerrors.Errorf("scheduled cancellation write error: %w", err)
+	}
+	return nil
+}
+
+func (s *MySQLScheduledCancellationStore) Revoke(id int, businessPartner int) (bool, error) {
+	return s.transition(id, ScheduledCancellationPending, ScheduledCancellationRevoked, `ScheduledCancellationID = ? AND BusinessPartner = ?`, id, businessPartner)
+}
+
+// transition は、状態が from のキャンセル要求のみを to に更新します
+// 複数のレプリカが同じキャンセル要求を実行しないように、更新できたかどうかで判定する
+func (s *MySQLScheduledCancellationStore) transition(id int, from, to, where string, args ...interface{}) (bool, error) {
+	res, err := s.db.Exec(
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_scheduled_cancellation_data
+		SET Status = ? WHERE `+where+` AND Status = ?;`, append(append([]interface{}{to}, args...), from)...,
+	)
+	if err != nil {
+		return false, xerrors.Errorf("scheduled cancellation %d write error: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, xerrors.Errorf("scheduled cancellation %d write error: %w", id, err)
+	}
+	return n == 1, nil
+}
+
+func scanScheduledCancellations(rows *sql.Rows) ([]ScheduledCancellation, error) {
+	defer rows.Close()
+	scheduled := make([]ScheduledCancellation, 0)
+	for rows.Next() {
+		var (
+			sc                ScheduledCancellation
+			accepter          string
+			effectiveDateTime string
+			creationDateTime  string
+		)
+		err := rows.Scan(
+			&sc.ScheduledCancellationID,
+			&sc.RuntimeSessionID,
+			&sc.OrderID,
+			&sc.BusinessPartner,
+			&accepter,
+			&sc.Input,
+			&effectiveDateTime,
+			&sc.Status,
+			&sc.Error,
+			&creationDateTime,
+		)
+		if err != nil {
+			return nil, xerrors.Errorf("scheduled cancellation scan error: %w", err)
+		}
+		sc.Accepter = strings.Split(accepter, ",")
+		if sc.EffectiveDateTime, err = time.ParseInLocation(scheduledDateTimeLayout, effectiveDateTime, time.UTC); err != nil {
+			return nil, xerrors.Errorf("scheduled cancellation scan error: %w", err)
+		}
+		if sc.CreationDateTime, err = time.ParseInLocation(scheduledDateTimeLayout, creationDateTime, time.UTC); err != nil {
+			return nil, xerrors.Errorf("scheduled cancellation scan error: %w", err)
+		}
+		scheduled = append(scheduled, sc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("scheduled cancellation scan error: %w", err)
+	}
+	return scheduled, nil
+}
+
+type MemoryScheduledCancellationStore struct {
+	mtx       sync.Mutex
+	lastID    int
+	scheduled map[int]*ScheduledCancellation
+	// 実行中にした日時
+	claimed map[int]time.Time
+}
+
+func NewMemoryScheduledCancellationStore() *MemoryScheduledCancellationStore {
+	return &MemoryScheduledCancellationStore{
+		scheduled: make(map[int]*ScheduledCancellation),
+		claimed:   make(map[int]time.Time),
+	}
+}
+
+func (s *MemoryScheduledCancellationStore) Save(scheduled *ScheduledCancellation) (*ScheduledCancellation, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	accepter := strings.Join(scheduled.Accepter, ",")
+	for _, sc := range s.scheduled {
+		if sc.RuntimeSessionID == scheduled.RuntimeSessionID && sc.OrderID == scheduled.OrderID && strings.Join(sc.Accepter, ",") == accepter {
+			saved := *sc
+			return &saved, nil
+		}
+	}
+	s.lastID++
+	sc := *scheduled
+	sc.ScheduledCancellationID = s.lastID
+	sc.EffectiveDateTime = sc.EffectiveDateTime.UTC().Truncate(time.Second)
+	sc.Status = ScheduledCancellationPending
+	sc.Error = nil
+	sc.CreationDateTime = time.Now().UTC().Truncate(time.Second)
+	s.scheduled[sc.ScheduledCancellationID] = &sc
+	saved := sc
+	return &saved, nil
+}
+
+func (s *MemoryScheduledCancellationStore) List(businessPartner int, orderID *int) ([]ScheduledCancellation, error) {
+	return s.filter(func(sc *ScheduledCancellation) bool {
+		return sc.BusinessPartner == businessPartner && (orderID == nil || sc.OrderID == *orderID)
+	}), nil
+}
+
+func (s *MemoryScheduledCancellationStore) Due(now time.Time, claimTimeout time.Duration) ([]ScheduledCancellation, error) {
+	return s.filter(func(sc *ScheduledCancellation) bool {
+		return (sc.Status == ScheduledCancellationPending && !sc.EffectiveDateTime.After(now)) || s.claimExpired(sc, claimTimeout)
+	}), nil
+}
+
+func (s *MemoryScheduledCancellationStore) Claim(id int, claimTimeout time.Duration) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sc, ok := s.scheduled[id]
+	if !ok || (sc.Status != ScheduledCancellationPending && !s.claimExpired(sc, claimTimeout)) {
+		return false, nil
+	}
+	sc.Status = ScheduledCancellationRunning
+	s.claimed[id] = time.Now()
+	return true, nil
+}
+
+func (s *MemoryScheduledCancellationStore) claimExpired(sc *ScheduledCancellation, claimTimeout time.Duration) bool {
+	return sc.Status == ScheduledCancellationRunning && time.Since(s.claimed[sc.ScheduledCancellationID]) >= claimTimeout
+}
+
+func (s *MemoryScheduledCancellationStore) Finish(id int, status string, errMessage *string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sc, ok := s.scheduled[id]
+	if !ok {
+		return xerrors.Errorf("scheduled cancellation %d: %w", id, errNotFound)
+	}
+	sc.Status = status
+	sc.Error = errMessage
+	return nil
+}
+
+func (s *MemoryScheduledCancellationStore) Revoke(id int, businessPartner int) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sc, ok := s.scheduled[id]
+	if !ok || sc.BusinessPartner != businessPartner || sc.Status != ScheduledCancellationPending {
+		return false, nil
+	}
+	sc.Status = ScheduledCancellationRevoked
+	return true, nil
+}
+
+func (s *MemoryScheduledCancellationStore) filter(match func(sc *ScheduledCancellation) bool) []ScheduledCancellation {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	scheduled := make([]ScheduledCancellation, 0)
+	for _, sc := range s.scheduled {
+		if match(sc) {
+			scheduled = append(scheduled, *sc)
+		}
+	}
+	sort.Slice(scheduled, func(i, j int) bool {
+		if !scheduled[i].EffectiveDateTime.Equal(scheduled[j].EffectiveDateTime) {
+			return scheduled[i].EffectiveDateTime.Before(scheduled[j].EffectiveDateTime)
+		}
+		return scheduled[i].ScheduledCancellationID < scheduled[j].ScheduledCancellationID
+	})
+	return scheduled
+}
@@ -0,0 +1,46 @@
+package dpfm_api_caller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryScheduledCancellationStoreReclaimsExpiredClaims(t *testing.T) {
+	s := NewMemoryScheduledCancellationStore()
+	scheduled, err := s.Save(&ScheduledCancellation{
+		RuntimeSessionID:  "scheduled",
+		OrderID:           testOrderID,
+		BusinessPartner:   testSeller,
+		Accepter:          []string{"Header"},
+		EffectiveDateTime: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := scheduled.ScheduledCancellationID
+
+	if ok, err := s.Claim(id, time.Hour); err != nil || !ok {
+		t.Fatalf("pending scheduled cancellation cannot be claimed: %v", err)
+	}
+	if ok, _ := s.Claim(id, time.Hour); ok {
+		t.Error("running scheduled cancellation is claimed again before the claim expires")
+	}
+	if due, _ := s.Due(time.Now(), time.Hour); len(due) != 0 {
+		t.Errorf("due = %+v, want none before the claim expires", due)
+	}
+
+	// 実行したレプリカが停止し、実行中のまま claimTimeout を過ぎた場合
+	if due, _ := s.Due(time.Now(), 0); len(due) != 1 || due[0].ScheduledCancellationID != id {
+		t.Errorf("due = %+v, want the expired claim", due)
+	}
+	if ok, err := s.Claim(id, 0); err != nil || !ok {
+		t.Fatalf("expired claim cannot be claimed again: %v", err)
+	}
+
+	if err := s.Finish(id, ScheduledCancellationExecuted, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Claim(id, 0); ok {
+		t.Error("executed scheduled cancellation is claimed again")
+	}
+}
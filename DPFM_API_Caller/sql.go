@@ -4,12 +4,36 @@ import (
 	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
 	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
 
+	"database/sql"
 	"fmt"
 
 	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	database "github.com/latonaio/golang-mysql-network-connector"
 )
 
-func (c *DPFMAPICaller) HeaderRead(
+// queryer は、*database.Mysql と *sql.Tx のどちらからでも読み込めるようにするためのインターフェースです
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type MySQLOrdersRepository struct {
+	db queryer
+}
+
+func NewMySQLOrdersRepository(db *database.Mysql) *MySQLOrdersRepository {
+	return &MySQLOrdersRepository{db: db}
+}
+
+type MySQLStockRepository struct {
+	db        queryer
+	forUpdate bool
+}
+
+func NewMySQLStockRepository(db *database.Mysql) *MySQLStockRepository {
+	return &MySQLStockRepository{db: db}
+}
+
+func (r *MySQLOrdersRepository) HeaderRead(
 	input *dpfm_api_input_reader.SDC,
 	log *logger.Logger,
 ) *dpfm_api_output_formatter.Header {
@@ -18,9 +42,10 @@ func (c *DPFMAPICaller) HeaderRead(
 		where = fmt.Sprintf("%s \n AND HeaderDeliveryStatus = %s ", where, *input.Header.HeaderDeliveryStatus)
 	}
 	where = fmt.Sprintf("%s \n AND ( header.Buyer = %d OR header.Seller = %d ) ", where, input.BusinessPartner, input.BusinessPartner)
-	rows, err := c.db.Query(
+	rows, err := r.db.Query(
 		`SELECT 
-			header.OrderID
+			header.OrderID, header.HeaderDeliveryStatus, header.HeaderBillingStatus, header.IsCancelled, header.IsMarkedForDeletion,
+			header.CancellationReasonCode, header.CancellationComment
 		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_header_data as header ` + where + ` ;`)
 	if err != nil {
 		log.Error("%+v", err)
@@ -37,15 +62,93 @@ func (c *DPFMAPICaller) HeaderRead(
 	return data
 }
 
-func (c *DPFMAPICaller) ItemsRead(
+func (r *MySQLOrdersRepository) HeaderPartnerRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *dpfm_api_output_formatter.HeaderPartner {
+	rows, err := r.db.Query(
+		`SELECT 
+			header.OrderID, header.Buyer, header.Seller
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_header_data as header
+		WHERE header.OrderID = ?;`, input.Header.OrderID,
+	)
+	if err != nil {
+		log.Error("%+v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	data, err := dpfm_api_output_formatter.ConvertToHeaderPartner(rows)
+	if err != nil {
+		log.Error("%+v", err)
+		return nil
+	}
+
+	return data
+}
+
+func (r *MySQLOrdersRepository) OrdersByCriteriaRead(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) *[]int {
+	criteria := input.Criteria
+	args := make([]interface{}, 0)
+	where := "WHERE ( header.Buyer = ? OR header.Seller = ? )"
+	args = append(args, input.BusinessPartner, input.BusinessPartner)
+	where = fmt.Sprintf("%s\nAND ( header.IsCancelled IS NULL OR header.IsCancelled = false )", where)
+	where = fmt.Sprintf("%s\nAND ( header.IsMarkedForDeletion IS NULL OR header.IsMarkedForDeletion = false )", where)
+	if criteria.Buyer != nil {
+		where = fmt.Sprintf("%s\nAND header.Buyer = ?", where)
+		args = append(args, *criteria.Buyer)
+	}
+	if criteria.Seller != nil {
+		where = fmt.Sprintf("%s\nAND header.Seller = ?", where)
+		args = append(args, *criteria.Seller)
+	}
+	if criteria.RequestedDeliveryDateTo != nil {
+		where = fmt.Sprintf("%s\nAND itemScheduleLine.RequestedDeliveryDate < ?", where)
+		args = append(args, *criteria.RequestedDeliveryDateTo)
+	}
+	if criteria.Product != nil {
+		where = fmt.Sprintf("%s\nAND itemScheduleLine.Product = ?", where)
+		args = append(args, *criteria.Product)
+	}
+	if criteria.Plant != nil {
+		where = fmt.Sprintf("%s\nAND itemScheduleLine.StockConfirmationPlant = ?", where)
+		args = append(args, *criteria.Plant)
+	}
+	rows, err := r.db.Query(
+		`SELECT DISTINCT header.OrderID
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_header_data as header
+		LEFT JOIN DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_item_schedule_line_data as itemScheduleLine
+		ON itemScheduleLine.OrderID = header.OrderID `+where+`
+		ORDER BY header.OrderID ;`, args...,
+	)
+	if err != nil {
+		log.Error("%+v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	data, err := dpfm_api_output_formatter.ConvertToOrderID(rows)
+	if err != nil {
+		log.Error("%+v", err)
+		return nil
+	}
+
+	return data
+}
+
+func (r *MySQLOrdersRepository) ItemsRead(
 	input *dpfm_api_input_reader.SDC,
 	log *logger.Logger,
 ) *[]dpfm_api_output_formatter.Item {
 	where := fmt.Sprintf("WHERE item.OrderID IS NOT NULL\nAND header.OrderID = %d", input.Header.OrderID)
 	where = fmt.Sprintf("%s\nAND ( header.Buyer = %d OR header.Seller = %d ) ", where, input.BusinessPartner, input.BusinessPartner)
-	rows, err := c.db.Query(
+	rows, err := r.db.Query(
 		`SELECT 
-			item.OrderID, item.OrderItem
+			item.OrderID, item.OrderItem, item.ItemDeliveryStatus, item.ItemBillingStatus, item.IsCancelled, item.IsMarkedForDeletion,
+			item.CancellationReasonCode, item.CancellationComment
 		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_item_data as item
 		INNER JOIN DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_header_data as header
 		ON header.OrderID = item.OrderID ` + where + ` ;`)
@@ -64,20 +167,23 @@ func (c *DPFMAPICaller) ItemsRead(
 	return data
 }
 
-func (c *DPFMAPICaller) ItemScheduleLineRead(
+func (r *MySQLOrdersRepository) ItemScheduleLineRead(
 	input *dpfm_api_input_reader.SDC,
 	log *logger.Logger,
 ) *[]dpfm_api_output_formatter.ItemScheduleLine {
-	where := fmt.Sprintf("WHERE schedule.OrderID IS NOT NULL\nAND header.OrderID = %d", input.Header.OrderID)
+	where := fmt.Sprintf("WHERE itemScheduleLine.OrderID IS NOT NULL\nAND header.OrderID = %d", input.Header.OrderID)
 	where = fmt.Sprintf("%s\nAND ( header.Buyer = %d OR header.Seller = %d ) ", where, input.BusinessPartner, input.BusinessPartner)
-	rows, err := c.db.Query(
+	rows, err := r.db.Query(
 		`SELECT 
 			itemScheduleLine.OrderID, itemScheduleLine.OrderItem, itemScheduleLine.ScheduleLine, itemScheduleLine.Product, itemScheduleLine.StockConfirmationBusinessPartner,
-			itemScheduleLine.StockConfirmationPlant, itemScheduleLine.StockConfirmationPlantBatch, itemScheduleLine.RequestedDeliveryDate,
-			itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit,	itemScheduleLine.IsCancelled, itemScheduleLine.IsMarkedForDeletion
+			itemScheduleLine.StockConfirmationPlant, itemScheduleLine.StockConfirmationPlantBatch, itemScheduleLine.RequestedDeliveryDate, item.BaseUnit,
+			itemScheduleLine.ScheduleLineOrderQuantityInBaseUnit, itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit,	itemScheduleLine.IsCancelled, itemScheduleLine.IsMarkedForDeletion,
+			itemScheduleLine.CancellationReasonCode, itemScheduleLine.CancellationComment, itemScheduleLine.CancelledQuantityInBaseUnit
 		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_item_schedule_line_data as itemScheduleLine
 		INNER JOIN DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_header_data as header
-		ON header.OrderID = itemScheduleLine.OrderID ` + where + ` ;`)
+		ON header.OrderID = itemScheduleLine.OrderID
+		LEFT JOIN DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_item_data as item
+		ON item.OrderID = itemScheduleLine.OrderID AND item.OrderItem = itemScheduleLine.OrderItem ` + where + ` ;`)
 	if err != nil {
 		log.Error("%+v", err)
 		return nil
@@ -93,7 +199,7 @@ func (c *DPFMAPICaller) ItemScheduleLineRead(
 	return data
 }
 
-func (c *DPFMAPICaller) ProductStockAvailabilityRead(
+func (r *MySQLStockRepository) ProductStockAvailabilityRead(
 	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
 	log *logger.Logger,
 ) *dpfm_api_output_formatter.ProductStock {
@@ -101,10 +207,10 @@ func (c *DPFMAPICaller) ProductStockAvailabilityRead(
 
 	args = append(args, itemScheduleLine.Product, itemScheduleLine.StockConfirmationBusinessPartner, itemScheduleLine.StockConfirmationPlant, itemScheduleLine.RequestedDeliveryDate)
 
-	rows, err := c.db.Query(
+	rows, err := r.db.Query(
 		`SELECT Product, BusinessPartner, Plant, ProductStockAvailabilityDate, AvailableProductStock
 		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_product_stock_product_stock_availability_data
-		WHERE (Product, BusinessPartner, Plant , ProductStockAvailabilityDate) = (?, ?, ?, ?);`, args...,
+		WHERE (Product, BusinessPartner, Plant , ProductStockAvailabilityDate) = (?, ?, ?, ?)`+r.lockClause()+`;`, args...,
 	)
 	if err != nil {
 		log.Error("%+v", err)
@@ -121,7 +227,7 @@ func (c *DPFMAPICaller) ProductStockAvailabilityRead(
 	return data
 }
 
-func (c *DPFMAPICaller) ProductStockAvailabilityByBatchRead(
+func (r *MySQLStockRepository) ProductStockAvailabilityByBatchRead(
 	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
 	log *logger.Logger,
 ) *dpfm_api_output_formatter.ProductStock {
@@ -129,10 +235,10 @@ func (c *DPFMAPICaller) ProductStockAvailabilityByBatchRead(
 
 	args = append(args, itemScheduleLine.Product, itemScheduleLine.StockConfirmationBusinessPartner, itemScheduleLine.StockConfirmationPlant, *itemScheduleLine.StockConfirmationPlantBatch, itemScheduleLine.RequestedDeliveryDate)
 
-	rows, err := c.db.Query(
+	rows, err := r.db.Query(
 		`SELECT Product, BusinessPartner, Plant, Batch, ProductStockAvailabilityDate, AvailableProductStock
 		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_product_stock_product_stock_avail_by_btch
-		WHERE (Product, BusinessPartner, Plant, Batch, ProductStockAvailabilityDate) = (?, ?, ?, ?, ?);`, args...,
+		WHERE (Product, BusinessPartner, Plant, Batch, ProductStockAvailabilityDate) = (?, ?, ?, ?, ?)`+r.lockClause()+`;`, args...,
 	)
 	if err != nil {
 		log.Error("%+v", err)
@@ -148,3 +254,57 @@ func (c *DPFMAPICaller) ProductStockAvailabilityByBatchRead(
 
 	return data
 }
+
+func (r *MySQLStockRepository) ProductStockCandidatesRead(
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	availabilityDateTo *string,
+	otherBatches bool,
+	log *logger.Logger,
+) *[]dpfm_api_output_formatter.ProductStock {
+	if itemScheduleLine.RequestedDeliveryDate == nil {
+		return &[]dpfm_api_output_formatter.ProductStock{}
+	}
+	args := make([]interface{}, 0)
+	where := "WHERE (Product, BusinessPartner, Plant) = (?, ?, ?)\nAND ProductStockAvailabilityDate >= ?"
+	args = append(args, itemScheduleLine.Product, itemScheduleLine.StockConfirmationBusinessPartner, itemScheduleLine.StockConfirmationPlant, *itemScheduleLine.RequestedDeliveryDate)
+	if availabilityDateTo != nil {
+		where = fmt.Sprintf("%s\nAND ProductStockAvailabilityDate <= ?", where)
+		args = append(args, *availabilityDateTo)
+	}
+
+	query := `SELECT Product, BusinessPartner, Plant, '' AS Batch, ProductStockAvailabilityDate, AvailableProductStock
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_product_stock_product_stock_availability_data
+		` + where + `
+		ORDER BY ProductStockAvailabilityDate`
+	if itemScheduleLine.StockConfirmationPlantBatch != nil {
+		if !otherBatches {
+			where = fmt.Sprintf("%s\nAND Batch = ?", where)
+			args = append(args, *itemScheduleLine.StockConfirmationPlantBatch)
+		}
+		query = `SELECT Product, BusinessPartner, Plant, Batch, ProductStockAvailabilityDate, AvailableProductStock
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_product_stock_product_stock_avail_by_btch
+		` + where + `
+		ORDER BY ProductStockAvailabilityDate, Batch`
+	}
+	rows, err := r.db.Query(query+r.lockClause()+`;`, args...)
+	if err != nil {
+		log.Error("%+v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	data, err := dpfm_api_output_formatter.ConvertToProductStocks(rows)
+	if err != nil {
+		log.Error("%+v", err)
+		return nil
+	}
+
+	return data
+}
+
+func (r *MySQLStockRepository) lockClause() string {
+	if r.forUpdate {
+		return " FOR UPDATE"
+	}
+	return ""
+}
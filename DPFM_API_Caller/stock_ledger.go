@@ -0,0 +1,111 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"time"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"github.com/shopspring/decimal"
+	"golang.org/x/xerrors"
+)
+
+// appendStockMovement は、スケジュール行の在庫確認先の在庫を data に更新したことを記録します
+// トランザクションで更新する場合は、在庫の更新と同じトランザクションで記録する
+func (c *DPFMAPICaller) appendStockMovement(
+	s *saga,
+	movementType string,
+	itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine,
+	data dpfm_api_output_formatter.ProductStock,
+	delta decimal.Decimal,
+) error {
+	// 在庫が増減しない更新は記録しない
+	if delta.IsZero() {
+		return nil
+	}
+	movement := StockMovement{
+		MovementType:                 movementType,
+		Product:                      data.Product,
+		BusinessPartner:              data.BusinessPartner,
+		Plant:                        data.Plant,
+		ProductStockAvailabilityDate: data.ProductStockAvailabilityDate,
+		Quantity:                     delta,
+		QuantityBefore:               data.AvailableProductStock.Sub(delta),
+		QuantityAfter:                data.AvailableProductStock.Decimal,
+		OrderID:                      itemScheduleLine.OrderID,
+		OrderItem:                    itemScheduleLine.OrderItem,
+		ScheduleLine:                 itemScheduleLine.ScheduleLine,
+		RuntimeSessionID:             s.sessionID,
+		CreationDateTime:             time.Now(),
+	}
+	if itemScheduleLine.StockConfirmationPlantBatch != nil {
+		movement.Batch = &data.Batch
+	}
+
+	appendStockMovements := c.stockLedger.Append
+	if s.tx != nil {
+		appendStockMovements = s.tx.AppendStockMovements
+	}
+	if err := appendStockMovements([]StockMovement{movement}); err != nil {
+		return xerrors.Errorf("stock movement of order %d item %d schedule line %d cannot be recorded: %w", movement.OrderID, movement.OrderItem, movement.ScheduleLine, err)
+	}
+	return nil
+}
+
+// stockMovements は、Orders の OrderID のオーダー、または ProductStock の在庫の増減を返します
+// オーダーは買い手・売り手のみ、在庫は在庫のビジネスパートナのみが参照できる
+func (c *DPFMAPICaller) stockMovements(
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) (*[]dpfm_api_output_formatter.StockMovement, error) {
+	query := StockMovementQuery{}
+	if input.Header.OrderID != 0 {
+		headerPartner := c.orders.HeaderPartnerRead(input, log)
+		if headerPartner == nil {
+			return nil, xerrors.Errorf("order %d: %w", input.Header.OrderID, errNotFound)
+		}
+		if headerPartner.Buyer != input.BusinessPartner && headerPartner.Seller != input.BusinessPartner {
+			return nil, xerrors.Errorf("business partner %d is neither buyer nor seller of order %d", input.BusinessPartner, input.Header.OrderID)
+		}
+		query.OrderID = &input.Header.OrderID
+	} else {
+		productStock := input.ProductStock
+		if productStock.Product == nil || productStock.BusinessPartner == nil || productStock.Plant == nil {
+			return nil, xerrors.New("OrderID or Product, BusinessPartner and Plant of ProductStock is required")
+		}
+		if *productStock.BusinessPartner != input.BusinessPartner {
+			return nil, xerrors.Errorf("business partner %d is not permitted to read product stock of business partner %d", input.BusinessPartner, *productStock.BusinessPartner)
+		}
+		query.Product = productStock.Product
+		query.BusinessPartner = productStock.BusinessPartner
+		query.Plant = productStock.Plant
+		query.Batch = productStock.Batch
+		query.ProductStockAvailabilityDate = productStock.ProductStockAvailabilityDate
+	}
+
+	movements, err := c.stockLedger.List(query)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dpfm_api_output_formatter.StockMovement, 0, len(movements))
+	for _, m := range movements {
+		res = append(res, dpfm_api_output_formatter.StockMovement{
+			StockMovementID:              m.StockMovementID,
+			MovementType:                 m.MovementType,
+			Product:                      m.Product,
+			BusinessPartner:              m.BusinessPartner,
+			Plant:                        m.Plant,
+			Batch:                        m.Batch,
+			ProductStockAvailabilityDate: m.ProductStockAvailabilityDate,
+			Quantity:                     dpfm_api_output_formatter.NewQuantity(m.Quantity),
+			QuantityBefore:               dpfm_api_output_formatter.NewQuantity(m.QuantityBefore),
+			QuantityAfter:                dpfm_api_output_formatter.NewQuantity(m.QuantityAfter),
+			OrderID:                      m.OrderID,
+			OrderItem:                    m.OrderItem,
+			ScheduleLine:                 m.ScheduleLine,
+			RuntimeSessionID:             m.RuntimeSessionID,
+			CreationDateTime:             m.CreationDateTime.Format(time.RFC3339),
+		})
+	}
+	return &res, nil
+}
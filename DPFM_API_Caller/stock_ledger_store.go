@@ -0,0 +1,229 @@
+package dpfm_api_caller
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	database "github.com/latonaio/golang-mysql-network-connector"
+	"github.com/shopspring/decimal"
+	"golang.org/x/xerrors"
+)
+
+const (
+	StockMovementRelease      = "Release"
+	StockMovementReservation  = "Reservation"
+	StockMovementCompensation = "Compensation"
+)
+
+// StockLedgerStore は、在庫の更新ごとの増減を記録します
+// 在庫は増減後の値で上書きされるため、どのオーダーの処理でいくつ増減したかはこの記録からのみ分かる
+type StockLedgerStore interface {
+	Append(movements []StockMovement) error
+	// List は、query に一致する在庫の増減を記録した順に返します
+	List(query StockMovementQuery) ([]StockMovement, error)
+}
+
+// StockMovement は、1 回の在庫の更新です。Quantity は増減分で、在庫確認先がロットでない場合は Batch が nil です
+type StockMovement struct {
+	StockMovementID              int
+	MovementType                 string
+	Product                      string
+	BusinessPartner              int
+	Plant                        string
+	Batch                        *string
+	ProductStockAvailabilityDate string
+	Quantity                     decimal.Decimal
+	QuantityBefore               decimal.Decimal
+	QuantityAfter                decimal.Decimal
+	OrderID                      int
+	OrderItem                    int
+	ScheduleLine                 int
+	RuntimeSessionID             string
+	CreationDateTime             time.Time
+}
+
+// StockMovementQuery は、OrderID か在庫のキー（Product・BusinessPartner・Plant）のいずれかを指定します
+// Batch・ProductStockAvailabilityDate は、指定した場合のみ条件に含めます
+type StockMovementQuery struct {
+	OrderID                      *int
+	Product                      *string
+	BusinessPartner              *int
+	Plant                        *string
+	Batch                        *string
+	ProductStockAvailabilityDate *string
+}
+
+type MySQLStockLedgerStore struct {
+	db *database.Mysql
+}
+
+func NewMySQLStockLedgerStore(db *database.Mysql) (*MySQLStockLedgerStore, error) {
+	_, err := db.Exec(
+		`CREATE TABLE IF NOT EXISTS DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_stock_movement_data (
+			StockMovementID int(16) NOT NULL AUTO_INCREMENT,
+			MovementType varchar(20) NOT NULL,
+			Product varchar(40) NOT NULL,
+			BusinessPartner int(12) NOT NULL,
+			Plant varchar(4) NOT NULL,
+			Batch varchar(10) DEFAULT NULL,
+			ProductStockAvailabilityDate varchar(10) NOT NULL,
+			Quantity decimal(20, 6) NOT NULL,
+			QuantityBefore decimal(20, 6) NOT NULL,
+			QuantityAfter decimal(20, 6) NOT NULL,
+			OrderID int(16) NOT NULL,
+			OrderItem int(6) NOT NULL,
+			ScheduleLine int(3) NOT NULL,
+			RuntimeSessionID varchar(100) NOT NULL,
+			CreationDateTime datetime NOT NULL,
+			PRIMARY KEY (StockMovementID),
+			KEY (OrderID, StockMovementID),
+			KEY (Product, BusinessPartner, Plant, StockMovementID)
+		);`,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("stock movement table create error: %w", err)
+	}
+	return &MySQLStockLedgerStore{db: db}, nil
+}
+
+func (s *MySQLStockLedgerStore) Append(movements []StockMovement) error {
+	return insertStockMovements(s.db, movements)
+}
+
+func insertStockMovements(db execer, movements []StockMovement) error {
+	for _, m := range movements {
+		_, err := db.Exec(
+			`INSERT INTO DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_stock_movement_data
+			(MovementType, Product, BusinessPartner, Plant, Batch, ProductStockAvailabilityDate, Quantity, QuantityBefore, QuantityAfter,
+			OrderID, OrderItem, ScheduleLine, RuntimeSessionID, CreationDateTime)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+			m.MovementType, m.Product, m.BusinessPartner, m.Plant, m.Batch, m.ProductStockAvailabilityDate, m.Quantity, m.QuantityBefore, m.QuantityAfter,
+			m.OrderID, m.OrderItem, m.ScheduleLine, m.RuntimeSessionID, m.CreationDateTime.UTC().Format(scheduledDateTimeLayout),
+		)
+		if err != nil {
+			return xerrors.Errorf("stock movement write error: %v: %w", err, errSQL)
+		}
+	}
+	return nil
+}
+
+func (s *MySQLStockLedgerStore) List(query StockMovementQuery) ([]StockMovement, error) {
+	return selectStockMovements(s.db, query)
+}
+
+func selectStockMovements(db queryer, query StockMovementQuery) ([]StockMovement, error) {
+	where := `WHERE 1 = 1`
+	args := make([]interface{}, 0)
+	add := func(column string, value interface{}) {
+		where += ` AND ` + column + ` = ?`
+		args = append(args, value)
+	}
+	if query.OrderID != nil {
+		add("OrderID", *query.OrderID)
+	}
+	if query.Product != nil {
+		add("Product", *query.Product)
+	}
+	if query.BusinessPartner != nil {
+		add("BusinessPartner", *query.BusinessPartner)
+	}
+	if query.Plant != nil {
+		add("Plant", *query.Plant)
+	}
+	if query.Batch != nil {
+		add("Batch", *query.Batch)
+	}
+	if query.ProductStockAvailabilityDate != nil {
+		add("ProductStockAvailabilityDate", *query.ProductStockAvailabilityDate)
+	}
+	rows, err := db.Query(
+		`SELECT StockMovementID, MovementType, Product, BusinessPartner, Plant, Batch, ProductStockAvailabilityDate,
+		Quantity, QuantityBefore, QuantityAfter, OrderID, OrderItem, ScheduleLine, RuntimeSessionID, CreationDateTime
+		FROM DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_cancels_stock_movement_data
+		`+where+` ORDER BY StockMovementID;`, args...,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("stock movement read error: %w", err)
+	}
+	return scanStockMovements(rows)
+}
+
+func scanStockMovements(rows *sql.Rows) ([]StockMovement, error) {
+	defer rows.Close()
+	movements := make([]StockMovement, 0)
+	for rows.Next() {
+		var (
+			m                StockMovement
+			creationDateTime string
+		)
+		err := rows.Scan(
+			&m.StockMovementID,
+			&m.MovementType,
+			&m.Product,
+			&m.BusinessPartner,
+			&m.Plant,
+			&m.Batch,
+			&m.ProductStockAvailabilityDate,
+			&m.Quantity,
+			&m.QuantityBefore,
+			&m.QuantityAfter,
+			&m.OrderID,
+			&m.OrderItem,
+			&m.ScheduleLine,
+			&m.RuntimeSessionID,
+			&creationDateTime,
+		)
+		if err != nil {
+			return nil, xerrors.Errorf("stock movement scan error: %w", err)
+		}
+		if m.CreationDateTime, err = time.ParseInLocation(scheduledDateTimeLayout, creationDateTime, time.UTC); err != nil {
+			return nil, xerrors.Errorf("stock movement scan error: %w", err)
+		}
+		movements = append(movements, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("stock movement scan error: %w", err)
+	}
+	return movements, nil
+}
+
+type MemoryStockLedgerStore struct {
+	mtx       sync.Mutex
+	movements []StockMovement
+}
+
+func NewMemoryStockLedgerStore() *MemoryStockLedgerStore {
+	return &MemoryStockLedgerStore{
+		movements: make([]StockMovement, 0),
+	}
+}
+
+func (s *MemoryStockLedgerStore) Append(movements []StockMovement) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, m := range movements {
+		m.StockMovementID = len(s.movements) + 1
+		m.CreationDateTime = m.CreationDateTime.UTC().Truncate(time.Second)
+		s.movements = append(s.movements, m)
+	}
+	return nil
+}
+
+func (s *MemoryStockLedgerStore) List(query StockMovementQuery) ([]StockMovement, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	movements := make([]StockMovement, 0)
+	for _, m := range s.movements {
+		if query.OrderID != nil && m.OrderID != *query.OrderID ||
+			query.Product != nil && m.Product != *query.Product ||
+			query.BusinessPartner != nil && m.BusinessPartner != *query.BusinessPartner ||
+			query.Plant != nil && m.Plant != *query.Plant ||
+			query.Batch != nil && (m.Batch == nil || *m.Batch != *query.Batch) ||
+			query.ProductStockAvailabilityDate != nil && m.ProductStockAvailabilityDate != *query.ProductStockAvailabilityDate {
+			continue
+		}
+		movements = append(movements, m)
+	}
+	return movements, nil
+}
@@ -0,0 +1,132 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"testing"
+)
+
+func (c *testCaller) stockMovements(t *testing.T, input *dpfm_api_input_reader.SDC) ([]dpfm_api_output_formatter.StockMovement, []error) {
+	t.Helper()
+	input.APIType = "stock-movements"
+	res, errs := c.AsyncCancels(nil, input, &dpfm_api_output_formatter.SDC{}, c.log)
+	movements, _ := res.(*[]dpfm_api_output_formatter.StockMovement)
+	if movements == nil {
+		return nil, errs
+	}
+	return *movements, errs
+}
+
+func TestStockMovementsRecordCancelAndReactivation(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+	_, errs := c.call(t, testInput("cancels", "cancel", true))
+	mustNoErrors(t, errs)
+	_, errs = c.call(t, testInput("cancels", "reactivate", false))
+	mustNoErrors(t, errs)
+
+	movements, errs := c.stockMovements(t, &dpfm_api_input_reader.SDC{
+		BusinessPartner: testBuyer,
+		Header:          dpfm_api_input_reader.Header{OrderID: testOrderID},
+	})
+	mustNoErrors(t, errs)
+	if len(movements) != 2 {
+		t.Fatalf("stock movements = %d, want 2", len(movements))
+	}
+	tests := []struct {
+		movementType  string
+		sessionID     string
+		quantity      int64
+		before, after int64
+	}{
+		{StockMovementRelease, "cancel", 10, 100, 110},
+		{StockMovementReservation, "reactivate", -10, 110, 100},
+	}
+	for i, tt := range tests {
+		m := movements[i]
+		if m.MovementType != tt.movementType || m.RuntimeSessionID != tt.sessionID {
+			t.Errorf("movement %d = %s by %s, want %s by %s", i, m.MovementType, m.RuntimeSessionID, tt.movementType, tt.sessionID)
+		}
+		if m.Product != testProduct || m.BusinessPartner != testSeller || m.Plant != testPlant || m.ProductStockAvailabilityDate != testDate {
+			t.Errorf("movement %d stock = %s/%d/%s/%s", i, m.Product, m.BusinessPartner, m.Plant, m.ProductStockAvailabilityDate)
+		}
+		if m.OrderID != testOrderID || m.OrderItem != 1 || m.ScheduleLine != 1 {
+			t.Errorf("movement %d schedule line = %d-%d-%d", i, m.OrderID, m.OrderItem, m.ScheduleLine)
+		}
+		assertQuantity(t, "quantity", m.Quantity.Decimal, tt.quantity)
+		assertQuantity(t, "quantity before", m.QuantityBefore.Decimal, tt.before)
+		assertQuantity(t, "quantity after", m.QuantityAfter.Decimal, tt.after)
+	}
+}
+
+func TestStockMovementsQuery(t *testing.T) {
+	c := newTestCaller(t, testStock(testDate, 100))
+	_, errs := c.call(t, testInput("cancels", "cancel", true))
+	mustNoErrors(t, errs)
+
+	productStock := func(businessPartner int, date string) dpfm_api_input_reader.ProductStock {
+		return dpfm_api_input_reader.ProductStock{
+			Product:                      getStringPtr(testProduct),
+			BusinessPartner:              &businessPartner,
+			Plant:                        getStringPtr(testPlant),
+			ProductStockAvailabilityDate: getStringPtr(date),
+		}
+	}
+	tests := []struct {
+		name    string
+		input   dpfm_api_input_reader.SDC
+		want    int
+		wantErr bool
+	}{
+		{
+			name:  "order by seller",
+			input: dpfm_api_input_reader.SDC{BusinessPartner: testSeller, Header: dpfm_api_input_reader.Header{OrderID: testOrderID}},
+			want:  1,
+		},
+		{
+			name:    "order by other partner",
+			input:   dpfm_api_input_reader.SDC{BusinessPartner: 999, Header: dpfm_api_input_reader.Header{OrderID: testOrderID}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown order",
+			input:   dpfm_api_input_reader.SDC{BusinessPartner: testSeller, Header: dpfm_api_input_reader.Header{OrderID: testOrderID + 1}},
+			wantErr: true,
+		},
+		{
+			name:  "product stock",
+			input: dpfm_api_input_reader.SDC{BusinessPartner: testSeller, ProductStock: productStock(testSeller, testDate)},
+			want:  1,
+		},
+		{
+			name:  "product stock of other date",
+			input: dpfm_api_input_reader.SDC{BusinessPartner: testSeller, ProductStock: productStock(testSeller, "2022-10-02")},
+			want:  0,
+		},
+		{
+			name:    "product stock of other partner",
+			input:   dpfm_api_input_reader.SDC{BusinessPartner: testBuyer, ProductStock: productStock(testSeller, testDate)},
+			wantErr: true,
+		},
+		{
+			name:    "product stock without plant",
+			input:   dpfm_api_input_reader.SDC{BusinessPartner: testSeller, ProductStock: dpfm_api_input_reader.ProductStock{Product: getStringPtr(testProduct)}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.input
+			movements, errs := c.stockMovements(t, &input)
+			if tt.wantErr {
+				if len(errs) == 0 {
+					t.Fatal("query is not rejected")
+				}
+				return
+			}
+			mustNoErrors(t, errs)
+			if len(movements) != tt.want {
+				t.Errorf("stock movements = %d, want %d", len(movements), tt.want)
+			}
+		})
+	}
+}
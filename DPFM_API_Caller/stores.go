@@ -0,0 +1,74 @@
+package dpfm_api_caller
+
+import (
+	database "github.com/latonaio/golang-mysql-network-connector"
+)
+
+// Stores は、キャンセル処理が読み書きするデータの保持先です
+type Stores struct {
+	Orders               OrdersRepository
+	Stocks               StockRepository
+	Writer               SQLWriter
+	Idempotency          IdempotencyStore
+	Scheduled            ScheduledCancellationStore
+	CancellationRequests CancellationRequestStore
+	MassCancellations    MassCancellationStore
+	Outbox               OutboxStore
+	StockLedger          StockLedgerStore
+	// Transactor がある場合は、1 回のキャンセルの読み込み・更新を 1 つのトランザクションで行います
+	Transactor Transactor
+}
+
+// NewMySQLStores は、MySQL から読み込み、sql-update-kube に更新を依頼する保持先を作成します
+func NewMySQLStores(db *database.Mysql, rmq RMQClient, queueToSQL string) (*Stores, error) {
+	idempotency, err := NewMySQLIdempotencyStore(db)
+	if err != nil {
+		return nil, err
+	}
+	scheduled, err := NewMySQLScheduledCancellationStore(db)
+	if err != nil {
+		return nil, err
+	}
+	cancellationRequests, err := NewMySQLCancellationRequestStore(db)
+	if err != nil {
+		return nil, err
+	}
+	massCancellations, err := NewMySQLMassCancellationStore(db)
+	if err != nil {
+		return nil, err
+	}
+	outbox, err := NewMySQLOutboxStore(db)
+	if err != nil {
+		return nil, err
+	}
+	stockLedger, err := NewMySQLStockLedgerStore(db)
+	if err != nil {
+		return nil, err
+	}
+	return &Stores{
+		Orders:               NewMySQLOrdersRepository(db),
+		Stocks:               NewMySQLStockRepository(db),
+		Writer:               NewRMQSQLWriter(rmq, queueToSQL),
+		Idempotency:          idempotency,
+		Scheduled:            scheduled,
+		CancellationRequests: cancellationRequests,
+		MassCancellations:    massCancellations,
+		Outbox:               outbox,
+		StockLedger:          stockLedger,
+	}, nil
+}
+
+// NewMemoryStores は、MySQL・sql-update-kube を使わずにメモリ上で読み書きする保持先を作成します
+func NewMemoryStores(orders *MemoryOrdersRepository, stocks *MemoryStockRepository) *Stores {
+	return &Stores{
+		Orders:               orders,
+		Stocks:               stocks,
+		Writer:               NewMemorySQLWriter(orders, stocks),
+		Idempotency:          NewMemoryIdempotencyStore(),
+		Scheduled:            NewMemoryScheduledCancellationStore(),
+		CancellationRequests: NewMemoryCancellationRequestStore(),
+		MassCancellations:    NewMemoryMassCancellationStore(),
+		Outbox:               NewMemoryOutboxStore(),
+		StockLedger:          NewMemoryStockLedgerStore(),
+	}
+}
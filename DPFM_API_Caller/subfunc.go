@@ -0,0 +1,170 @@
+package dpfm_api_caller
+
+import (
+	"context"
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"data-platform-api-orders-cancels-rmq-kube/sub_func_complementer"
+	"encoding/json"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"golang.org/x/xerrors"
+)
+
+// subfuncProcess は、キャンセル対象として指定されていない明細・スケジュール行をサブファンクションで補完します
+// 明細が指定されていない場合は Headers にオーダーの全明細を、スケジュール行が指定されていない明細は Items に全スケジュール行を問い合わせ、
+// キャンセル・キャンセル取消の指定は上位の指定を引き継ぐ
+// プレビューではサブファンクションに問い合わせず、saga のリポジトリから明細・スケジュール行を読み込んで補完する
+func (c *DPFMAPICaller) subfuncProcess(
+	s *saga,
+	input *dpfm_api_input_reader.SDC,
+	output *dpfm_api_output_formatter.SDC,
+	accepter []string,
+	log *logger.Logger,
+) error {
+	queues := c.conf.RMQ.QueueToSubFunc()
+	called := false
+	subfuncRequest := c.subfuncRequest
+	if s.dryRun {
+		subfuncRequest = func(queue string, input *dpfm_api_input_reader.SDC) (*sub_func_complementer.SDC, error) {
+			return dryRunSubfuncRequest(s, input, log)
+		}
+	}
+
+	if contains(accepter, "Item") && len(input.Header.Item) == 0 && queues["Headers"] != "" {
+		called = true
+		res, err := subfuncRequest(queues["Headers"], input)
+		if err != nil {
+			output.SubfuncResult = getBoolPtr(false)
+			output.SubfuncError = err.Error()
+			return err
+		}
+		complementItems(input, res)
+	}
+
+	if contains(accepter, "ItemScheduleLine") && queues["Items"] != "" {
+		for i := range input.Header.Item {
+			if len(input.Header.Item[i].ItemScheduleLine) != 0 {
+				continue
+			}
+			called = true
+			itemInput := *input
+			itemInput.Header.Item = []dpfm_api_input_reader.Item{input.Header.Item[i]}
+			res, err := subfuncRequest(queues["Items"], &itemInput)
+			if err != nil {
+				output.SubfuncResult = getBoolPtr(false)
+				output.SubfuncError = err.Error()
+				return err
+			}
+			complementItemScheduleLines(&input.Header.Item[i], res)
+		}
+	}
+
+	if called {
+		output.SubfuncResult = getBoolPtr(true)
+		log.Info("order %d: %d items are complemented by sub function", input.Header.OrderID, len(input.Header.Item))
+	}
+	return nil
+}
+
+func (c *DPFMAPICaller) subfuncRequest(
+	queue string,
+	input *dpfm_api_input_reader.SDC,
+) (*sub_func_complementer.SDC, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.conf.RMQ.SubfuncTimeout())
+	defer cancel()
+	res, err := c.rmq.SessionKeepRequest(ctx, queue, input)
+	if err != nil {
+		return nil, xerrors.Errorf("%s: %v: %w", queue, err, errRMQ)
+	}
+	res.Success()
+
+	raw, err := json.Marshal(res.Data())
+	if err != nil {
+		return nil, xerrors.Errorf("%s response marshal error: %w", queue, err)
+	}
+	sdc := sub_func_complementer.SDC{}
+	if err := json.Unmarshal(raw, &sdc); err != nil {
+		return nil, xerrors.Errorf("%s response unmarshal error: %w", queue, err)
+	}
+	if sdc.SubfuncResult != nil && !*sdc.SubfuncResult {
+		return nil, xerrors.Errorf("%s: %s", queue, sdc.SubfuncError)
+	}
+	return &sdc, nil
+}
+
+// dryRunSubfuncRequest は、サブファンクションの応答と同じ明細・スケジュール行を saga のリポジトリから読み込みます
+// input に明細が指定されている場合は、その明細のスケジュール行のみを返す
+func dryRunSubfuncRequest(
+	s *saga,
+	input *dpfm_api_input_reader.SDC,
+	log *logger.Logger,
+) (*sub_func_complementer.SDC, error) {
+	items := s.orders.ItemsRead(input, log)
+	itemScheduleLines := s.orders.ItemScheduleLineRead(input, log)
+	if items == nil || itemScheduleLines == nil {
+		return nil, xerrors.Errorf("order %d read error: %w", input.Header.OrderID, errSQL)
+	}
+	orderItems := make(map[int]struct{}, len(input.Header.Item))
+	for _, item := range input.Header.Item {
+		orderItems[item.OrderItem] = struct{}{}
+	}
+
+	res := &sub_func_complementer.SDC{
+		Message: sub_func_complementer.Message{
+			Header:           &sub_func_complementer.Header{OrderID: input.Header.OrderID},
+			Item:             &[]sub_func_complementer.Item{},
+			ItemScheduleLine: &[]sub_func_complementer.ItemScheduleLine{},
+		},
+	}
+	for _, item := range *items {
+		if _, ok := orderItems[item.OrderItem]; len(orderItems) != 0 && !ok {
+			continue
+		}
+		*res.Message.Item = append(*res.Message.Item, sub_func_complementer.Item{OrderID: item.OrderID, OrderItem: item.OrderItem})
+	}
+	for _, itemScheduleLine := range *itemScheduleLines {
+		if _, ok := orderItems[itemScheduleLine.OrderItem]; len(orderItems) != 0 && !ok {
+			continue
+		}
+		*res.Message.ItemScheduleLine = append(*res.Message.ItemScheduleLine, sub_func_complementer.ItemScheduleLine{
+			OrderID:      itemScheduleLine.OrderID,
+			OrderItem:    itemScheduleLine.OrderItem,
+			ScheduleLine: itemScheduleLine.ScheduleLine,
+		})
+	}
+	return res, nil
+}
+
+func complementItems(input *dpfm_api_input_reader.SDC, res *sub_func_complementer.SDC) {
+	if res.Message.Item == nil {
+		return
+	}
+	for _, item := range *res.Message.Item {
+		input.Header.Item = append(input.Header.Item, dpfm_api_input_reader.Item{
+			OrderID:     input.Header.OrderID,
+			OrderItem:   item.OrderItem,
+			IsCancelled: input.Header.IsCancelled,
+		})
+	}
+	for i := range input.Header.Item {
+		complementItemScheduleLines(&input.Header.Item[i], res)
+	}
+}
+
+func complementItemScheduleLines(item *dpfm_api_input_reader.Item, res *sub_func_complementer.SDC) {
+	if res.Message.ItemScheduleLine == nil || len(item.ItemScheduleLine) != 0 {
+		return
+	}
+	for _, itemScheduleLine := range *res.Message.ItemScheduleLine {
+		if itemScheduleLine.OrderItem != item.OrderItem {
+			continue
+		}
+		item.ItemScheduleLine = append(item.ItemScheduleLine, dpfm_api_input_reader.ItemScheduleLine{
+			OrderID:      item.OrderID,
+			OrderItem:    item.OrderItem,
+			ScheduleLine: itemScheduleLine.ScheduleLine,
+			IsCancelled:  item.IsCancelled,
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"data-platform-api-orders-cancels-rmq-kube/sub_func_complementer"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+// subfuncRequests は、存在性チェックを除いたサブファンクションへの問い合わせです
+func subfuncRequests(rmq *testRMQ) []string {
+	requests := make([]string, 0)
+	for _, queue := range rmq.requests {
+		if queue != "exconf" {
+			requests = append(requests, queue)
+		}
+	}
+	return requests
+}
+
+func TestSubfuncComplementsItemsFromHeaders(t *testing.T) {
+	c, rmq := newTestCallerWithRMQ(t, testStock(testDate, 100))
+
+	output := &dpfm_api_output_formatter.SDC{}
+	input := headerCancelInput("cancels", "cancel")
+	_, errs := c.AsyncCancels(input.Accepter, input, output, c.log)
+	mustNoErrors(t, errs)
+	// Headers の応答にスケジュール行が含まれるため、Items には問い合わせない
+	if got := subfuncRequests(rmq); len(got) != 1 || got[0] != "headers-sub-func" {
+		t.Errorf("sub function requests = %v, want headers-sub-func only", got)
+	}
+	if output.SubfuncResult == nil || !*output.SubfuncResult {
+		t.Errorf("SubfuncResult = %v, want true", output.SubfuncResult)
+	}
+	if !isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Error("complemented schedule line is not cancelled")
+	}
+	assertQuantity(t, "stock after cancel", c.stock(t, testDate), 110)
+}
+
+func TestSubfuncComplementsItemScheduleLinesFromItems(t *testing.T) {
+	c, rmq := newTestCallerWithRMQ(t, testStock(testDate, 100))
+
+	input := testInput("cancels", "cancel", true)
+	input.Accepter = []string{"Item", "ItemScheduleLine"}
+	_, errs := c.call(t, input)
+	mustNoErrors(t, errs)
+	if got := subfuncRequests(rmq); len(got) != 1 || got[0] != "items-sub-func" {
+		t.Errorf("sub function requests = %v, want items-sub-func only", got)
+	}
+	if !isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Error("complemented schedule line is not cancelled")
+	}
+}
+
+func TestSubfuncFailureStopsCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(rmq *testRMQ)
+		wantErr error
+	}{
+		{
+			name: "subfunc_result false",
+			setup: func(rmq *testRMQ) {
+				rmq.respond["headers-sub-func"] = func(payload interface{}) (map[string]interface{}, error) {
+					return map[string]interface{}{"subfunc_result": false, "subfunc_error": "order is not found"}, nil
+				}
+			},
+		},
+		{
+			name: "timeout",
+			setup: func(rmq *testRMQ) {
+				rmq.hang["headers-sub-func"] = true
+			},
+			wantErr: errRMQ,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SUBFUNC_TIMEOUT_SECONDS", "1")
+			c, rmq := newTestCallerWithRMQ(t, testStock(testDate, 100))
+			tt.setup(rmq)
+
+			output := &dpfm_api_output_formatter.SDC{}
+			input := headerCancelInput("cancels", "cancel")
+			_, errs := c.AsyncCancels(input.Accepter, input, output, c.log)
+			if len(errs) == 0 {
+				t.Fatal("cancel succeeded despite the sub function failure")
+			}
+			if tt.wantErr != nil && !xerrors.Is(errs[0], tt.wantErr) {
+				t.Errorf("error = %v, want %v", errs[0], tt.wantErr)
+			}
+			if output.SubfuncResult == nil || *output.SubfuncResult || output.SubfuncError == "" {
+				t.Errorf("SubfuncResult = %v, SubfuncError = %q, want the failure", output.SubfuncResult, output.SubfuncError)
+			}
+			if isTrue(c.itemScheduleLine(t).IsCancelled) {
+				t.Error("schedule line is cancelled")
+			}
+			assertQuantity(t, "stock after failed sub function", c.stock(t, testDate), 100)
+		})
+	}
+}
+
+func TestComplementItemsInheritsIsCancelled(t *testing.T) {
+	input := &dpfm_api_input_reader.SDC{Header: dpfm_api_input_reader.Header{OrderID: testOrderID, IsCancelled: getBoolPtr(false)}}
+	complementItems(input, &sub_func_complementer.SDC{
+		Message: sub_func_complementer.Message{
+			Item: &[]sub_func_complementer.Item{{OrderID: testOrderID, OrderItem: 1}, {OrderID: testOrderID, OrderItem: 2}},
+			ItemScheduleLine: &[]sub_func_complementer.ItemScheduleLine{
+				{OrderID: testOrderID, OrderItem: 1, ScheduleLine: 1},
+				{OrderID: testOrderID, OrderItem: 1, ScheduleLine: 2},
+				{OrderID: testOrderID, OrderItem: 2, ScheduleLine: 1},
+			},
+		},
+	})
+
+	if len(input.Header.Item) != 2 {
+		t.Fatalf("items = %+v, want 2 items", input.Header.Item)
+	}
+	for _, item := range input.Header.Item {
+		if item.IsCancelled == nil || *item.IsCancelled {
+			t.Errorf("item %d IsCancelled = %v, want false of the order", item.OrderItem, item.IsCancelled)
+		}
+		want := map[int]int{1: 2, 2: 1}[item.OrderItem]
+		if len(item.ItemScheduleLine) != want {
+			t.Errorf("item %d has %d schedule lines, want %d", item.OrderItem, len(item.ItemScheduleLine), want)
+		}
+		for _, itemScheduleLine := range item.ItemScheduleLine {
+			if itemScheduleLine.OrderItem != item.OrderItem || itemScheduleLine.IsCancelled == nil || *itemScheduleLine.IsCancelled {
+				t.Errorf("schedule line %+v is not complemented from item %d", itemScheduleLine, item.OrderItem)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"database/sql"
+
+	database "github.com/latonaio/golang-mysql-network-connector"
+	"golang.org/x/xerrors"
+)
+
+// Transactor は、1 回のキャンセルの読み込み・更新を行うトランザクションを開始します
+type Transactor interface {
+	Begin() (Transaction, error)
+}
+
+// Transaction は、トランザクション内で読み込み・更新を行うリポジトリと、ドメインイベント・在庫の増減・処理済みの要求の記録先です
+type Transaction interface {
+	Orders() OrdersRepository
+	// Stocks は、読み込んだ在庫をコミットまたはロールバックまでロックします
+	Stocks() StockRepository
+	Writer() SQLWriter
+	AppendEvents(events []dpfm_api_output_formatter.DomainEvent) error
+	AppendStockMovements(movements []StockMovement) error
+	// StockMovements は、トランザクション内で記録した在庫の増減を含めて、query に一致する在庫の増減を返します
+	StockMovements(query StockMovementQuery) ([]StockMovement, error)
+	SaveIdempotency(key IdempotencyKey, record *IdempotencyRecord) error
+	Commit() error
+	Rollback() error
+}
+
+// MySQLTransactor は、sql-update-kube を経由せずに MySQL を直接更新するトランザクションを開始します
+type MySQLTransactor struct {
+	db *database.Mysql
+}
+
+func NewMySQLTransactor(db *database.Mysql) *MySQLTransactor {
+	return &MySQLTransactor{db: db}
+}
+
+func (t *MySQLTransactor) Begin() (Transaction, error) {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return nil, xerrors.Errorf("cancel transaction begin error: %v: %w", err, errSQL)
+	}
+	return &mysqlTransaction{tx: tx}, nil
+}
+
+type mysqlTransaction struct {
+	tx *sql.Tx
+}
+
+func (t *mysqlTransaction) Orders() OrdersRepository {
+	return &MySQLOrdersRepository{db: t.tx}
+}
+
+func (t *mysqlTransaction) Stocks() StockRepository {
+	return &MySQLStockRepository{db: t.tx, forUpdate: true}
+}
+
+func (t *mysqlTransaction) Writer() SQLWriter {
+	return &MySQLSQLWriter{db: t.tx}
+}
+
+func (t *mysqlTransaction) AppendEvents(events []dpfm_api_output_formatter.DomainEvent) error {
+	return insertOutboxEvents(t.tx, events)
+}
+
+func (t *mysqlTransaction) AppendStockMovements(movements []StockMovement) error {
+	return insertStockMovements(t.tx, movements)
+}
+
+func (t *mysqlTransaction) StockMovements(query StockMovementQuery) ([]StockMovement, error) {
+	return selectStockMovements(t.tx, query)
+}
+
+func (t *mysqlTransaction) SaveIdempotency(key IdempotencyKey, record *IdempotencyRecord) error {
+	return insertIdempotency(t.tx, key, record)
+}
+
+func (t *mysqlTransaction) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *mysqlTransaction) Rollback() error {
+	return t.tx.Rollback()
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// MySQLSQLWriter は、MySQL を直接更新します
+// MemorySQLWriter と同様に、オーダーはキャンセル処理で変更する項目のみを更新します
+type MySQLSQLWriter struct {
+	db execer
+}
+
+func (w *MySQLSQLWriter) UpdateHeader(sessionID string, header dpfm_api_output_formatter.Header) error {
+	return w.exec(functionHeader,
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_header_data
+		SET IsCancelled = ?, CancellationReasonCode = ?, CancellationComment = ?
+		WHERE OrderID = ?;`,
+		header.IsCancelled, header.CancellationReasonCode, header.CancellationComment, header.OrderID,
+	)
+}
+
+func (w *MySQLSQLWriter) UpdateItem(sessionID string, item dpfm_api_output_formatter.Item) error {
+	return w.exec(functionItem,
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_item_data
+		SET IsCancelled = ?, CancellationReasonCode = ?, CancellationComment = ?
+		WHERE (OrderID, OrderItem) = (?, ?);`,
+		item.IsCancelled, item.CancellationReasonCode, item.CancellationComment, item.OrderID, item.OrderItem,
+	)
+}
+
+func (w *MySQLSQLWriter) UpdateScheduleLine(sessionID string, itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine) error {
+	set := `IsCancelled = ?, CancellationReasonCode = ?, CancellationComment = ?`
+	args := []interface{}{itemScheduleLine.IsCancelled, itemScheduleLine.CancellationReasonCode, itemScheduleLine.CancellationComment}
+	// 在庫確認先を含まない更新は、確定数量を変更しない
+	if itemScheduleLine.Product != "" {
+		set += `, ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit = ?`
+		args = append(args, itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit)
+	}
+	if itemScheduleLine.CancelledQuantityInBaseUnit != nil {
+		set += `, CancelledQuantityInBaseUnit = COALESCE(CancelledQuantityInBaseUnit, 0) + ?`
+		args = append(args, *itemScheduleLine.CancelledQuantityInBaseUnit)
+	}
+	args = append(args, itemScheduleLine.OrderID, itemScheduleLine.OrderItem, itemScheduleLine.ScheduleLine)
+	return w.exec(functionItemScheduleLine,
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_orders_item_schedule_line_data
+		SET `+set+`
+		WHERE (OrderID, OrderItem, ScheduleLine) = (?, ?, ?);`,
+		args...,
+	)
+}
+
+func (w *MySQLSQLWriter) UpdateStock(sessionID string, productStock dpfm_api_output_formatter.ProductStock) error {
+	return w.exec(functionProductStock,
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_product_stock_product_stock_availability_data
+		SET AvailableProductStock = ?
+		WHERE (Product, BusinessPartner, Plant, ProductStockAvailabilityDate) = (?, ?, ?, ?);`,
+		productStock.AvailableProductStock, productStock.Product, productStock.BusinessPartner, productStock.Plant, productStock.ProductStockAvailabilityDate,
+	)
+}
+
+func (w *MySQLSQLWriter) UpdateStockByBatch(sessionID string, productStock dpfm_api_output_formatter.ProductStock) error {
+	return w.exec(functionProductStockByBatch,
+		`UPDATE DataPlatformMastersAndTransactionsMysqlKube.data_platform_product_stock_product_stock_avail_by_btch
+		SET AvailableProductStock = ?
+		WHERE (Product, BusinessPartner, Plant, Batch, ProductStockAvailabilityDate) = (?, ?, ?, ?, ?);`,
+		productStock.AvailableProductStock, productStock.Product, productStock.BusinessPartner, productStock.Plant, productStock.Batch, productStock.ProductStockAvailabilityDate,
+	)
+}
+
+func (w *MySQLSQLWriter) exec(function string, query string, args ...interface{}) error {
+	if _, err := w.db.Exec(query, args...); err != nil {
+		return xerrors.Errorf("%s data cannot update: %v: %w", function, err, errSQL)
+	}
+	return nil
+}
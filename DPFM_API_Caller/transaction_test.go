@@ -0,0 +1,164 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"testing"
+)
+
+// testTransactor は、オーダーと在庫の複製を更新し、コミットした場合のみ元のリポジトリに反映するトランザクションを開始します
+type testTransactor struct {
+	c                *testCaller
+	failScheduleLine bool
+	commits          int
+	rollbacks        int
+}
+
+func (t *testTransactor) Begin() (Transaction, error) {
+	orders := NewMemoryOrdersRepository()
+	t.c.orders.mtx.RLock()
+	for _, order := range t.c.orders.orders {
+		orders.Seed(*order)
+	}
+	t.c.orders.mtx.RUnlock()
+	stocks := NewMemoryStockRepository()
+	t.c.stocks.mtx.RLock()
+	for _, stock := range t.c.stocks.stocks {
+		stocks.Seed(stock)
+	}
+	t.c.stocks.mtx.RUnlock()
+	return &testTransaction{
+		transactor:  t,
+		orders:      orders,
+		stocks:      stocks,
+		writer:      &failingWriter{SQLWriter: NewMemorySQLWriter(orders, stocks), failScheduleLine: t.failScheduleLine},
+		ledger:      NewMemoryStockLedgerStore(),
+		idempotency: make(map[IdempotencyKey]*IdempotencyRecord),
+	}, nil
+}
+
+type testTransaction struct {
+	transactor  *testTransactor
+	orders      *MemoryOrdersRepository
+	stocks      *MemoryStockRepository
+	writer      SQLWriter
+	events      []dpfm_api_output_formatter.DomainEvent
+	ledger      *MemoryStockLedgerStore
+	idempotency map[IdempotencyKey]*IdempotencyRecord
+}
+
+func (tx *testTransaction) Orders() OrdersRepository { return tx.orders }
+func (tx *testTransaction) Stocks() StockRepository  { return tx.stocks }
+func (tx *testTransaction) Writer() SQLWriter        { return tx.writer }
+
+func (tx *testTransaction) AppendEvents(events []dpfm_api_output_formatter.DomainEvent) error {
+	tx.events = append(tx.events, events...)
+	return nil
+}
+
+func (tx *testTransaction) AppendStockMovements(movements []StockMovement) error {
+	return tx.ledger.Append(movements)
+}
+
+func (tx *testTransaction) StockMovements(query StockMovementQuery) ([]StockMovement, error) {
+	committed, err := tx.transactor.c.stockLedger.List(query)
+	if err != nil {
+		return nil, err
+	}
+	uncommitted, err := tx.ledger.List(query)
+	if err != nil {
+		return nil, err
+	}
+	return append(committed, uncommitted...), nil
+}
+
+func (tx *testTransaction) SaveIdempotency(key IdempotencyKey, record *IdempotencyRecord) error {
+	tx.idempotency[key] = record
+	return nil
+}
+
+func (tx *testTransaction) Commit() error {
+	tx.transactor.commits++
+	c := tx.transactor.c
+	c.orders.mtx.Lock()
+	c.orders.orders = tx.orders.orders
+	c.orders.mtx.Unlock()
+	c.stocks.mtx.Lock()
+	c.stocks.stocks = tx.stocks.stocks
+	c.stocks.mtx.Unlock()
+	if err := c.outbox.Append(tx.events); err != nil {
+		return err
+	}
+	if err := c.stockLedger.Append(tx.ledger.movements); err != nil {
+		return err
+	}
+	for key, record := range tx.idempotency {
+		if err := c.idempotency.Save(key, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *testTransaction) Rollback() error {
+	tx.transactor.rollbacks++
+	return nil
+}
+
+func newTestCallerWithTransactor(t *testing.T, failScheduleLine bool) (*testCaller, *testTransactor) {
+	t.Helper()
+	c := newTestCaller(t, testStock(testDate, 100))
+	transactor := &testTransactor{c: c, failScheduleLine: failScheduleLine}
+	c.transactor = transactor
+	return c, transactor
+}
+
+func TestTransactionIsCommittedOnCancel(t *testing.T) {
+	c, transactor := newTestCallerWithTransactor(t, false)
+
+	_, errs := c.call(t, testInput("cancels", "cancel", true))
+	mustNoErrors(t, errs)
+	if transactor.commits != 1 || transactor.rollbacks != 0 {
+		t.Fatalf("commits = %d, rollbacks = %d, want 1, 0", transactor.commits, transactor.rollbacks)
+	}
+	if !isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Error("schedule line is not cancelled after commit")
+	}
+	assertQuantity(t, "stock after commit", c.stock(t, testDate), 110)
+	movements, err := c.stockLedger.List(StockMovementQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movements) != 1 {
+		t.Errorf("stock movements after commit = %d, want 1", len(movements))
+	}
+}
+
+func TestTransactionIsRolledBackOnFailure(t *testing.T) {
+	c, transactor := newTestCallerWithTransactor(t, true)
+
+	if _, errs := c.call(t, testInput("cancels", "failed", true)); len(errs) == 0 {
+		t.Fatal("cancel succeeded though the schedule line cannot be updated")
+	}
+	if transactor.commits != 0 || transactor.rollbacks != 1 {
+		t.Fatalf("commits = %d, rollbacks = %d, want 0, 1", transactor.commits, transactor.rollbacks)
+	}
+	if isTrue(c.header(t).IsCancelled) || isTrue(c.item(t).IsCancelled) || isTrue(c.itemScheduleLine(t).IsCancelled) {
+		t.Error("order is cancelled though the transaction is rolled back")
+	}
+	assertQuantity(t, "confirmed quantity after rollback", c.itemScheduleLine(t).ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, 10)
+	assertQuantity(t, "stock after rollback", c.stock(t, testDate), 100)
+	movements, err := c.stockLedger.List(StockMovementQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movements) != 0 {
+		t.Errorf("stock movements after rollback = %d, want 0", len(movements))
+	}
+	events, err := c.outbox.Pending(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("domain events after rollback = %d, want 0", len(events))
+	}
+}
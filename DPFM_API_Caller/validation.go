@@ -0,0 +1,264 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"fmt"
+	"time"
+)
+
+// 入力の検証エラーのコードです。呼び出し元が判定に使うため、値は変更しない
+const (
+	ValidationRequired      = "REQUIRED"
+	ValidationOutOfRange    = "OUT_OF_RANGE"
+	ValidationInvalidFormat = "INVALID_FORMAT"
+	ValidationUnsupported   = "UNSUPPORTED"
+	ValidationInconsistent  = "INCONSISTENT"
+	ValidationDuplicate     = "DUPLICATE"
+)
+
+// ID の上限は、テーブルの列の桁数です。32 ビット環境の int を超えるため int64 とする
+const (
+	maxBusinessPartner int64 = 999999999999
+	maxOrderID         int64 = 9999999999999999
+	maxOrderItem       int64 = 999999
+	maxScheduleLine    int64 = 999
+)
+
+type validator struct {
+	errs []dpfm_api_output_formatter.ValidationError
+}
+
+func (v *validator) add(field, code, format string, args ...interface{}) {
+	v.errs = append(v.errs, dpfm_api_output_formatter.ValidationError{
+		Field:   field,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// id は、必須の ID が 1 以上 max 以下であることを検証します
+func (v *validator) id(field string, id int, max int64) {
+	if id == 0 {
+		v.add(field, ValidationRequired, "%s is required", field)
+		return
+	}
+	if id < 0 || int64(id) > max {
+		v.add(field, ValidationOutOfRange, "%s must be between 1 and %d", field, max)
+	}
+}
+
+func (v *validator) date(field string, date *string) {
+	if date == nil {
+		return
+	}
+	if _, err := time.Parse(stockAvailabilityDateLayout, *date); err != nil {
+		v.add(field, ValidationInvalidFormat, "%s must be %s", field, stockAvailabilityDateLayout)
+	}
+}
+
+// Validate は、キャンセル処理の前に、api_type・accepter ごとの必須項目、ID の範囲、accepter と入力の整合性を検証します
+// 検証エラーがない場合は空のスライスを返します
+func Validate(
+	accepter []string,
+	input *dpfm_api_input_reader.SDC,
+) []dpfm_api_output_formatter.ValidationError {
+	v := &validator{errs: make([]dpfm_api_output_formatter.ValidationError, 0)}
+	// runtime_session_id は、再配送されたメッセージの判定と一括キャンセルの登録の重複の判定に使う
+	if input.RuntimeSessionID == "" {
+		v.add("runtime_session_id", ValidationRequired, "runtime_session_id is required")
+	}
+	v.id("business_partner", input.BusinessPartner, maxBusinessPartner)
+	v.accepter(input.Accepter)
+
+	switch input.APIType {
+	case "cancels", "cancels-preview":
+		v.order("Orders", input.Header, accepter)
+		if input.APIType == "cancels" && input.EffectiveDateTime != nil {
+			if _, err := parseEffectiveDateTime(*input.EffectiveDateTime); err != nil {
+				v.add("EffectiveDateTime", ValidationInvalidFormat, "EffectiveDateTime must be RFC3339")
+			}
+		}
+	case "bulk-cancels":
+		if len(input.BulkOrders) == 0 {
+			v.add("BulkOrders", ValidationRequired, "BulkOrders is required")
+		}
+		orderIDs := make(map[int]struct{}, len(input.BulkOrders))
+		for i, order := range input.BulkOrders {
+			path := fmt.Sprintf("BulkOrders[%d]", i)
+			if _, ok := orderIDs[order.OrderID]; ok && order.OrderID != 0 {
+				v.add(path+".OrderID", ValidationDuplicate, "order %d is specified more than once", order.OrderID)
+			}
+			orderIDs[order.OrderID] = struct{}{}
+			v.order(path, order, accepter)
+		}
+	case "mass-cancels", "mass-cancels-preview":
+		v.criteria(input.Criteria)
+	case "scheduled-cancels-list", "cancellation-requests-list":
+		if input.Header.OrderID != 0 {
+			v.id("Orders.OrderID", input.Header.OrderID, maxOrderID)
+		}
+	case "scheduled-cancels-revoke":
+		if input.ScheduledCancellationID == nil {
+			v.add("ScheduledCancellationID", ValidationRequired, "ScheduledCancellationID is required")
+		} else if *input.ScheduledCancellationID <= 0 {
+			v.add("ScheduledCancellationID", ValidationOutOfRange, "ScheduledCancellationID must be positive")
+		}
+	case "mass-cancels-status":
+		if input.MassCancellationID == nil {
+			v.add("MassCancellationID", ValidationRequired, "MassCancellationID is required")
+		} else if *input.MassCancellationID <= 0 {
+			v.add("MassCancellationID", ValidationOutOfRange, "MassCancellationID must be positive")
+		}
+	case "cancellation-requests-confirm", "cancellation-requests-reject":
+		if input.CancellationRequestID == nil {
+			v.add("CancellationRequestID", ValidationRequired, "CancellationRequestID is required")
+		} else if *input.CancellationRequestID <= 0 {
+			v.add("CancellationRequestID", ValidationOutOfRange, "CancellationRequestID must be positive")
+		}
+	case "stock-movements":
+		v.stockMovements(input)
+	default:
+		v.add("api_type", ValidationUnsupported, "unknown api type %s", input.APIType)
+	}
+	return v.errs
+}
+
+// accepter は、指定されたデータ種別を検証します。All は単独でのみ指定できます
+func (v *validator) accepter(accepter []string) {
+	for i, a := range accepter {
+		field := fmt.Sprintf("accepter[%d]", i)
+		switch a {
+		case "Header", "Item", "ItemScheduleLine":
+		case "All":
+			if len(accepter) != 1 {
+				v.add(field, ValidationInconsistent, "All cannot be specified with other accepters")
+			}
+		default:
+			v.add(field, ValidationUnsupported, "unknown accepter %s", a)
+		}
+	}
+}
+
+// order は、accepter のデータ種別ごとに、キャンセルに必要な項目が指定されているかを検証します
+// 明細・スケジュール行が指定されていない場合はサブファンクションで補完されるため、補完時に引き継ぐ上位の IsCancelled を必須とする
+func (v *validator) order(path string, header dpfm_api_input_reader.Header, accepter []string) {
+	has := make(map[string]bool, len(accepter))
+	for _, a := range accepter {
+		has[a] = true
+	}
+	v.id(path+".OrderID", header.OrderID, maxOrderID)
+
+	if has["Header"] && header.IsCancelled == nil {
+		v.add(path+".IsCancelled", ValidationRequired, "IsCancelled is required for Header")
+	}
+	if !has["Item"] && !has["ItemScheduleLine"] && len(header.Item) != 0 {
+		v.add(path+".Item", ValidationInconsistent, "Item is specified but accepter has neither Item nor ItemScheduleLine")
+	}
+	if has["Item"] && len(header.Item) == 0 && !has["Header"] && header.IsCancelled == nil {
+		v.add(path+".IsCancelled", ValidationRequired, "IsCancelled is required for Item when Item is not specified")
+	}
+	if has["ItemScheduleLine"] && !has["Item"] && len(header.Item) == 0 {
+		v.add(path+".Item", ValidationRequired, "Item is required for ItemScheduleLine")
+	}
+
+	orderItems := make(map[int]struct{}, len(header.Item))
+	for i, item := range header.Item {
+		itemPath := fmt.Sprintf("%s.Item[%d]", path, i)
+		v.id(itemPath+".OrderItem", item.OrderItem, maxOrderItem)
+		if _, ok := orderItems[item.OrderItem]; ok && item.OrderItem != 0 {
+			v.add(itemPath+".OrderItem", ValidationDuplicate, "order item %d is specified more than once", item.OrderItem)
+		}
+		orderItems[item.OrderItem] = struct{}{}
+		if item.OrderID != 0 && item.OrderID != header.OrderID {
+			v.add(itemPath+".OrderID", ValidationInconsistent, "OrderID %d differs from OrderID %d of the order", item.OrderID, header.OrderID)
+		}
+
+		// 明細のキャンセルは、すべての明細を先頭の明細と同じ IsCancelled で処理する
+		if has["Item"] {
+			switch {
+			case item.IsCancelled == nil:
+				v.add(itemPath+".IsCancelled", ValidationRequired, "IsCancelled is required for Item")
+			case header.IsCancelled != nil && *item.IsCancelled != *header.IsCancelled:
+				v.add(itemPath+".IsCancelled", ValidationInconsistent, "IsCancelled differs from IsCancelled of the order")
+			case header.Item[0].IsCancelled != nil && *item.IsCancelled != *header.Item[0].IsCancelled:
+				v.add(itemPath+".IsCancelled", ValidationInconsistent, "IsCancelled differs from IsCancelled of the first item")
+			}
+		}
+		if !has["ItemScheduleLine"] {
+			if len(item.ItemScheduleLine) != 0 {
+				v.add(itemPath+".ItemScheduleLine", ValidationInconsistent, "ItemScheduleLine is specified but accepter does not have ItemScheduleLine")
+			}
+			continue
+		}
+		if len(item.ItemScheduleLine) == 0 && !has["Item"] && item.IsCancelled == nil {
+			v.add(itemPath+".IsCancelled", ValidationRequired, "IsCancelled is required for ItemScheduleLine when ItemScheduleLine is not specified")
+		}
+		v.itemScheduleLines(itemPath, header.OrderID, item)
+	}
+}
+
+// itemScheduleLines は、スケジュール行ごとに IsCancelled か CancelledQuantityInBaseUnit のいずれかが指定されているかを検証します
+func (v *validator) itemScheduleLines(path string, orderID int, item dpfm_api_input_reader.Item) {
+	scheduleLines := make(map[int]struct{}, len(item.ItemScheduleLine))
+	for i, itemScheduleLine := range item.ItemScheduleLine {
+		linePath := fmt.Sprintf("%s.ItemScheduleLine[%d]", path, i)
+		v.id(linePath+".ScheduleLine", itemScheduleLine.ScheduleLine, maxScheduleLine)
+		if _, ok := scheduleLines[itemScheduleLine.ScheduleLine]; ok && itemScheduleLine.ScheduleLine != 0 {
+			v.add(linePath+".ScheduleLine", ValidationDuplicate, "schedule line %d is specified more than once", itemScheduleLine.ScheduleLine)
+		}
+		scheduleLines[itemScheduleLine.ScheduleLine] = struct{}{}
+		if itemScheduleLine.OrderID != 0 && itemScheduleLine.OrderID != orderID {
+			v.add(linePath+".OrderID", ValidationInconsistent, "OrderID %d differs from OrderID %d of the order", itemScheduleLine.OrderID, orderID)
+		}
+		if itemScheduleLine.OrderItem != 0 && itemScheduleLine.OrderItem != item.OrderItem {
+			v.add(linePath+".OrderItem", ValidationInconsistent, "OrderItem %d differs from OrderItem %d of the item", itemScheduleLine.OrderItem, item.OrderItem)
+		}
+
+		switch quantity := itemScheduleLine.CancelledQuantityInBaseUnit; {
+		case quantity == nil && itemScheduleLine.IsCancelled == nil:
+			v.add(linePath+".IsCancelled", ValidationRequired, "IsCancelled or CancelledQuantityInBaseUnit is required for ItemScheduleLine")
+		case quantity == nil:
+		case itemScheduleLine.IsCancelled != nil:
+			v.add(linePath+".CancelledQuantityInBaseUnit", ValidationInconsistent, "IsCancelled and CancelledQuantityInBaseUnit cannot be specified together")
+		case quantity.Sign() <= 0:
+			v.add(linePath+".CancelledQuantityInBaseUnit", ValidationOutOfRange, "CancelledQuantityInBaseUnit must be positive")
+		}
+	}
+}
+
+func (v *validator) criteria(criteria dpfm_api_input_reader.Criteria) {
+	if criteria.Buyer == nil && criteria.Seller == nil && criteria.RequestedDeliveryDateTo == nil && criteria.Product == nil && criteria.Plant == nil {
+		v.add("Criteria", ValidationRequired, "at least one criteria is required for mass cancellation")
+	}
+	if criteria.Buyer != nil {
+		v.id("Criteria.Buyer", *criteria.Buyer, maxBusinessPartner)
+	}
+	if criteria.Seller != nil {
+		v.id("Criteria.Seller", *criteria.Seller, maxBusinessPartner)
+	}
+	v.date("Criteria.RequestedDeliveryDateTo", criteria.RequestedDeliveryDateTo)
+	if criteria.BatchSize != nil && *criteria.BatchSize <= 0 {
+		v.add("Criteria.BatchSize", ValidationOutOfRange, "Criteria.BatchSize must be positive")
+	}
+}
+
+func (v *validator) stockMovements(input *dpfm_api_input_reader.SDC) {
+	if input.Header.OrderID != 0 {
+		v.id("Orders.OrderID", input.Header.OrderID, maxOrderID)
+		return
+	}
+	productStock := input.ProductStock
+	if productStock.Product == nil {
+		v.add("ProductStock.Product", ValidationRequired, "Orders.OrderID or ProductStock.Product is required")
+	}
+	if productStock.BusinessPartner == nil {
+		v.add("ProductStock.BusinessPartner", ValidationRequired, "Orders.OrderID or ProductStock.BusinessPartner is required")
+	} else {
+		v.id("ProductStock.BusinessPartner", *productStock.BusinessPartner, maxBusinessPartner)
+	}
+	if productStock.Plant == nil {
+		v.add("ProductStock.Plant", ValidationRequired, "Orders.OrderID or ProductStock.Plant is required")
+	}
+	v.date("ProductStock.ProductStockAvailabilityDate", productStock.ProductStockAvailabilityDate)
+}
@@ -0,0 +1,389 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestValidateOrderIDRange(t *testing.T) {
+	input := testInput("cancels", "validate", true)
+	if errs := Validate(input.Accepter, input); len(errs) != 0 {
+		t.Fatalf("valid input has validation errors: %+v", errs)
+	}
+
+	input.Header.OrderID = -1
+	errs := Validate(input.Accepter, input)
+	if len(errs) == 0 || errs[0].Field != "Orders.OrderID" || errs[0].Code != ValidationOutOfRange {
+		t.Errorf("validation errors = %+v, want Orders.OrderID %s", errs, ValidationOutOfRange)
+	}
+}
+
+func TestValidateRequiresRuntimeSessionID(t *testing.T) {
+	input := testInput("cancels", "", true)
+	errs := Validate(input.Accepter, input)
+	if len(errs) != 1 || errs[0].Field != "runtime_session_id" || errs[0].Code != ValidationRequired {
+		t.Errorf("validation errors = %+v, want runtime_session_id %s", errs, ValidationRequired)
+	}
+}
+
+type wantValidationError struct {
+	field string
+	code  string
+}
+
+func TestValidate(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	quantity := func(v int64) *decimal.Decimal {
+		d := decimal.NewFromInt(v)
+		return &d
+	}
+	tests := []struct {
+		name  string
+		input func() *dpfm_api_input_reader.SDC
+		want  []wantValidationError
+	}{
+		{
+			name:  "cancels",
+			input: func() *dpfm_api_input_reader.SDC { return testInput("cancels", "validate", true) },
+		},
+		{
+			name:  "cancels-preview",
+			input: func() *dpfm_api_input_reader.SDC { return testInput("cancels-preview", "validate", true) },
+		},
+		{
+			name: "business_partner is required",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.BusinessPartner = 0
+				return input
+			},
+			want: []wantValidationError{{"business_partner", ValidationRequired}},
+		},
+		{
+			name: "business_partner out of range",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.BusinessPartner = -1
+				return input
+			},
+			want: []wantValidationError{{"business_partner", ValidationOutOfRange}},
+		},
+		{
+			name: "unknown api_type",
+			input: func() *dpfm_api_input_reader.SDC {
+				return testInput("deletes", "validate", true)
+			},
+			want: []wantValidationError{{"api_type", ValidationUnsupported}},
+		},
+		{
+			name: "unknown accepter",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.Accepter = append(input.Accepter, "Partner")
+				return input
+			},
+			want: []wantValidationError{{"accepter[3]", ValidationUnsupported}},
+		},
+		{
+			name: "All with other accepters",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.Accepter = []string{"All", "Header"}
+				input.Header.Item = nil
+				return input
+			},
+			want: []wantValidationError{{"accepter[0]", ValidationInconsistent}},
+		},
+		{
+			name: "Header IsCancelled is required",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.Header.IsCancelled = nil
+				input.Accepter = []string{"Header"}
+				input.Header.Item = nil
+				return input
+			},
+			want: []wantValidationError{{"Orders.IsCancelled", ValidationRequired}},
+		},
+		{
+			name: "Item without Item accepter",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.Accepter = []string{"Header"}
+				return input
+			},
+			want: []wantValidationError{{"Orders.Item", ValidationInconsistent}},
+		},
+		{
+			name: "Item IsCancelled differs from the order",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.Header.Item[0].IsCancelled = getBoolPtr(false)
+				return input
+			},
+			want: []wantValidationError{{"Orders.Item[0].IsCancelled", ValidationInconsistent}},
+		},
+		{
+			name: "Item OrderID differs from the order",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.Header.Item[0].OrderID = testOrderID + 1
+				return input
+			},
+			want: []wantValidationError{{"Orders.Item[0].OrderID", ValidationInconsistent}},
+		},
+		{
+			name: "duplicate OrderItem",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.Header.Item = append(input.Header.Item, input.Header.Item[0])
+				return input
+			},
+			want: []wantValidationError{{"Orders.Item[1].OrderItem", ValidationDuplicate}},
+		},
+		{
+			name: "ItemScheduleLine requires Item",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.Header.Item = nil
+				input.Accepter = []string{"ItemScheduleLine"}
+				return input
+			},
+			want: []wantValidationError{{"Orders.Item", ValidationRequired}},
+		},
+		{
+			name: "partial cancel",
+			input: func() *dpfm_api_input_reader.SDC {
+				return partialCancelInput("validate", 5)
+			},
+		},
+		{
+			name: "IsCancelled with CancelledQuantityInBaseUnit",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := partialCancelInput("validate", 5)
+				input.Header.Item[0].ItemScheduleLine[0].IsCancelled = getBoolPtr(true)
+				return input
+			},
+			want: []wantValidationError{{"Orders.Item[0].ItemScheduleLine[0].CancelledQuantityInBaseUnit", ValidationInconsistent}},
+		},
+		{
+			name: "CancelledQuantityInBaseUnit must be positive",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := partialCancelInput("validate", 5)
+				input.Header.Item[0].ItemScheduleLine[0].CancelledQuantityInBaseUnit = quantity(0)
+				return input
+			},
+			want: []wantValidationError{{"Orders.Item[0].ItemScheduleLine[0].CancelledQuantityInBaseUnit", ValidationOutOfRange}},
+		},
+		{
+			name: "ItemScheduleLine IsCancelled is required",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := partialCancelInput("validate", 5)
+				input.Header.Item[0].ItemScheduleLine[0].CancelledQuantityInBaseUnit = nil
+				return input
+			},
+			want: []wantValidationError{{"Orders.Item[0].ItemScheduleLine[0].IsCancelled", ValidationRequired}},
+		},
+		{
+			name: "duplicate ScheduleLine",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := partialCancelInput("validate", 5)
+				item := &input.Header.Item[0]
+				item.ItemScheduleLine = append(item.ItemScheduleLine, item.ItemScheduleLine[0])
+				return input
+			},
+			want: []wantValidationError{{"Orders.Item[0].ItemScheduleLine[1].ScheduleLine", ValidationDuplicate}},
+		},
+		{
+			name: "ScheduleLine out of range",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := partialCancelInput("validate", 5)
+				input.Header.Item[0].ItemScheduleLine[0].ScheduleLine = 1000
+				return input
+			},
+			want: []wantValidationError{{"Orders.Item[0].ItemScheduleLine[0].ScheduleLine", ValidationOutOfRange}},
+		},
+		{
+			name: "EffectiveDateTime",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.EffectiveDateTime = getStringPtr("2022-10-01T09:00:00+09:00")
+				return input
+			},
+		},
+		{
+			name: "EffectiveDateTime is not RFC3339",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("cancels", "validate", true)
+				input.EffectiveDateTime = getStringPtr("2022-10-01 09:00")
+				return input
+			},
+			want: []wantValidationError{{"EffectiveDateTime", ValidationInvalidFormat}},
+		},
+		{
+			name: "bulk-cancels",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("bulk-cancels", "validate", true)
+				other := input.Header
+				other.OrderID = testOrderID + 1
+				other.Item = nil
+				input.BulkOrders = []dpfm_api_input_reader.Header{input.Header, other}
+				input.Header = dpfm_api_input_reader.Header{}
+				input.Accepter = []string{"Header", "Item"}
+				return input
+			},
+		},
+		{
+			name: "BulkOrders is required",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("bulk-cancels", "validate", true)
+				input.Header = dpfm_api_input_reader.Header{}
+				return input
+			},
+			want: []wantValidationError{{"BulkOrders", ValidationRequired}},
+		},
+		{
+			name: "duplicate BulkOrders",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testInput("bulk-cancels", "validate", true)
+				input.BulkOrders = []dpfm_api_input_reader.Header{input.Header, input.Header}
+				input.Header = dpfm_api_input_reader.Header{}
+				return input
+			},
+			want: []wantValidationError{{"BulkOrders[1].OrderID", ValidationDuplicate}},
+		},
+		{
+			name: "mass-cancels",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testMassInput("validate", 10)
+				input.Criteria.RequestedDeliveryDateTo = getStringPtr(testDate)
+				return input
+			},
+		},
+		{
+			name: "mass-cancels-preview without criteria",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testMassInput("validate", 10)
+				input.APIType = "mass-cancels-preview"
+				input.Criteria = dpfm_api_input_reader.Criteria{}
+				return input
+			},
+			want: []wantValidationError{{"Criteria", ValidationRequired}},
+		},
+		{
+			name: "mass-cancels with invalid criteria",
+			input: func() *dpfm_api_input_reader.SDC {
+				input := testMassInput("validate", 0)
+				input.Criteria.Buyer = intPtr(-1)
+				input.Criteria.RequestedDeliveryDateTo = getStringPtr("2022/10/01")
+				return input
+			},
+			want: []wantValidationError{
+				{"Criteria.Buyer", ValidationOutOfRange},
+				{"Criteria.RequestedDeliveryDateTo", ValidationInvalidFormat},
+				{"Criteria.BatchSize", ValidationOutOfRange},
+			},
+		},
+		{
+			name: "mass-cancels-status",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "mass-cancels-status", MassCancellationID: intPtr(1)}
+			},
+		},
+		{
+			name: "MassCancellationID is required",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "mass-cancels-status"}
+			},
+			want: []wantValidationError{{"MassCancellationID", ValidationRequired}},
+		},
+		{
+			name: "scheduled-cancels-list",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "scheduled-cancels-list"}
+			},
+		},
+		{
+			name: "cancellation-requests-list with OrderID out of range",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "cancellation-requests-list", Header: dpfm_api_input_reader.Header{OrderID: -1}}
+			},
+			want: []wantValidationError{{"Orders.OrderID", ValidationOutOfRange}},
+		},
+		{
+			name: "ScheduledCancellationID is required",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "scheduled-cancels-revoke"}
+			},
+			want: []wantValidationError{{"ScheduledCancellationID", ValidationRequired}},
+		},
+		{
+			name: "ScheduledCancellationID must be positive",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "scheduled-cancels-revoke", ScheduledCancellationID: intPtr(0)}
+			},
+			want: []wantValidationError{{"ScheduledCancellationID", ValidationOutOfRange}},
+		},
+		{
+			name: "CancellationRequestID is required",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "cancellation-requests-confirm"}
+			},
+			want: []wantValidationError{{"CancellationRequestID", ValidationRequired}},
+		},
+		{
+			name: "CancellationRequestID must be positive",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "cancellation-requests-reject", CancellationRequestID: intPtr(-1)}
+			},
+			want: []wantValidationError{{"CancellationRequestID", ValidationOutOfRange}},
+		},
+		{
+			name: "stock-movements by order",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "stock-movements", Header: dpfm_api_input_reader.Header{OrderID: testOrderID}}
+			},
+		},
+		{
+			name: "stock-movements by product stock",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "stock-movements", ProductStock: dpfm_api_input_reader.ProductStock{
+					Product:                      getStringPtr(testProduct),
+					BusinessPartner:              intPtr(testSeller),
+					Plant:                        getStringPtr(testPlant),
+					ProductStockAvailabilityDate: getStringPtr(testDate),
+				}}
+			},
+		},
+		{
+			name: "stock-movements without order or product stock",
+			input: func() *dpfm_api_input_reader.SDC {
+				return &dpfm_api_input_reader.SDC{RuntimeSessionID: "validate", BusinessPartner: testSeller, APIType: "stock-movements", ProductStock: dpfm_api_input_reader.ProductStock{
+					ProductStockAvailabilityDate: getStringPtr("20221001"),
+				}}
+			},
+			want: []wantValidationError{
+				{"ProductStock.Product", ValidationRequired},
+				{"ProductStock.BusinessPartner", ValidationRequired},
+				{"ProductStock.Plant", ValidationRequired},
+				{"ProductStock.ProductStockAvailabilityDate", ValidationInvalidFormat},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.input()
+			errs := Validate(input.Accepter, input)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("validation errors = %+v, want %v", errs, tt.want)
+			}
+			for i, want := range tt.want {
+				if errs[i].Field != want.field || errs[i].Code != want.code {
+					t.Errorf("validation error %d = %s %s, want %s %s", i, errs[i].Field, errs[i].Code, want.field, want.code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"golang.org/x/xerrors"
+)
+
+// sql-update-kube の function、および更新のメトリクス・イベントのラベル
+const (
+	functionHeader              = "OrdersHeader"
+	functionItem                = "OrdersItem"
+	functionItemScheduleLine    = "OrdersItemScheduleLine"
+	functionProductStock        = "ProductStockAvailability"
+	functionProductStockByBatch = "ProductStockAvailabilityByBatch"
+)
+
+// SQLWriter は、キャンセル処理によるオーダー・在庫の更新の書き込み先です
+// UpdateScheduleLine の CancelledQuantityInBaseUnit は、その更新でキャンセルする数量で、書き込み先はキャンセル済みの数量に加算します。nil の場合は変更しません
+type SQLWriter interface {
+	UpdateHeader(sessionID string, header dpfm_api_output_formatter.Header) error
+	UpdateItem(sessionID string, item dpfm_api_output_formatter.Item) error
+	UpdateScheduleLine(sessionID string, itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine) error
+	UpdateStock(sessionID string, productStock dpfm_api_output_formatter.ProductStock) error
+	UpdateStockByBatch(sessionID string, productStock dpfm_api_output_formatter.ProductStock) error
+}
+
+// RMQSQLWriter は、sql-update-kube に更新を依頼し、その応答を待ちます
+// スケジュール行の CancelledQuantityInBaseUnit は加算する数量のまま送信するため、sql-update-kube はキャンセル済みの数量に加算します
+type RMQSQLWriter struct {
+	rmq   RMQClient
+	queue string
+}
+
+func NewRMQSQLWriter(rmq RMQClient, queue string) *RMQSQLWriter {
+	return &RMQSQLWriter{rmq: rmq, queue: queue}
+}
+
+func (w *RMQSQLWriter) UpdateHeader(sessionID string, header dpfm_api_output_formatter.Header) error {
+	return w.request(sessionID, functionHeader, header)
+}
+
+func (w *RMQSQLWriter) UpdateItem(sessionID string, item dpfm_api_output_formatter.Item) error {
+	return w.request(sessionID, functionItem, item)
+}
+
+func (w *RMQSQLWriter) UpdateScheduleLine(sessionID string, itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine) error {
+	return w.request(sessionID, functionItemScheduleLine, itemScheduleLine)
+}
+
+func (w *RMQSQLWriter) UpdateStock(sessionID string, productStock dpfm_api_output_formatter.ProductStock) error {
+	return w.request(sessionID, functionProductStock, productStock)
+}
+
+func (w *RMQSQLWriter) UpdateStockByBatch(sessionID string, productStock dpfm_api_output_formatter.ProductStock) error {
+	return w.request(sessionID, functionProductStockByBatch, productStock)
+}
+
+func (w *RMQSQLWriter) request(sessionID string, function string, message interface{}) error {
+	res, err := w.rmq.SessionKeepRequest(nil, w.queue, map[string]interface{}{"message": message, "function": function, "runtime_session_id": sessionID})
+	if err != nil {
+		return xerrors.Errorf("%v: %w", err, errRMQ)
+	}
+	res.Success()
+	if !checkResult(res) {
+		return xerrors.Errorf("%s data cannot update: %w", function, errSQL)
+	}
+	return nil
+}
+
+// MemorySQLWriter は、sql-update-kube を使わずに MemoryOrdersRepository・MemoryStockRepository を直接更新します
+// ローカルでの実行やテストで使用し、オーダーはキャンセル処理で変更する項目のみを更新します
+type MemorySQLWriter struct {
+	orders *MemoryOrdersRepository
+	stocks *MemoryStockRepository
+}
+
+func NewMemorySQLWriter(orders *MemoryOrdersRepository, stocks *MemoryStockRepository) *MemorySQLWriter {
+	return &MemorySQLWriter{orders: orders, stocks: stocks}
+}
+
+func (w *MemorySQLWriter) UpdateHeader(sessionID string, header dpfm_api_output_formatter.Header) error {
+	w.orders.mtx.Lock()
+	defer w.orders.mtx.Unlock()
+	order, ok := w.orders.orders[header.OrderID]
+	if !ok {
+		return xerrors.Errorf("%s %d: %w", functionHeader, header.OrderID, errSQL)
+	}
+	order.Header.IsCancelled = header.IsCancelled
+	order.Header.CancellationReasonCode = header.CancellationReasonCode
+	order.Header.CancellationComment = header.CancellationComment
+	return nil
+}
+
+func (w *MemorySQLWriter) UpdateItem(sessionID string, item dpfm_api_output_formatter.Item) error {
+	w.orders.mtx.Lock()
+	defer w.orders.mtx.Unlock()
+	if order, ok := w.orders.orders[item.OrderID]; ok {
+		for i := range order.Item {
+			if order.Item[i].OrderItem != item.OrderItem {
+				continue
+			}
+			order.Item[i].IsCancelled = item.IsCancelled
+			order.Item[i].CancellationReasonCode = item.CancellationReasonCode
+			order.Item[i].CancellationComment = item.CancellationComment
+			return nil
+		}
+	}
+	return xerrors.Errorf("%s %d-%d: %w", functionItem, item.OrderID, item.OrderItem, errSQL)
+}
+
+func (w *MemorySQLWriter) UpdateScheduleLine(sessionID string, itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine) error {
+	w.orders.mtx.Lock()
+	defer w.orders.mtx.Unlock()
+	if order, ok := w.orders.orders[itemScheduleLine.OrderID]; ok {
+		for i := range order.ItemScheduleLine {
+			v := &order.ItemScheduleLine[i]
+			if v.OrderItem != itemScheduleLine.OrderItem || v.ScheduleLine != itemScheduleLine.ScheduleLine {
+				continue
+			}
+			applyScheduleLineUpdate(v, itemScheduleLine)
+			return nil
+		}
+	}
+	return xerrors.Errorf("%s %d-%d-%d: %w", functionItemScheduleLine, itemScheduleLine.OrderID, itemScheduleLine.OrderItem, itemScheduleLine.ScheduleLine, errSQL)
+}
+
+// applyScheduleLineUpdate は、スケジュール行の更新のうちキャンセル処理で変更する項目を v に反映します
+func applyScheduleLineUpdate(v *dpfm_api_output_formatter.ItemScheduleLine, itemScheduleLine dpfm_api_output_formatter.ItemScheduleLine) {
+	// 在庫確認先を含まない更新は、確定数量を変更しない
+	if itemScheduleLine.Product != "" {
+		v.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit = itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit
+	}
+	v.IsCancelled = itemScheduleLine.IsCancelled
+	if itemScheduleLine.CancelledQuantityInBaseUnit != nil {
+		cancelledQuantity := *itemScheduleLine.CancelledQuantityInBaseUnit
+		if v.CancelledQuantityInBaseUnit != nil {
+			cancelledQuantity = dpfm_api_output_formatter.NewQuantity(cancelledQuantity.Add(v.CancelledQuantityInBaseUnit.Decimal))
+		}
+		v.CancelledQuantityInBaseUnit = &cancelledQuantity
+	}
+	v.CancellationReasonCode = itemScheduleLine.CancellationReasonCode
+	v.CancellationComment = itemScheduleLine.CancellationComment
+}
+
+func (w *MemorySQLWriter) UpdateStock(sessionID string, productStock dpfm_api_output_formatter.ProductStock) error {
+	productStock.Batch = ""
+	w.stocks.Seed(productStock)
+	return nil
+}
+
+func (w *MemorySQLWriter) UpdateStockByBatch(sessionID string, productStock dpfm_api_output_formatter.ProductStock) error {
+	if productStock.Batch == "" {
+		return xerrors.Errorf("%s batch is empty: %w", functionProductStockByBatch, errSQL)
+	}
+	w.stocks.Seed(productStock)
+	return nil
+}
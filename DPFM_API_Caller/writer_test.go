@@ -0,0 +1,140 @@
+package dpfm_api_caller
+
+import (
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"testing"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"github.com/shopspring/decimal"
+	"golang.org/x/xerrors"
+)
+
+func TestRMQSQLWriterRequestsSQLUpdate(t *testing.T) {
+	rmq := newTestRMQ()
+	requests := make([]map[string]interface{}, 0)
+	result := "success"
+	rmq.respond["sql-update"] = func(payload interface{}) (map[string]interface{}, error) {
+		requests = append(requests, payload.(map[string]interface{}))
+		return map[string]interface{}{"result": result}, nil
+	}
+	w := NewRMQSQLWriter(rmq, "sql-update")
+
+	updates := []struct {
+		function string
+		update   func() error
+	}{
+		{functionHeader, func() error { return w.UpdateHeader("session", dpfm_api_output_formatter.Header{OrderID: testOrderID}) }},
+		{functionItem, func() error { return w.UpdateItem("session", dpfm_api_output_formatter.Item{OrderID: testOrderID}) }},
+		{functionItemScheduleLine, func() error {
+			return w.UpdateScheduleLine("session", dpfm_api_output_formatter.ItemScheduleLine{OrderID: testOrderID})
+		}},
+		{functionProductStock, func() error { return w.UpdateStock("session", testStock(testDate, 100)) }},
+		{functionProductStockByBatch, func() error { return w.UpdateStockByBatch("session", testStock(testDate, 100)) }},
+	}
+	for _, u := range updates {
+		requests = requests[:0]
+		result = "success"
+		if err := u.update(); err != nil {
+			t.Errorf("%s: %v", u.function, err)
+		}
+		if len(requests) != 1 || requests[0]["function"] != u.function || requests[0]["runtime_session_id"] != "session" || requests[0]["message"] == nil {
+			t.Errorf("%s request = %+v", u.function, requests)
+		}
+
+		result = "failure"
+		if err := u.update(); !xerrors.Is(err, errSQL) {
+			t.Errorf("%s error on failure = %v, want %v", u.function, err, errSQL)
+		}
+	}
+
+	delete(rmq.respond, "sql-update")
+	if err := w.UpdateHeader("session", dpfm_api_output_formatter.Header{OrderID: testOrderID}); !xerrors.Is(err, errRMQ) {
+		t.Errorf("error without response = %v, want %v", err, errRMQ)
+	}
+}
+
+func TestMemorySQLWriterUpdatesCancellationFields(t *testing.T) {
+	orders := NewMemoryOrdersRepository()
+	orders.Seed(testOrder(decimal.NewFromInt(10), decimal.NewFromInt(10)))
+	stocks := NewMemoryStockRepository()
+	w := NewMemorySQLWriter(orders, stocks)
+	c := &testCaller{orders: orders, stocks: stocks, log: logger.NewLogger()}
+
+	header := c.header(t)
+	header.IsCancelled = getBoolPtr(true)
+	header.CancellationReasonCode = getStringPtr("OTHER")
+	header.HeaderDeliveryStatus = getStringPtr("CL")
+	if err := w.UpdateHeader("session", header); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.header(t); !isTrue(got.IsCancelled) || *got.CancellationReasonCode != "OTHER" || *got.HeaderDeliveryStatus != "NP" {
+		t.Errorf("header after update = %+v, want only cancellation fields updated", got)
+	}
+
+	item := c.item(t)
+	item.IsCancelled = getBoolPtr(true)
+	if err := w.UpdateItem("session", item); err != nil {
+		t.Fatal(err)
+	}
+	if !isTrue(c.item(t).IsCancelled) {
+		t.Error("item is not updated")
+	}
+
+	// スケジュール行のキャンセル数量は加算し、在庫確認先を含まない更新は確定数量を変更しない
+	for i := 0; i < 2; i++ {
+		cancelled := dpfm_api_output_formatter.NewQuantity(decimal.NewFromInt(3))
+		if err := w.UpdateScheduleLine("session", dpfm_api_output_formatter.ItemScheduleLine{
+			OrderID:                     testOrderID,
+			OrderItem:                   1,
+			ScheduleLine:                1,
+			IsCancelled:                 getBoolPtr(false),
+			CancelledQuantityInBaseUnit: &cancelled,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	itemScheduleLine := c.itemScheduleLine(t)
+	assertQuantity(t, "cancelled quantity", itemScheduleLine.CancelledQuantityInBaseUnit.Decimal, 6)
+	assertQuantity(t, "confirmed quantity", itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Decimal, 10)
+
+	missing := []error{
+		w.UpdateHeader("session", dpfm_api_output_formatter.Header{OrderID: testOrderID + 1}),
+		w.UpdateItem("session", dpfm_api_output_formatter.Item{OrderID: testOrderID, OrderItem: 2}),
+		w.UpdateScheduleLine("session", dpfm_api_output_formatter.ItemScheduleLine{OrderID: testOrderID, OrderItem: 1, ScheduleLine: 2}),
+	}
+	for i, err := range missing {
+		if !xerrors.Is(err, errSQL) {
+			t.Errorf("update %d of missing data = %v, want %v", i, err, errSQL)
+		}
+	}
+}
+
+func TestMemorySQLWriterUpdatesStock(t *testing.T) {
+	orders := NewMemoryOrdersRepository()
+	stocks := NewMemoryStockRepository()
+	w := NewMemorySQLWriter(orders, stocks)
+	log := logger.NewLogger()
+	itemScheduleLine := dpfm_api_output_formatter.ItemScheduleLine{
+		Product:                          testProduct,
+		StockConfirmationBusinessPartner: testSeller,
+		StockConfirmationPlant:           testPlant,
+		RequestedDeliveryDate:            getStringPtr(testDate),
+	}
+
+	// ロットを指定しない在庫の更新は、ロットを無視する
+	stock := testStock(testDate, 90)
+	stock.Batch = "B1"
+	if err := w.UpdateStock("session", stock); err != nil {
+		t.Fatal(err)
+	}
+	assertQuantity(t, "stock", stocks.ProductStockAvailabilityRead(itemScheduleLine, log).AvailableProductStock.Decimal, 90)
+
+	if err := w.UpdateStockByBatch("session", testStock(testDate, 80)); !xerrors.Is(err, errSQL) {
+		t.Errorf("update of stock by batch without batch = %v, want %v", err, errSQL)
+	}
+	if err := w.UpdateStockByBatch("session", stock); err != nil {
+		t.Fatal(err)
+	}
+	itemScheduleLine.StockConfirmationPlantBatch = getStringPtr("B1")
+	assertQuantity(t, "stock by batch", stocks.ProductStockAvailabilityByBatchRead(itemScheduleLine, log).AvailableProductStock.Decimal, 90)
+}
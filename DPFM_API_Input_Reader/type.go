@@ -1,5 +1,9 @@
 package dpfm_api_input_reader
 
+import (
+	"github.com/shopspring/decimal"
+)
+
 type EC_MC struct {
 	ConnectionKey string `json:"connection_key"`
 	Result        bool   `json:"result"`
@@ -54,39 +58,72 @@ type EC_MC struct {
 }
 
 type SDC struct {
-	ConnectionKey    string   `json:"connection_key"`
-	Result           bool     `json:"result"`
-	RedisKey         string   `json:"redis_key"`
-	Filepath         string   `json:"filepath"`
-	APIStatusCode    int      `json:"api_status_code"`
-	RuntimeSessionID string   `json:"runtime_session_id"`
-	BusinessPartner  int      `json:"business_partner"`
-	ServiceLabel     string   `json:"service_label"`
-	APIType          string   `json:"api_type"`
-	Header           Header   `json:"Orders"`
-	APISchema        string   `json:"api_schema"`
-	Accepter         []string `json:"accepter"`
-	Deleted          bool     `json:"deleted"`
+	ConnectionKey           string       `json:"connection_key"`
+	Result                  bool         `json:"result"`
+	RedisKey                string       `json:"redis_key"`
+	Filepath                string       `json:"filepath"`
+	APIStatusCode           int          `json:"api_status_code"`
+	RuntimeSessionID        string       `json:"runtime_session_id"`
+	BusinessPartner         int          `json:"business_partner"`
+	ServiceLabel            string       `json:"service_label"`
+	APIType                 string       `json:"api_type"`
+	Header                  Header       `json:"Orders"`
+	BulkOrders              []Header     `json:"BulkOrders"`
+	Criteria                Criteria     `json:"Criteria"`
+	EffectiveDateTime       *string      `json:"EffectiveDateTime"`
+	ScheduledCancellationID *int         `json:"ScheduledCancellationID"`
+	CancellationRequestID   *int         `json:"CancellationRequestID"`
+	MassCancellationID      *int         `json:"MassCancellationID"`
+	ProductStock            ProductStock `json:"ProductStock"`
+	APISchema               string       `json:"api_schema"`
+	Accepter                []string     `json:"accepter"`
+	Deleted                 bool         `json:"deleted"`
 }
 
 type Header struct {
-	OrderID             	int     `json:"OrderID"`
-	HeaderDeliveryStatus	*string `json:"HeaderDeliveryStatus"`
-	IsCancelled          	*bool   `json:"IsCancelled"`
-	Item                 	[]Item  `json:"Item"`
+	OrderID                int     `json:"OrderID"`
+	HeaderDeliveryStatus   *string `json:"HeaderDeliveryStatus"`
+	IsCancelled            *bool   `json:"IsCancelled"`
+	CancellationReasonCode *string `json:"CancellationReasonCode"`
+	CancellationComment    *string `json:"CancellationComment"`
+	Item                   []Item  `json:"Item"`
 }
 
 type Item struct {
-	OrderID            		int     			`json:"OrderID"`
-	OrderItem          		int                 `json:"OrderItem"`
-	ItemDeliveryStatus 		*string             `json:"ItemDeliveryStatus"`
-	IsCancelled        		*bool               `json:"IsCancelled"`
-	ItemScheduleLine   		[]ItemScheduleLine	`json:"ItemScheduleLine"`
+	OrderID                int                `json:"OrderID"`
+	OrderItem              int                `json:"OrderItem"`
+	ItemDeliveryStatus     *string            `json:"ItemDeliveryStatus"`
+	IsCancelled            *bool              `json:"IsCancelled"`
+	CancellationReasonCode *string            `json:"CancellationReasonCode"`
+	CancellationComment    *string            `json:"CancellationComment"`
+	ItemScheduleLine       []ItemScheduleLine `json:"ItemScheduleLine"`
 }
 
 type ItemScheduleLine struct {
-	OrderID            		int     			`json:"OrderID"`
-	OrderItem          		int                 `json:"OrderItem"`
-	ScheduleLine 			int   				`json:"ScheduleLine"`
-	IsCancelled  			*bool 				`json:"IsCancelled"`
+	OrderID                     int              `json:"OrderID"`
+	OrderItem                   int              `json:"OrderItem"`
+	ScheduleLine                int              `json:"ScheduleLine"`
+	IsCancelled                 *bool            `json:"IsCancelled"`
+	CancelledQuantityInBaseUnit *decimal.Decimal `json:"CancelledQuantityInBaseUnit"`
+	CancellationReasonCode      *string          `json:"CancellationReasonCode"`
+	CancellationComment         *string          `json:"CancellationComment"`
+}
+
+type Criteria struct {
+	Buyer                   *int    `json:"Buyer"`
+	Seller                  *int    `json:"Seller"`
+	RequestedDeliveryDateTo *string `json:"RequestedDeliveryDateTo"`
+	Product                 *string `json:"Product"`
+	Plant                   *string `json:"Plant"`
+	BatchSize               *int    `json:"BatchSize"`
+	CancellationReasonCode  *string `json:"CancellationReasonCode"`
+	CancellationComment     *string `json:"CancellationComment"`
+}
+
+type ProductStock struct {
+	Product                      *string `json:"Product"`
+	BusinessPartner              *int    `json:"BusinessPartner"`
+	Plant                        *string `json:"Plant"`
+	Batch                        *string `json:"Batch"`
+	ProductStockAvailabilityDate *string `json:"ProductStockAvailabilityDate"`
 }
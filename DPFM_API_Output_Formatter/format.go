@@ -14,6 +14,12 @@ func ConvertToHeader(rows *sql.Rows) (*Header, error) {
 		i++
 		err := rows.Scan(
 			&header.OrderID,
+			&header.HeaderDeliveryStatus,
+			&header.HeaderBillingStatus,
+			&header.IsCancelled,
+			&header.IsMarkedForDeletion,
+			&header.CancellationReasonCode,
+			&header.CancellationComment,
 		)
 		if err != nil {
 			fmt.Printf("err = %+v \n", err)
@@ -29,6 +35,58 @@ func ConvertToHeader(rows *sql.Rows) (*Header, error) {
 	return &header, nil
 }
 
+func ConvertToHeaderPartner(rows *sql.Rows) (*HeaderPartner, error) {
+	defer rows.Close()
+	headerPartner := HeaderPartner{}
+	i := 0
+
+	for rows.Next() {
+		i++
+		err := rows.Scan(
+			&headerPartner.OrderID,
+			&headerPartner.Buyer,
+			&headerPartner.Seller,
+		)
+		if err != nil {
+			fmt.Printf("err = %+v \n", err)
+			return &headerPartner, err
+		}
+
+	}
+	if i == 0 {
+		fmt.Printf("DBに対象のレコードが存在しません。")
+		return nil, nil
+	}
+
+	return &headerPartner, nil
+}
+
+func ConvertToOrderID(rows *sql.Rows) (*[]int, error) {
+	defer rows.Close()
+	orderIDs := make([]int, 0)
+	i := 0
+
+	for rows.Next() {
+		i++
+		orderID := 0
+		err := rows.Scan(
+			&orderID,
+		)
+		if err != nil {
+			fmt.Printf("err = %+v \n", err)
+			return &orderIDs, err
+		}
+
+		orderIDs = append(orderIDs, orderID)
+	}
+	if i == 0 {
+		fmt.Printf("DBに対象のレコードが存在しません。")
+		return &orderIDs, nil
+	}
+
+	return &orderIDs, nil
+}
+
 func ConvertToItem(rows *sql.Rows) (*[]Item, error) {
 	defer rows.Close()
 	items := make([]Item, 0)
@@ -40,6 +98,12 @@ func ConvertToItem(rows *sql.Rows) (*[]Item, error) {
 		err := rows.Scan(
 			&item.OrderID,
 			&item.OrderItem,
+			&item.ItemDeliveryStatus,
+			&item.ItemBillingStatus,
+			&item.IsCancelled,
+			&item.IsMarkedForDeletion,
+			&item.CancellationReasonCode,
+			&item.CancellationComment,
 		)
 		if err != nil {
 			fmt.Printf("err = %+v \n", err)
@@ -65,21 +129,26 @@ func ConvertToItemScheduleLine(rows *sql.Rows) (*[]ItemScheduleLine, error) {
 		itemScheduleLine := ItemScheduleLine{}
 		i++
 		err := rows.Scan(
-			&schedule.OrderID,
-			&schedule.OrderItem,
-			&schedule.ScheduleLine,
-			&schedule.Product,
-			&schedule.StockConfirmationBusinessPartner,
-			&schedule.StockConfirmationPlant,
-			&schedule.StockConfirmationPlantBatch,
-			&schedule.RequestedDeliveryDate,
-			&schedule.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit,
-			&schedule.IsCancelled,
-			&schedule.IsMarkedForDeletion,
+			&itemScheduleLine.OrderID,
+			&itemScheduleLine.OrderItem,
+			&itemScheduleLine.ScheduleLine,
+			&itemScheduleLine.Product,
+			&itemScheduleLine.StockConfirmationBusinessPartner,
+			&itemScheduleLine.StockConfirmationPlant,
+			&itemScheduleLine.StockConfirmationPlantBatch,
+			&itemScheduleLine.RequestedDeliveryDate,
+			&itemScheduleLine.BaseUnit,
+			&itemScheduleLine.ScheduleLineOrderQuantityInBaseUnit,
+			&itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit,
+			&itemScheduleLine.IsCancelled,
+			&itemScheduleLine.IsMarkedForDeletion,
+			&itemScheduleLine.CancellationReasonCode,
+			&itemScheduleLine.CancellationComment,
+			&itemScheduleLine.CancelledQuantityInBaseUnit,
 		)
 		if err != nil {
 			fmt.Printf("err = %+v \n", err)
-			return &schedules, err
+			return &itemScheduleLines, err
 		}
 
 		itemScheduleLines = append(itemScheduleLines, itemScheduleLine)
@@ -148,3 +217,28 @@ func ConvertToProductStockAvailabilityByBatch(rows *sql.Rows) (*ProductStock, er
 
 	return &productStock, nil
 }
+
+func ConvertToProductStocks(rows *sql.Rows) (*[]ProductStock, error) {
+	defer rows.Close()
+	productStocks := make([]ProductStock, 0)
+
+	for rows.Next() {
+		productStock := ProductStock{}
+		err := rows.Scan(
+			&productStock.Product,
+			&productStock.BusinessPartner,
+			&productStock.Plant,
+			&productStock.Batch,
+			&productStock.ProductStockAvailabilityDate,
+			&productStock.AvailableProductStock,
+		)
+		if err != nil {
+			fmt.Printf("err = %+v \n", err)
+			return &productStocks, err
+		}
+
+		productStocks = append(productStocks, productStock)
+	}
+
+	return &productStocks, nil
+}
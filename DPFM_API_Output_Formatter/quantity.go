@@ -0,0 +1,19 @@
+package dpfm_api_output_formatter
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// Quantity は、数量です
+// sql-update-kube やドメインイベントの受信側がこれまで通り数値として読めるように、JSON では引用符を付けずに出力する
+type Quantity struct {
+	decimal.Decimal
+}
+
+func NewQuantity(d decimal.Decimal) Quantity {
+	return Quantity{Decimal: d}
+}
+
+func (q Quantity) MarshalJSON() ([]byte, error) {
+	return []byte(q.String()), nil
+}
@@ -0,0 +1,39 @@
+package dpfm_api_output_formatter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestQuantityMarshalsAsNumber(t *testing.T) {
+	raw, err := json.Marshal(ItemScheduleLine{
+		ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit: NewQuantity(decimal.RequireFromString("10.5")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit":10.5`,
+		`"ScheduleLineOrderQuantityInBaseUnit":null`,
+	} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("%s does not contain %s", raw, want)
+		}
+	}
+
+	var itemScheduleLine ItemScheduleLine
+	if err := json.Unmarshal(raw, &itemScheduleLine); err != nil {
+		t.Fatal(err)
+	}
+	if !itemScheduleLine.ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit.Equal(decimal.RequireFromString("10.5")) || itemScheduleLine.ScheduleLineOrderQuantityInBaseUnit != nil {
+		t.Errorf("unmarshalled schedule line = %+v", itemScheduleLine)
+	}
+
+	// decimal.Decimal の JSON の出力は変更しない
+	if raw, _ := json.Marshal(decimal.NewFromInt(1)); string(raw) != `"1"` {
+		t.Errorf("decimal is marshalled as %s", raw)
+	}
+}
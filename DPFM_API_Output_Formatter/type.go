@@ -1,67 +1,206 @@
 package dpfm_api_output_formatter
 
 type SDC struct {
-	ConnectionKey       string      `json:"connection_key"`
-	Result              bool        `json:"result"`
-	RedisKey            string      `json:"redis_key"`
-	Filepath            string      `json:"filepath"`
-	APIStatusCode       int         `json:"api_status_code"`
-	RuntimeSessionID    string      `json:"runtime_session_id"`
-	BusinessPartnerID   *int        `json:"business_partner"`
-	ServiceLabel        string      `json:"service_label"`
-	APIType             string      `json:"api_type"`
-	Message             interface{} `json:"message"`
-	APISchema           string      `json:"api_schema"`
-	Accepter            []string    `json:"accepter"`
-	Deleted             bool        `json:"deleted"`
-	SQLUpdateResult     *bool       `json:"sql_update_result"`
-	SQLUpdateError      string      `json:"sql_update_error"`
-	SubfuncResult       *bool       `json:"subfunc_result"`
-	SubfuncError        string      `json:"subfunc_error"`
-	ExconfResult        *bool       `json:"exconf_result"`
-	ExconfError         string      `json:"exconf_error"`
-	APIProcessingResult *bool       `json:"api_processing_result"`
-	APIProcessingError  string      `json:"api_processing_error"`
+	ConnectionKey       string             `json:"connection_key"`
+	Result              bool               `json:"result"`
+	RedisKey            string             `json:"redis_key"`
+	Filepath            string             `json:"filepath"`
+	APIStatusCode       int                `json:"api_status_code"`
+	RuntimeSessionID    string             `json:"runtime_session_id"`
+	BusinessPartnerID   *int               `json:"business_partner"`
+	ServiceLabel        string             `json:"service_label"`
+	APIType             string             `json:"api_type"`
+	Message             interface{}        `json:"message"`
+	APISchema           string             `json:"api_schema"`
+	Accepter            []string           `json:"accepter"`
+	Deleted             bool               `json:"deleted"`
+	SQLUpdateResult     *bool              `json:"sql_update_result"`
+	SQLUpdateError      string             `json:"sql_update_error"`
+	SubfuncResult       *bool              `json:"subfunc_result"`
+	SubfuncError        string             `json:"subfunc_error"`
+	ExconfResult        *bool              `json:"exconf_result"`
+	ExconfError         string             `json:"exconf_error"`
+	APIProcessingResult *bool              `json:"api_processing_result"`
+	APIProcessingError  string             `json:"api_processing_error"`
+	ValidationErrors    *[]ValidationError `json:"validation_errors"`
 }
 
 type Message struct {
-	Header       		*Header         	`json:"Header"`
-	Item         		*[]Item         	`json:"Item"`
-	ItemScheduleLine	*[]ItemScheduleLine `json:"ItemScheduleLine"`
-	ProductStock 		*[]ProductStock 	`json:"ProductStock"`
+	Header                      *Header                        `json:"Header"`
+	Item                        *[]Item                        `json:"Item"`
+	ItemScheduleLine            *[]ItemScheduleLine            `json:"ItemScheduleLine"`
+	ProductStock                *[]ProductStock                `json:"ProductStock"`
+	CancellationPolicyViolation *[]CancellationPolicyViolation `json:"CancellationPolicyViolation"`
+	StockReservation            *[]StockReservation            `json:"StockReservation"`
+	CancellationRequest         *CancellationRequest           `json:"CancellationRequest"`
 }
 
 type Header struct {
-	OrderID              int     `json:"OrderID"`
-	HeaderDeliveryStatus *string `json:"HeaderDeliveryStatus"`
-	IsCancelled          *bool   `json:"IsCancelled"`
+	OrderID                int     `json:"OrderID"`
+	HeaderDeliveryStatus   *string `json:"HeaderDeliveryStatus"`
+	HeaderBillingStatus    *string `json:"HeaderBillingStatus"`
+	IsCancelled            *bool   `json:"IsCancelled"`
+	IsMarkedForDeletion    *bool   `json:"IsMarkedForDeletion"`
+	CancellationReasonCode *string `json:"CancellationReasonCode"`
+	CancellationComment    *string `json:"CancellationComment"`
+}
+
+type HeaderPartner struct {
+	OrderID int `json:"OrderID"`
+	Buyer   int `json:"Buyer"`
+	Seller  int `json:"Seller"`
 }
 
 type Item struct {
-	OrderID            int     `json:"OrderID"`
-	OrderItem          int     `json:"OrderItem"`
-	ItemDeliveryStatus *string `json:"ItemDeliveryStatus"`
-	IsCancelled        *bool   `json:"IsCancelled"`
+	OrderID                int     `json:"OrderID"`
+	OrderItem              int     `json:"OrderItem"`
+	ItemDeliveryStatus     *string `json:"ItemDeliveryStatus"`
+	ItemBillingStatus      *string `json:"ItemBillingStatus"`
+	IsCancelled            *bool   `json:"IsCancelled"`
+	IsMarkedForDeletion    *bool   `json:"IsMarkedForDeletion"`
+	CancellationReasonCode *string `json:"CancellationReasonCode"`
+	CancellationComment    *string `json:"CancellationComment"`
 }
 
 type ItemScheduleLine struct {
-	OrderID                                         int     `json:"OrderID"`
-	OrderItem                                       int     `json:"OrderItem"`
-	ScheduleLine                                    int     `json:"ScheduleLine"`
-	Product                                         string  `json:"Product"`
-	StockConfirmationBusinessPartner                int     `json:"StockConfirmationBusinessPartner"`
-	StockConfirmationPlant                          string  `json:"StockConfirmationPlant"`
-	StockConfirmationPlantBatch                     *string `json:"StockConfirmationPlantBatch"`
-	RequestedDeliveryDate                           *string `json:"RequestedDeliveryDate"`
-	ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit float32 `json:"ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit"`
-	IsCancelled                                     *bool   `json:"IsCancelled"`
+	OrderID                                         int       `json:"OrderID"`
+	OrderItem                                       int       `json:"OrderItem"`
+	ScheduleLine                                    int       `json:"ScheduleLine"`
+	Product                                         string    `json:"Product"`
+	StockConfirmationBusinessPartner                int       `json:"StockConfirmationBusinessPartner"`
+	StockConfirmationPlant                          string    `json:"StockConfirmationPlant"`
+	StockConfirmationPlantBatch                     *string   `json:"StockConfirmationPlantBatch"`
+	RequestedDeliveryDate                           *string   `json:"RequestedDeliveryDate"`
+	BaseUnit                                        *string   `json:"BaseUnit"`
+	ScheduleLineOrderQuantityInBaseUnit             *Quantity `json:"ScheduleLineOrderQuantityInBaseUnit"`
+	ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit Quantity  `json:"ConfirmedOrderQuantityByPDTAvailCheckInBaseUnit"`
+	IsCancelled                                     *bool     `json:"IsCancelled"`
+	IsMarkedForDeletion                             *bool     `json:"IsMarkedForDeletion"`
+	CancelledQuantityInBaseUnit                     *Quantity `json:"CancelledQuantityInBaseUnit"`
+	CancellationReasonCode                          *string   `json:"CancellationReasonCode"`
+	CancellationComment                             *string   `json:"CancellationComment"`
 }
 
 type ProductStock struct {
-	Product                      string  `json:"Product"`
-	BusinessPartner              int     `json:"BusinessPartner"`
-	Plant                        string  `json:"Plant"`
-	Batch                        string  `json:"Batch"`
-	ProductStockAvailabilityDate string  `json:"ProductStockAvailabilityDate"`
-	AvailableProductStock        float32 `json:"AvailableProductStock"`
+	Product                      string   `json:"Product"`
+	BusinessPartner              int      `json:"BusinessPartner"`
+	Plant                        string   `json:"Plant"`
+	Batch                        string   `json:"Batch"`
+	ProductStockAvailabilityDate string   `json:"ProductStockAvailabilityDate"`
+	AvailableProductStock        Quantity `json:"AvailableProductStock"`
+}
+
+type StockReservation struct {
+	OrderID                             int               `json:"OrderID"`
+	OrderItem                           int               `json:"OrderItem"`
+	ScheduleLine                        int               `json:"ScheduleLine"`
+	ScheduleLineOrderQuantityInBaseUnit *Quantity         `json:"ScheduleLineOrderQuantityInBaseUnit"`
+	ConfirmedQuantityInBaseUnit         Quantity          `json:"ConfirmedQuantityInBaseUnit"`
+	ShortfallQuantityInBaseUnit         Quantity          `json:"ShortfallQuantityInBaseUnit"`
+	StockAllocation                     []StockAllocation `json:"StockAllocation"`
+}
+
+type StockAllocation struct {
+	Plant                        string   `json:"Plant"`
+	Batch                        *string  `json:"Batch"`
+	ProductStockAvailabilityDate string   `json:"ProductStockAvailabilityDate"`
+	QuantityInBaseUnit           Quantity `json:"QuantityInBaseUnit"`
+}
+
+type CancellationPolicyViolation struct {
+	OrderID   int    `json:"OrderID"`
+	OrderItem *int   `json:"OrderItem"`
+	Rule      string `json:"Rule"`
+	Reason    string `json:"Reason"`
+}
+
+type BulkCancellation struct {
+	Succeeded   int           `json:"Succeeded"`
+	Failed      int           `json:"Failed"`
+	OrderResult []OrderResult `json:"OrderResult"`
+}
+
+type OrderResult struct {
+	OrderID int      `json:"OrderID"`
+	Result  bool     `json:"Result"`
+	Error   string   `json:"Error"`
+	Message *Message `json:"Message"`
+}
+
+type MassCancellation struct {
+	MassCancellationID int                     `json:"MassCancellationID"`
+	Status             string                  `json:"Status"`
+	Error              *string                 `json:"Error"`
+	CreationDateTime   string                  `json:"CreationDateTime"`
+	Matched            int                     `json:"Matched"`
+	MatchedOrderID     []int                   `json:"MatchedOrderID"`
+	Processed          int                     `json:"Processed"`
+	Succeeded          int                     `json:"Succeeded"`
+	Failed             int                     `json:"Failed"`
+	Batch              []MassCancellationBatch `json:"Batch"`
+}
+
+type MassCancellationBatch struct {
+	Batch       int           `json:"Batch"`
+	Succeeded   int           `json:"Succeeded"`
+	Failed      int           `json:"Failed"`
+	OrderResult []OrderResult `json:"OrderResult"`
+}
+
+type ScheduledCancellation struct {
+	ScheduledCancellationID int      `json:"ScheduledCancellationID"`
+	OrderID                 int      `json:"OrderID"`
+	BusinessPartner         int      `json:"BusinessPartner"`
+	Accepter                []string `json:"Accepter"`
+	EffectiveDateTime       string   `json:"EffectiveDateTime"`
+	Status                  string   `json:"Status"`
+	Error                   *string  `json:"Error"`
+	CreationDateTime        string   `json:"CreationDateTime"`
+}
+
+type CancellationRequest struct {
+	CancellationRequestID int      `json:"CancellationRequestID"`
+	OrderID               int      `json:"OrderID"`
+	Buyer                 int      `json:"Buyer"`
+	Seller                int      `json:"Seller"`
+	Accepter              []string `json:"Accepter"`
+	Status                string   `json:"Status"`
+	CreationDateTime      string   `json:"CreationDateTime"`
+}
+
+type DomainEvent struct {
+	EventID          int         `json:"EventID"`
+	EventType        string      `json:"EventType"`
+	RuntimeSessionID string      `json:"runtime_session_id"`
+	BusinessPartner  int         `json:"business_partner"`
+	OrderID          int         `json:"OrderID"`
+	OrderItem        *int        `json:"OrderItem"`
+	ScheduleLine     *int        `json:"ScheduleLine"`
+	Quantity         *Quantity   `json:"Quantity"`
+	Data             interface{} `json:"Data"`
+	OccurredDateTime string      `json:"OccurredDateTime"`
+}
+
+type StockMovement struct {
+	StockMovementID              int      `json:"StockMovementID"`
+	MovementType                 string   `json:"MovementType"`
+	Product                      string   `json:"Product"`
+	BusinessPartner              int      `json:"BusinessPartner"`
+	Plant                        string   `json:"Plant"`
+	Batch                        *string  `json:"Batch"`
+	ProductStockAvailabilityDate string   `json:"ProductStockAvailabilityDate"`
+	Quantity                     Quantity `json:"Quantity"`
+	QuantityBefore               Quantity `json:"QuantityBefore"`
+	QuantityAfter                Quantity `json:"QuantityAfter"`
+	OrderID                      int      `json:"OrderID"`
+	OrderItem                    int      `json:"OrderItem"`
+	ScheduleLine                 int      `json:"ScheduleLine"`
+	RuntimeSessionID             string   `json:"runtime_session_id"`
+	CreationDateTime             string   `json:"CreationDateTime"`
+}
+
+type ValidationError struct {
+	Field   string `json:"Field"`
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
 }
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Cancellation struct {
+	reasonCodes           []string
+	policyRules           map[string]CancellationPolicyRule
+	massBatchSize         int
+	massBatchInterval     int
+	schedulerInterval     int
+	schedulerClaimTimeout int
+	outboxInterval        int
+	// キャンセル取消時の再引当で在庫を探す範囲
+	reservationSearchDays   int
+	reservationOtherBatches bool
+	quantityScales          map[string]int32
+}
+
+// CancellationPolicyRule は、キャンセルを許可するオーダーの状態を表します
+type CancellationPolicyRule struct {
+	AllowedDeliveryStatuses []string `json:"AllowedDeliveryStatuses"`
+	AllowedBillingStatuses  []string `json:"AllowedBillingStatuses"`
+	AllowMarkedForDeletion  bool     `json:"AllowMarkedForDeletion"`
+}
+
+const (
+	defaultPolicyRuleKey = "default"
+)
+
+func newCancellation() *Cancellation {
+	reasonCodes := make([]string, 0)
+	for _, v := range getEnvStrings("CANCELLATION_REASON_CODES") {
+		if v != "" {
+			reasonCodes = append(reasonCodes, v)
+		}
+	}
+	return &Cancellation{
+		reasonCodes:             reasonCodes,
+		policyRules:             getEnvPolicyRules("CANCELLATION_POLICY_RULES"),
+		massBatchSize:           getEnvInt("MASS_CANCELLATION_BATCH_SIZE", 50),
+		massBatchInterval:       getEnvInt("MASS_CANCELLATION_BATCH_INTERVAL_MILLISECONDS", 1000),
+		schedulerInterval:       getEnvInt("SCHEDULED_CANCELLATION_INTERVAL_SECONDS", 60),
+		schedulerClaimTimeout:   getEnvInt("SCHEDULED_CANCELLATION_CLAIM_TIMEOUT_SECONDS", 600),
+		outboxInterval:          getEnvInt("OUTBOX_PUBLISH_INTERVAL_SECONDS", 5),
+		reservationSearchDays:   getEnvInt("REACTIVATION_STOCK_SEARCH_DAYS", 30),
+		reservationOtherBatches: getEnv("REACTIVATION_STOCK_OTHER_BATCHES", "false") == "true",
+		quantityScales:          getEnvQuantityScales("QUANTITY_UNIT_SCALES"),
+	}
+}
+
+// ReasonCodes は、キャンセル理由コードのマスタを返します。空の場合は理由コードを検証しません。
+func (c *Cancellation) ReasonCodes() []string {
+	return c.reasonCodes
+}
+
+// PolicyRule は、ビジネスパートナに設定されたキャンセルポリシーを返します。設定がない場合は default を返します。
+func (c *Cancellation) PolicyRule(businessPartner int) CancellationPolicyRule {
+	if rule, ok := c.policyRules[strconv.Itoa(businessPartner)]; ok {
+		return rule
+	}
+	return c.policyRules[defaultPolicyRuleKey]
+}
+
+// MassBatchSize は、条件指定の一括キャンセルで 1 回にキャンセルするオーダー数です
+func (c *Cancellation) MassBatchSize() int {
+	if c.massBatchSize < 1 {
+		return 1
+	}
+	return c.massBatchSize
+}
+
+// MassBatchInterval は、条件指定の一括キャンセルでバッチ間に空ける時間です
+func (c *Cancellation) MassBatchInterval() time.Duration {
+	return time.Duration(c.massBatchInterval) * time.Millisecond
+}
+
+// SchedulerInterval は、実行日時を過ぎたキャンセル要求を確認する間隔です
+func (c *Cancellation) SchedulerInterval() time.Duration {
+	if c.schedulerInterval < 1 {
+		return time.Second
+	}
+	return time.Duration(c.schedulerInterval) * time.Second
+}
+
+// SchedulerClaimTimeout は、実行中のキャンセル要求を、実行したレプリカが停止したものとみなして再度実行するまでの時間です
+func (c *Cancellation) SchedulerClaimTimeout() time.Duration {
+	if c.schedulerClaimTimeout < 1 {
+		return time.Second
+	}
+	return time.Duration(c.schedulerClaimTimeout) * time.Second
+}
+
+// OutboxPublishInterval は、送信できなかったドメインイベントを再送する間隔です
+func (c *Cancellation) OutboxPublishInterval() time.Duration {
+	if c.outboxInterval < 1 {
+		return time.Second
+	}
+	return time.Duration(c.outboxInterval) * time.Second
+}
+
+// ReservationSearchDays は、キャンセル取消時の再引当で、要求納入日付の在庫が不足する場合に在庫を探す日数です
+// 0 の場合は要求納入日付の在庫のみ、負の場合は日数の上限なしで探します
+func (c *Cancellation) ReservationSearchDays() int {
+	return c.reservationSearchDays
+}
+
+// ReservationOtherBatches は、キャンセル取消時の再引当で、スケジュール行と同じプラントの他のロットの在庫も引き当てるかどうかです
+func (c *Cancellation) ReservationOtherBatches() bool {
+	return c.reservationOtherBatches
+}
+
+// QuantityScale は、基本数量単位の数量の小数桁数です。設定がない場合は false を返します。
+func (c *Cancellation) QuantityScale(baseUnit string) (int32, bool) {
+	scale, ok := c.quantityScales[baseUnit]
+	return scale, ok
+}
+
+func getEnvQuantityScales(key string) map[string]int32 {
+	scales := make(map[string]int32)
+	rawVal := os.Getenv(key)
+	if rawVal == "" {
+		return scales
+	}
+	if err := json.Unmarshal([]byte(rawVal), &scales); err != nil {
+		fmt.Fprintf(os.Stderr, "environment %s required json type: %+v", key, err)
+		return make(map[string]int32)
+	}
+	return scales
+}
+
+func getEnvPolicyRules(key string) map[string]CancellationPolicyRule {
+	rules := map[string]CancellationPolicyRule{
+		defaultPolicyRuleKey: {
+			AllowedDeliveryStatuses: []string{"NP"},
+			AllowedBillingStatuses:  []string{"NP"},
+			AllowMarkedForDeletion:  false,
+		},
+	}
+	rawVal := os.Getenv(key)
+	if rawVal == "" {
+		return rules
+	}
+	val := make(map[string]CancellationPolicyRule)
+	if err := json.Unmarshal([]byte(rawVal), &val); err != nil {
+		fmt.Fprintf(os.Stderr, "environment %s required json type: %+v", key, err)
+		return rules
+	}
+	for k, v := range val {
+		rules[k] = v
+	}
+	return rules
+}
@@ -7,14 +7,18 @@ import (
 )
 
 type Conf struct {
-	RMQ *RMQ
-	DB  *Database
+	RMQ          *RMQ
+	DB           *Database
+	Cancellation *Cancellation
+	Server       *Server
 }
 
 func NewConf() *Conf {
 	return &Conf{
-		RMQ: newRMQ(),
-		DB:  newDatabase(),
+		RMQ:          newRMQ(),
+		DB:           newDatabase(),
+		Cancellation: newCancellation(),
+		Server:       newServer(),
 	}
 }
 
@@ -28,6 +32,9 @@ func getEnv(key, fallback string) string {
 
 func getEnvInt(key string, fallback int) int {
 	rawVal := os.Getenv(key)
+	if rawVal == "" {
+		return fallback
+	}
 	val, err := strconv.Atoi(rawVal)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "environment %s required number type: %+v", key, err)
@@ -5,21 +5,35 @@ import (
 	"os"
 )
 
+const (
+	StoreMySQL  = "mysql"
+	StoreMemory = "memory"
+
+	WriteModeRMQ         = "rmq"
+	WriteModeTransaction = "transaction"
+)
+
 type Database struct {
-	user     string
-	password string
-	dbName   string
-	address  string
-	port     string
+	user      string
+	password  string
+	dbName    string
+	address   string
+	port      string
+	store     string
+	seedFile  string
+	writeMode string
 }
 
 func newDatabase() *Database {
 	return &Database{
-		user:     os.Getenv("MYSQL_USER"),
-		password: os.Getenv("MYSQL_PASSWORD"),
-		dbName:   os.Getenv("DB_NAME"),
-		address:  os.Getenv("DATA_PLATFORM_MASTERS_AND_TRANSACTIONS_MYSQL_KUBE"),
-		port:     os.Getenv("MYSQL_PORT"),
+		user:      os.Getenv("MYSQL_USER"),
+		password:  os.Getenv("MYSQL_PASSWORD"),
+		dbName:    os.Getenv("DB_NAME"),
+		address:   os.Getenv("DATA_PLATFORM_MASTERS_AND_TRANSACTIONS_MYSQL_KUBE"),
+		port:      os.Getenv("MYSQL_PORT"),
+		store:     getEnv("DATA_STORE", StoreMySQL),
+		seedFile:  os.Getenv("DATA_STORE_SEED_FILE"),
+		writeMode: getEnv("SQL_WRITE_MODE", WriteModeRMQ),
 	}
 }
 func (c Database) DSN() string {
@@ -28,3 +42,20 @@ func (c Database) DSN() string {
 		c.user, c.password, c.address, c.port, c.dbName,
 	)
 }
+
+// Store は、オーダー・在庫の読み書き先です
+// memory の場合は MySQL・sql-update-kube に接続せず、メモリ上で読み書きします
+func (c Database) Store() string {
+	return c.store
+}
+
+// SeedFile は、Store が memory の場合に起動時に登録するオーダー・在庫の JSON ファイルです
+func (c Database) SeedFile() string {
+	return c.seedFile
+}
+
+// WriteMode は、Store が mysql の場合の更新方法です
+// rmq の場合は sql-update-kube に 1 行ずつ更新を依頼し、transaction の場合は 1 回のキャンセルを 1 つのトランザクションで直接更新します
+func (c Database) WriteMode() string {
+	return c.writeMode
+}
@@ -4,18 +4,22 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
 func newRMQ() *RMQ {
 	return &RMQ{
-		user:          os.Getenv("RMQ_USER"),
-		pass:          os.Getenv("RMQ_PASS"),
-		addr:          os.Getenv("RMQ_ADDRESS"),
-		port:          os.Getenv("RMQ_PORT"),
-		vhost:         os.Getenv("RMQ_VHOST"),
-		queueFrom:     os.Getenv("RMQ_QUEUE_FROM"),
-		queueToSQL:    getEnvStrings("RMQ_QUEUE_TO_SQL"),
-		queueToExConf: getEnvStrings("RMQ_QUEUE_TO_EX_CONF"),
+		user:           os.Getenv("RMQ_USER"),
+		pass:           os.Getenv("RMQ_PASS"),
+		addr:           os.Getenv("RMQ_ADDRESS"),
+		port:           os.Getenv("RMQ_PORT"),
+		vhost:          os.Getenv("RMQ_VHOST"),
+		queueFrom:      os.Getenv("RMQ_QUEUE_FROM"),
+		queueToSQL:     getEnvStrings("RMQ_QUEUE_TO_SQL"),
+		queueToExConf:  getEnvStrings("RMQ_QUEUE_TO_EX_CONF"),
+		queueToEvents:  getEnvStrings("RMQ_QUEUE_TO_EVENTS"),
+		exconfTimeout:  getEnvInt("EXCONF_TIMEOUT_SECONDS", 30),
+		subfuncTimeout: getEnvInt("SUBFUNC_TIMEOUT_SECONDS", 30),
 		queueToSubFunc: map[string]string{
 			"Headers": os.Getenv("RMQ_QUEUE_TO_HEADERS_SUB_FUNC"),
 			"Items":   os.Getenv("RMQ_QUEUE_TO_ITEMS_SUB_FUNC"),
@@ -35,6 +39,9 @@ type RMQ struct {
 	queueFrom       string
 	queueToSQL      []string
 	queueToExConf   []string
+	queueToEvents   []string
+	exconfTimeout   int
+	subfuncTimeout  int
 	queueToSubFunc  map[string]string
 	queueToResponse string
 
@@ -57,13 +64,38 @@ func (c *RMQ) SessionControlQueue() string {
 func (c *RMQ) QueueToSubFunc() map[string]string {
 	return c.queueToSubFunc
 }
+
+// SubfuncTimeout は、サブファンクションの応答を待つ期限です
+func (c *RMQ) SubfuncTimeout() time.Duration {
+	return time.Duration(c.subfuncTimeout) * time.Second
+}
 func (c *RMQ) QueueToExConf() []string {
-	return c.queueToExConf
+	return nonEmpty(c.queueToExConf)
+}
+
+// ExconfTimeout は、存在性チェックのすべての応答を待つ期限です
+func (c *RMQ) ExconfTimeout() time.Duration {
+	return time.Duration(c.exconfTimeout) * time.Second
+}
+
+// QueueToEvents は、キャンセル・キャンセル取消のドメインイベントの送信先です
+func (c *RMQ) QueueToEvents() []string {
+	return nonEmpty(c.queueToEvents)
 }
 func (c *RMQ) QueueToResponse() string {
 	return c.queueToResponse
 }
 
+func nonEmpty(values []string) []string {
+	val := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" {
+			val = append(val, v)
+		}
+	}
+	return val
+}
+
 func getEnvStrings(key string) []string {
 	rawVal := os.Getenv(key)
 	rawVal = strings.ReplaceAll(rawVal, "\\ ", "$THIS_SECTION_IS_SPACE")
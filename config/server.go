@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type Server struct {
+	port                 int
+	consumerStallTimeout int
+	shutdownTimeout      int
+	workerCount          int
+	authTokens           map[string]int
+	maxBodyBytes         int
+}
+
+func newServer() *Server {
+	return &Server{
+		port:                 getEnvInt("HTTP_PORT", 8080),
+		consumerStallTimeout: getEnvInt("CONSUMER_STALL_TIMEOUT_SECONDS", 300),
+		shutdownTimeout:      getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 60),
+		workerCount:          getEnvInt("WORKER_COUNT", 1),
+		authTokens:           getEnvAuthTokens("HTTP_AUTH_TOKENS"),
+		maxBodyBytes:         getEnvInt("HTTP_MAX_BODY_BYTES", 1<<20),
+	}
+}
+
+func (c *Server) Port() int {
+	return c.port
+}
+
+// ConsumerStallTimeout は、1 件のメッセージの処理がこの時間を超えた場合に受信ループを異常とみなす時間です
+func (c *Server) ConsumerStallTimeout() time.Duration {
+	return time.Duration(c.consumerStallTimeout) * time.Second
+}
+
+// ShutdownTimeout は、停止時に処理中のキャンセルの完了を待つ期限です
+func (c *Server) ShutdownTimeout() time.Duration {
+	return time.Duration(c.shutdownTimeout) * time.Second
+}
+
+// WorkerCount は、RabbitMQ からのメッセージを並行して処理するワーカー数です
+func (c *Server) WorkerCount() int {
+	if c.workerCount < 1 {
+		return 1
+	}
+	return c.workerCount
+}
+
+// AuthTokens は、HTTP でのコールで受け付けるトークンと、トークンごとのビジネスパートナです
+// 空の場合は、HTTP でのコールをすべて拒否します
+func (c *Server) AuthTokens() map[string]int {
+	return c.authTokens
+}
+
+// MaxBodyBytes は、HTTP でのコールで受け付けるリクエストボディの上限です
+func (c *Server) MaxBodyBytes() int64 {
+	if c.maxBodyBytes < 1 {
+		return 1 << 20
+	}
+	return int64(c.maxBodyBytes)
+}
+
+func getEnvAuthTokens(key string) map[string]int {
+	tokens := make(map[string]int)
+	rawVal := os.Getenv(key)
+	if rawVal == "" {
+		return tokens
+	}
+	if err := json.Unmarshal([]byte(rawVal), &tokens); err != nil {
+		fmt.Fprintf(os.Stderr, "environment %s required json type: %+v", key, err)
+		return make(map[string]int)
+	}
+	return tokens
+}
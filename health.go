@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	database "github.com/latonaio/golang-mysql-network-connector"
+	rabbitmq "github.com/latonaio/rabbitmq-golang-client-for-data-platform"
+	"golang.org/x/xerrors"
+)
+
+// consumerState は、RabbitMQ の受信ワーカーが動作しているか、1 件の処理で停止していないかを保持します
+type consumerState struct {
+	mtx        sync.Mutex
+	running    int
+	processing map[int]time.Time
+}
+
+func newConsumerState() *consumerState {
+	return &consumerState{
+		processing: make(map[int]time.Time),
+	}
+}
+
+func (s *consumerState) start() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.running++
+}
+
+func (s *consumerState) stop() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.running--
+}
+
+func (s *consumerState) begin(worker int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.processing[worker] = time.Now()
+}
+
+func (s *consumerState) end(worker int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.processing, worker)
+}
+
+func (s *consumerState) check(stallTimeout time.Duration) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.running == 0 {
+		return xerrors.New("consumer is not running")
+	}
+	for worker, since := range s.processing {
+		if time.Since(since) > stallTimeout {
+			return xerrors.Errorf("consumer worker %d is stalled for %v", worker, time.Since(since))
+		}
+	}
+	return nil
+}
+
+type healthChecker struct {
+	db           *database.Mysql
+	rmq          *rabbitmq.RabbitmqClient
+	consumer     *consumerState
+	stallTimeout time.Duration
+}
+
+// liveness は、受信ループが生きているかのみを確認します
+func (h *healthChecker) liveness(ctx context.Context) map[string]error {
+	return map[string]error{
+		"consumer": h.consumer.check(h.stallTimeout),
+	}
+}
+
+// readiness は、受信ループに加えて MySQL と RabbitMQ への接続を確認します
+func (h *healthChecker) readiness(ctx context.Context) map[string]error {
+	checks := h.liveness(ctx)
+	if h.db != nil {
+		checks["mysql"] = h.db.PingContext(ctx)
+	}
+	checks["rabbitmq"] = h.checkRabbitmq()
+	return checks
+}
+
+func (h *healthChecker) checkRabbitmq() error {
+	ch, err := h.rmq.CreateChannel()
+	if err != nil {
+		return xerrors.Errorf("rabbitmq channel error: %w", err)
+	}
+	return ch.Close()
+}
+
+func healthHandler(check func(ctx context.Context) map[string]error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		status := http.StatusOK
+		res := make(map[string]string)
+		for name, err := range check(ctx) {
+			if err != nil {
+				status = http.StatusServiceUnavailable
+				res[name] = err.Error()
+				continue
+			}
+			res[name] = "ok"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+	}
+}
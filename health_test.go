@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func TestConsumerStateCheck(t *testing.T) {
+	s := newConsumerState()
+	if err := s.check(time.Minute); err == nil {
+		t.Error("consumer without running workers is healthy")
+	}
+
+	s.start()
+	if err := s.check(time.Minute); err != nil {
+		t.Errorf("running consumer is unhealthy: %v", err)
+	}
+	s.begin(1)
+	if err := s.check(time.Minute); err != nil {
+		t.Errorf("consumer processing a message is unhealthy: %v", err)
+	}
+	if err := s.check(0); err == nil {
+		t.Error("consumer processing a message beyond the stall timeout is healthy")
+	}
+	s.end(1)
+	if err := s.check(0); err != nil {
+		t.Errorf("consumer is unhealthy after the message is processed: %v", err)
+	}
+
+	s.stop()
+	if err := s.check(time.Minute); err == nil {
+		t.Error("stopped consumer is healthy")
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks map[string]error
+		want   int
+		body   map[string]string
+	}{
+		{
+			name:   "healthy",
+			checks: map[string]error{"consumer": nil, "mysql": nil},
+			want:   http.StatusOK,
+			body:   map[string]string{"consumer": "ok", "mysql": "ok"},
+		},
+		{
+			name:   "unhealthy",
+			checks: map[string]error{"consumer": nil, "mysql": xerrors.New("connection refused")},
+			want:   http.StatusServiceUnavailable,
+			body:   map[string]string{"consumer": "ok", "mysql": "connection refused"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := healthHandler(func(ctx context.Context) map[string]error {
+				return tt.checks
+			})
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			body := make(map[string]string)
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.body {
+				if body[name] != want {
+					t.Errorf("%s = %q, want %q", name, body[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLivenessChecksOnlyConsumer(t *testing.T) {
+	consumer := newConsumerState()
+	h := &healthChecker{consumer: consumer, stallTimeout: time.Minute}
+
+	w := httptest.NewRecorder()
+	healthHandler(h.liveness)(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status before the consumer starts = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	consumer.start()
+	w = httptest.NewRecorder()
+	healthHandler(h.liveness)(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status of the running consumer = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+}
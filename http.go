@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/subtle"
+	dpfm_api_caller "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Caller"
+	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
+	"data-platform-api-orders-cancels-rmq-kube/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func newHTTPServer(conf *config.Conf, caller *dpfm_api_caller.DPFMAPICaller, health *healthChecker) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cancels", cancelsHandler(caller, conf.Server.AuthTokens(), conf.Server.MaxBodyBytes()))
+	mux.HandleFunc("/healthz", healthHandler(health.liveness))
+	mux.HandleFunc("/readyz", healthHandler(health.readiness))
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", conf.Server.Port()),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// cancelsHandler は、RabbitMQ を経由せずに SDC を受け付け、キャンセル処理の結果を同期的に返します
+// Authorization ヘッダの Bearer トークンで呼び出し元のビジネスパートナを認証し、SDC の business_partner と一致する場合のみ処理します
+func cancelsHandler(caller *dpfm_api_caller.DPFMAPICaller, authTokens map[string]int, maxBodyBytes int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		businessPartner, ok := authenticate(r, authTokens)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cancels"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		var sdc struct {
+			BusinessPartner int `json:"business_partner"`
+		}
+		if err := json.Unmarshal(raw, &sdc); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if sdc.BusinessPartner != businessPartner {
+			http.Error(w, "business_partner does not match the authenticated business partner", http.StatusForbidden)
+			return
+		}
+
+		output, err := httpProcess(caller, raw)
+		if output == nil {
+			http.Error(w, fmt.Sprintf("process error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if output.ValidationErrors != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(output)
+	}
+}
+
+// authenticate は、Bearer トークンに対応するビジネスパートナを返します
+func authenticate(r *http.Request, authTokens map[string]int) (int, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return 0, false
+	}
+	businessPartner, found := 0, false
+	for t, bp := range authTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			businessPartner, found = bp, true
+		}
+	}
+	return businessPartner, found
+}
+
+func httpProcess(caller *dpfm_api_caller.DPFMAPICaller, raw []byte) (output *dpfm_api_output_formatter.SDC, err error) {
+	l := logger.NewLogger()
+	defer recovery(l, &err)
+
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	l.AddHeaderInfo(map[string]interface{}{"runtime_session_id": getSessionID(data)})
+	return process(caller, raw, l)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCancelsHandlerRejectsUnauthenticatedRequests(t *testing.T) {
+	handler := cancelsHandler(nil, map[string]int{"seller-token": 201}, 64)
+	body := `{"business_partner": 201}`
+
+	tests := []struct {
+		name          string
+		authorization string
+		body          string
+		want          int
+	}{
+		{"no token", "", body, http.StatusUnauthorized},
+		{"unknown token", "Bearer buyer-token", body, http.StatusUnauthorized},
+		{"other business partner", "Bearer seller-token", `{"business_partner": 101}`, http.StatusForbidden},
+		{"too large body", "Bearer seller-token", `{"business_partner": 201, "comment": "` + strings.Repeat("x", 64) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/cancels", strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
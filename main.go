@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	dpfm_api_caller "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Caller"
 	dpfm_api_input_reader "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Input_Reader"
 	dpfm_api_output_formatter "data-platform-api-orders-cancels-rmq-kube/DPFM_API_Output_Formatter"
 	"data-platform-api-orders-cancels-rmq-kube/config"
+	"data-platform-api-orders-cancels-rmq-kube/metrics"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/latonaio/golang-logging-library-for-data-platform/logger"
@@ -17,38 +24,191 @@ import (
 func main() {
 	l := logger.NewLogger()
 	conf := config.NewConf()
-	db, err := database.NewMySQL(conf.DB)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	rmq, err := rabbitmq.NewRabbitmqClient(conf.RMQ.URL(), conf.RMQ.QueueFrom(), conf.RMQ.SessionControlQueue(), queueTo(conf), 0)
 	if err != nil {
 		l.Fatal(err.Error())
 	}
-	rmq, err := rabbitmq.NewRabbitmqClient(conf.RMQ.URL(), conf.RMQ.QueueFrom(), conf.RMQ.SessionControlQueue(), conf.RMQ.QueueToSQL(), 0)
+	iter, err := rmq.Iterator()
 	if err != nil {
 		l.Fatal(err.Error())
 	}
-	defer rmq.Close()
-	iter, err := rmq.Iterator()
+
+	db, stores, err := newStores(conf, rmq)
 	if err != nil {
 		l.Fatal(err.Error())
 	}
-	defer rmq.Stop()
 
-	caller := dpfm_api_caller.NewDPFMAPICaller(conf, rmq, db)
+	caller := dpfm_api_caller.NewDPFMAPICaller(conf, rmq, stores)
+
+	consumer := newConsumerState()
+	health := &healthChecker{
+		db:           db,
+		rmq:          rmq,
+		consumer:     consumer,
+		stallTimeout: conf.Server.ConsumerStallTimeout(),
+	}
 
-	for msg := range iter {
-		start := time.Now()
-		err = callProcess(rmq, caller, conf, msg)
-		if err != nil {
-			msg.Fail()
-			continue
+	server := newHTTPServer(conf, caller, health)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			l.Fatal(err.Error())
 		}
-		msg.Success()
-		l.Info("process time %v\n", time.Since(start).Milliseconds())
+	}()
+
+	// 同じオーダー・在庫に対する処理は DPFMAPICaller 内で直列化される
+	consumed := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < conf.Server.WorkerCount(); i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			consume(ctx, worker, rmq, caller, conf, iter, consumer, l)
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(consumed)
+	}()
+
+	// 予約キャンセル・一括キャンセルの実行とドメインイベントの送信
+	backgroundCtx, stopBackground := context.WithCancel(ctx)
+	background := make(chan struct{})
+	bwg := sync.WaitGroup{}
+	for _, run := range []func(context.Context, *logger.Logger){caller.RunScheduledCancellations, caller.RunMassCancellations, caller.RunOutboxPublisher} {
+		bwg.Add(1)
+		go func(run func(context.Context, *logger.Logger)) {
+			defer bwg.Done()
+			run(backgroundCtx, l)
+		}(run)
+	}
+	go func() {
+		bwg.Wait()
+		close(background)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-consumed:
+	}
+	stopBackground()
+	shutdown(conf, rmq, db, server, iter, consumed, background, l)
+}
+
+// consume は、停止の通知を受けるまでメッセージを 1 件ずつ処理します
+// 処理中のメッセージは、停止の通知を受けても完了して応答を返してから終了します
+func consume(ctx context.Context, worker int, rmq *rabbitmq.RabbitmqClient, caller *dpfm_api_caller.DPFMAPICaller, conf *config.Conf, iter <-chan rabbitmq.RabbitmqMessage, consumer *consumerState, l *logger.Logger) {
+	consumer.start()
+	defer consumer.stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-iter:
+			if !ok {
+				return
+			}
+			if ctx.Err() != nil {
+				msg.Requeue()
+				return
+			}
+			consumer.begin(worker)
+			start := time.Now()
+			err := callProcess(rmq, caller, conf, msg)
+			consumer.end(worker)
+			if err != nil {
+				msg.Fail()
+				continue
+			}
+			msg.Success()
+			l.Info("process time %v\n", time.Since(start).Milliseconds())
+		}
+	}
+}
+
+// shutdown は、処理中のキャンセルと予約キャンセル・イベント送信の完了を期限まで待ってから、HTTP サーバ、RabbitMQ、DB の順に終了します
+func shutdown(conf *config.Conf, rmq *rabbitmq.RabbitmqClient, db *database.Mysql, server *http.Server, iter <-chan rabbitmq.RabbitmqMessage, consumed, background <-chan struct{}, l *logger.Logger) {
+	l.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Server.ShutdownTimeout())
+	defer cancel()
+
+wait:
+	for _, done := range []<-chan struct{}{consumed, background} {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			l.Error("shutdown deadline exceeded while waiting for in-flight cancellation")
+			break wait
+		}
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		l.Error("http server shutdown error: %+v", err)
+	}
+
+	// 受信済みで未処理のメッセージはキューに戻す
+	go func() {
+		for msg := range iter {
+			if msg != nil {
+				msg.Requeue()
+			}
+		}
+	}()
+	if err := rmq.Stop(); err != nil {
+		l.Error("rabbitmq stop error: %+v", err)
+	}
+	if err := rmq.Close(); err != nil {
+		l.Error("rabbitmq close error: %+v", err)
+	}
+	if db != nil {
+		db.Close()
 	}
 }
 
+// newStores は、DATA_STORE に応じてオーダー・在庫の読み書き先を作成します
+// memory の場合は MySQL に接続せず、db は nil を返します
+func newStores(conf *config.Conf, rmq *rabbitmq.RabbitmqClient) (*database.Mysql, *dpfm_api_caller.Stores, error) {
+	if conf.DB.Store() == config.StoreMemory {
+		orders := dpfm_api_caller.NewMemoryOrdersRepository()
+		stocks := dpfm_api_caller.NewMemoryStockRepository()
+		if conf.DB.SeedFile() != "" {
+			if err := dpfm_api_caller.LoadMemorySeed(conf.DB.SeedFile(), orders, stocks); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, dpfm_api_caller.NewMemoryStores(orders, stocks), nil
+	}
+
+	db, err := database.NewMySQL(conf.DB)
+	if err != nil {
+		return nil, nil, err
+	}
+	stores, err := dpfm_api_caller.NewMySQLStores(db, rmq, conf.RMQ.QueueToSQL()[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if conf.DB.WriteMode() == config.WriteModeTransaction {
+		stores.Transactor = dpfm_api_caller.NewMySQLTransactor(db)
+	}
+	return db, stores, nil
+}
+
+// queueTo は、起動時に存在を確認する送信先のキューです
+func queueTo(conf *config.Conf) []string {
+	queues := make([]string, 0)
+	queues = append(queues, conf.RMQ.QueueToSQL()...)
+	queues = append(queues, conf.RMQ.QueueToExConf()...)
+	for _, queue := range conf.RMQ.QueueToSubFunc() {
+		queues = append(queues, queue)
+	}
+	queues = append(queues, conf.RMQ.QueueToEvents()...)
+	return queues
+}
+
 func recovery(l *logger.Logger, err *error) {
 	if e := recover(); e != nil {
-		*err = fmt.Errorf("error occurred: %w", e)
+		*err = fmt.Errorf("error occurred: %v", e)
 		l.Error(err)
 		return
 	}
@@ -63,22 +223,46 @@ func callProcess(rmq *rabbitmq.RabbitmqClient, caller *dpfm_api_caller.DPFMAPICa
 	defer recovery(l, &err)
 
 	l.AddHeaderInfo(map[string]interface{}{"runtime_session_id": getSessionID(msg.Data())})
+	output, err := process(caller, msg.Raw(), l)
+	if output != nil {
+		rmq.Send(conf.RMQ.QueueToResponse(), output)
+	}
+	return err
+}
+
+func process(caller *dpfm_api_caller.DPFMAPICaller, raw []byte, l *logger.Logger) (*dpfm_api_output_formatter.SDC, error) {
 	var input dpfm_api_input_reader.SDC
 	var output dpfm_api_output_formatter.SDC
 
-	err = json.Unmarshal(msg.Raw(), &input)
+	err := json.Unmarshal(raw, &input)
 	if err != nil {
 		l.Error(err)
-		return
+		return nil, err
 	}
-	err = json.Unmarshal(msg.Raw(), &output)
+	err = json.Unmarshal(raw, &output)
 	if err != nil {
 		l.Error(err)
-		return
+		return nil, err
 	}
 
 	accepter := getAccepter(&input)
+	// 不正な入力は処理せず、項目ごとの検証エラーを返す
+	if validationErrors := dpfm_api_caller.Validate(accepter, &input); len(validationErrors) != 0 {
+		err := fmt.Errorf("input validation error: %s %s", validationErrors[0].Field, validationErrors[0].Code)
+		l.Error(err)
+		output.APIProcessingResult = getBoolPtr(false)
+		output.APIProcessingError = err.Error()
+		output.ValidationErrors = &validationErrors
+		l.JsonParseOut(output)
+		return &output, err
+	}
+	start := time.Now()
 	res, errs := caller.AsyncCancels(accepter, &input, &output, l)
+	result := "success"
+	if len(errs) != 0 {
+		result = "failure"
+	}
+	metrics.ProcessDuration.WithLabelValues(input.APIType, result).Observe(time.Since(start).Seconds())
 	if len(errs) != 0 {
 		for _, err := range errs {
 			l.Error(err)
@@ -86,16 +270,14 @@ func callProcess(rmq *rabbitmq.RabbitmqClient, caller *dpfm_api_caller.DPFMAPICa
 		output.APIProcessingResult = getBoolPtr(false)
 		output.APIProcessingError = errs[0].Error()
 		output.Message = res
-		rmq.Send(conf.RMQ.QueueToResponse(), output)
-		return errs[0]
+		return &output, errs[0]
 	}
 	output.APIProcessingResult = getBoolPtr(true)
 	output.Message = res
 
 	l.JsonParseOut(output)
-	rmq.Send(conf.RMQ.QueueToResponse(), output)
 
-	return nil
+	return &output, nil
 }
 
 func getAccepter(input *dpfm_api_input_reader.SDC) []string {
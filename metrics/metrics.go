@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "data_platform_api_orders_cancels"
+
+const (
+	OutcomeSuccess    = "success"
+	OutcomeSQLFailure = "sql_failure"
+	OutcomeRMQFailure = "rmq_failure"
+	OutcomeNotFound   = "not_found"
+	OutcomeRejected   = "rejected"
+)
+
+const (
+	StockReleased = "released"
+	StockReserved = "reserved"
+)
+
+var (
+	Cancellations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cancellations_total",
+		Help:      "Number of cancellations processed per accepter and outcome.",
+	}, []string{"accepter", "outcome"})
+
+	ProcessDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "process_duration_seconds",
+		Help:      "End-to-end processing time of a cancellation request.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"api_type", "result"})
+
+	SQLUpdateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sql_update_duration_seconds",
+		Help:      "Round trip time of a SessionKeepRequest to the sql update queue.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"function"})
+
+	StockQuantity = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stock_quantity_total",
+		Help:      "Quantity of product stock released or reserved by committed cancellations.",
+	}, []string{"direction"})
+)
@@ -0,0 +1,40 @@
+package sub_func_complementer
+
+type SDC struct {
+	ConnectionKey    string   `json:"connection_key"`
+	Result           bool     `json:"result"`
+	RedisKey         string   `json:"redis_key"`
+	Filepath         string   `json:"filepath"`
+	APIStatusCode    int      `json:"api_status_code"`
+	RuntimeSessionID string   `json:"runtime_session_id"`
+	BusinessPartner  *int     `json:"business_partner"`
+	ServiceLabel     string   `json:"service_label"`
+	APIType          string   `json:"api_type"`
+	Message          Message  `json:"message"`
+	APISchema        string   `json:"api_schema"`
+	Accepter         []string `json:"accepter"`
+	Deleted          bool     `json:"deleted"`
+	SubfuncResult    *bool    `json:"subfunc_result"`
+	SubfuncError     string   `json:"subfunc_error"`
+}
+
+type Message struct {
+	Header           *Header             `json:"Header"`
+	Item             *[]Item             `json:"Item"`
+	ItemScheduleLine *[]ItemScheduleLine `json:"ItemScheduleLine"`
+}
+
+type Header struct {
+	OrderID int `json:"OrderID"`
+}
+
+type Item struct {
+	OrderID   int `json:"OrderID"`
+	OrderItem int `json:"OrderItem"`
+}
+
+type ItemScheduleLine struct {
+	OrderID      int `json:"OrderID"`
+	OrderItem    int `json:"OrderItem"`
+	ScheduleLine int `json:"ScheduleLine"`
+}